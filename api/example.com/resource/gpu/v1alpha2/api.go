@@ -0,0 +1,145 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha2
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+
+	"Ascend-dra-driver/api/example.com/resource/gpu/v1alpha1"
+)
+
+const (
+	GroupName = "gpu.resource.example.com"
+	Version   = "v1alpha2"
+
+	NpuConfigKind = "NpuConfig"
+)
+
+// Decoder implements a decoder for objects in this API group. It decodes the
+// v1alpha1 GpuConfig kind as well, use ToNpuConfig to convert the result.
+var Decoder runtime.Decoder
+
+// scheme knows the kinds of all versions of the API group and the
+// conversions between them.
+var scheme = runtime.NewScheme()
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NpuConfig holds the set of parameters for configuring an NPU.
+type NpuConfig struct {
+	metav1.TypeMeta `json:",inline"`
+	// Vnpu carves a vNPU out of the allocated chip. The whole chip is used
+	// if it is not set.
+	Vnpu *VnpuSpec `json:"vnpu,omitempty"`
+	// Sharing controls how the containers of a claim share the device.
+	Sharing *NpuSharing `json:"sharing,omitempty"`
+	// Runtime controls what is injected into the containers next to the
+	// device nodes of the NPU.
+	Runtime *RuntimeOptions `json:"runtime,omitempty"`
+}
+
+// VnpuSpec selects the vNPU either by the name of a template supported by
+// the chip (e.g. vir02) or by the resources it needs at least, in which case
+// the smallest fitting template is used.
+type VnpuSpec struct {
+	TemplateName string `json:"templateName,omitempty"`
+	AICore       int    `json:"aicore,omitempty"`
+	MemoryGiB    int    `json:"memoryGiB,omitempty"`
+	AICPU        int    `json:"aicpu,omitempty"`
+	// DVPP requires or excludes the media engines of the chip.
+	DVPP VnpuDvpp `json:"dvpp,omitempty"`
+	// Placement chooses the chip the vNPU is carved from among the chips of
+	// the devices allocated to the claim, so it has no effect on claims with
+	// a single device. The policy of the node applies if it is not set.
+	Placement VnpuPlacement `json:"placement,omitempty"`
+}
+
+// RuntimeOptions controls the container runtime setup of the devices.
+type RuntimeOptions struct {
+	// NoDriverMounts skips mounting the driver libraries and tools of the
+	// host, for images that ship the driver user space themselves. The
+	// device nodes are injected regardless.
+	NoDriverMounts bool `json:"noDriverMounts,omitempty"`
+	// Env holds extra environment variables set in the containers.
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// DefaultNpuConfig provides the default NPU configuration.
+func DefaultNpuConfig() *NpuConfig {
+	return &NpuConfig{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: GroupName + "/" + Version,
+			Kind:       NpuConfigKind,
+		},
+		Sharing: &NpuSharing{
+			Mode: ExclusiveMode,
+		},
+	}
+}
+
+// Normalize updates an NpuConfig config with implied default values based on other settings.
+func (c *NpuConfig) Normalize() error {
+	if c == nil {
+		return fmt.Errorf("config is 'nil'")
+	}
+	if c.Sharing == nil {
+		c.Sharing = &NpuSharing{}
+	}
+	if c.Sharing.Mode == "" {
+		c.Sharing.Mode = ExclusiveMode
+	}
+	return nil
+}
+
+func init() {
+	schemeGroupVersion := schema.GroupVersion{
+		Group:   GroupName,
+		Version: Version,
+	}
+	scheme.AddKnownTypes(schemeGroupVersion,
+		&NpuConfig{},
+	)
+	metav1.AddToGroupVersion(scheme, schemeGroupVersion)
+
+	v1alpha1GroupVersion := schema.GroupVersion{
+		Group:   v1alpha1.GroupName,
+		Version: v1alpha1.Version,
+	}
+	scheme.AddKnownTypes(v1alpha1GroupVersion,
+		&v1alpha1.GpuConfig{},
+	)
+	metav1.AddToGroupVersion(scheme, v1alpha1GroupVersion)
+
+	if err := RegisterConversions(scheme); err != nil {
+		panic(err)
+	}
+
+	Decoder = json.NewSerializerWithOptions(
+		json.DefaultMetaFactory,
+		scheme,
+		scheme,
+		json.SerializerOptions{
+			Pretty: true, Strict: true,
+		},
+	)
+}
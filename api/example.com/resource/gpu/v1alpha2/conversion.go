@@ -0,0 +1,77 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha2
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"Ascend-dra-driver/api/example.com/resource/gpu/v1alpha1"
+)
+
+// RegisterConversions adds the conversions from the v1alpha1 kinds to the
+// scheme.
+func RegisterConversions(s *runtime.Scheme) error {
+	return s.AddConversionFunc((*v1alpha1.GpuConfig)(nil), (*NpuConfig)(nil), func(a, b interface{}, scope conversion.Scope) error {
+		return Convert_v1alpha1_GpuConfig_To_v1alpha2_NpuConfig(a.(*v1alpha1.GpuConfig), b.(*NpuConfig), scope)
+	})
+}
+
+// Convert_v1alpha1_GpuConfig_To_v1alpha2_NpuConfig converts a GpuConfig to an
+// NpuConfig. The GPU time-slice intervals and partition counts have no
+// meaning on Ascend and are dropped: time slicing becomes time sharing, while
+// space partitioning is what the vNPU already does and becomes exclusive use.
+func Convert_v1alpha1_GpuConfig_To_v1alpha2_NpuConfig(in *v1alpha1.GpuConfig, out *NpuConfig, s conversion.Scope) error {
+	out.APIVersion = GroupName + "/" + Version
+	out.Kind = NpuConfigKind
+	// The full-card classes of older releases carry an empty vnpuSpec.
+	out.Vnpu = nil
+	if in.VnpuSpec != nil && in.VnpuSpec.TemplateName != "" {
+		out.Vnpu = &VnpuSpec{TemplateName: in.VnpuSpec.TemplateName}
+	}
+	out.Sharing = nil
+	if in.Sharing != nil {
+		switch in.Sharing.Strategy {
+		case v1alpha1.TimeSlicingStrategy:
+			out.Sharing = &NpuSharing{Mode: TimeSharingMode}
+		case v1alpha1.SpacePartitioningStrategy:
+			out.Sharing = &NpuSharing{Mode: ExclusiveMode}
+		default:
+			return fmt.Errorf("unknown GPU sharing strategy: %v", in.Sharing.Strategy)
+		}
+	}
+	out.Runtime = nil
+	return nil
+}
+
+// ToNpuConfig returns a decoded config as NpuConfig, converting it from an
+// older kind if needed.
+func ToNpuConfig(obj runtime.Object) (*NpuConfig, error) {
+	switch config := obj.(type) {
+	case *NpuConfig:
+		return config, nil
+	case *v1alpha1.GpuConfig:
+		out := &NpuConfig{}
+		if err := scheme.Convert(config, out, nil); err != nil {
+			return nil, fmt.Errorf("error converting GpuConfig: %w", err)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("runtime object is not a recognized configuration")
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestDecodeToNpuConfig(t *testing.T) {
+	tests := map[string]struct {
+		raw      string
+		expected *NpuConfig
+	}{
+		"NpuConfig": {
+			raw: `{"apiVersion": "gpu.resource.example.com/v1alpha2", "kind": "NpuConfig",
+				"vnpu": {"aicore": 4, "memoryGiB": 8}, "sharing": {"mode": "TimeSharing"},
+				"runtime": {"noDriverMounts": true}}`,
+			expected: &NpuConfig{
+				Vnpu:    &VnpuSpec{AICore: 4, MemoryGiB: 8},
+				Sharing: &NpuSharing{Mode: TimeSharingMode},
+				Runtime: &RuntimeOptions{NoDriverMounts: true},
+			},
+		},
+		"GpuConfig with template": {
+			raw: `{"apiVersion": "gpu.resource.example.com/v1alpha1", "kind": "GpuConfig",
+				"vnpuSpec": {"templateName": "vir02"}}`,
+			expected: &NpuConfig{
+				Vnpu: &VnpuSpec{TemplateName: "vir02"},
+			},
+		},
+		"GpuConfig without template": {
+			raw: `{"apiVersion": "gpu.resource.example.com/v1alpha1", "kind": "GpuConfig",
+				"vnpuSpec": {"templateName": ""}}`,
+			expected: &NpuConfig{},
+		},
+		"GpuConfig with time slicing": {
+			raw: `{"apiVersion": "gpu.resource.example.com/v1alpha1", "kind": "GpuConfig",
+				"sharing": {"strategy": "TimeSlicing", "timeSlicingConfig": {"interval": "Long"}}}`,
+			expected: &NpuConfig{
+				Sharing: &NpuSharing{Mode: TimeSharingMode},
+			},
+		},
+		"GpuConfig with space partitioning": {
+			raw: `{"apiVersion": "gpu.resource.example.com/v1alpha1", "kind": "GpuConfig",
+				"sharing": {"strategy": "SpacePartitioning", "spacePartitioningConfig": {"partitionCount": 2}}}`,
+			expected: &NpuConfig{
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			obj, err := runtime.Decode(Decoder, []byte(test.raw))
+			require.NoError(t, err)
+			config, err := ToNpuConfig(obj)
+			require.NoError(t, err)
+			test.expected.APIVersion = GroupName + "/" + Version
+			test.expected.Kind = NpuConfigKind
+			assert.Equal(t, test.expected, config)
+		})
+	}
+}
+
+func TestDecodeRejectsUnknownFields(t *testing.T) {
+	_, err := runtime.Decode(Decoder, []byte(`{"apiVersion": "gpu.resource.example.com/v1alpha2",
+		"kind": "NpuConfig", "vnpuSpec": {"templateName": "vir02"}}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,41 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha2
+
+import "fmt"
+
+const (
+	// DvppRequired selects a template with media engines (DVPP), for video
+	// and image processing on the vNPU.
+	DvppRequired VnpuDvpp = "Required"
+	// DvppNone selects a template without media engines, leaving them to the
+	// other vNPUs of the chip.
+	DvppNone VnpuDvpp = "None"
+)
+
+// VnpuDvpp encodes whether the vNPU needs the media engines (DVPP) of the
+// chip. Templates with and without them are considered if it is not set.
+type VnpuDvpp string
+
+// Validate ensures that VnpuDvpp has a valid value.
+func (d VnpuDvpp) Validate() error {
+	switch d {
+	case DvppRequired, DvppNone:
+		return nil
+	}
+	return fmt.Errorf("unknown vNPU DVPP requirement: %v", d)
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha2
+
+import "fmt"
+
+const (
+	// SchedulerPlacement carves the vNPU from the chip chosen by the scheduler.
+	SchedulerPlacement VnpuPlacement = "Scheduler"
+	// BinpackPlacement carves the vNPU from the most used chip it fits on,
+	// keeping whole chips free for the jobs that need them.
+	BinpackPlacement VnpuPlacement = "Binpack"
+	// SpreadPlacement carves the vNPU from the least used chip it fits on,
+	// spreading the load over the chips.
+	SpreadPlacement VnpuPlacement = "Spread"
+	// SameTemplatePlacement prefers the chips already running vNPUs of the
+	// same template and packs the vNPUs otherwise.
+	SameTemplatePlacement VnpuPlacement = "SameTemplate"
+)
+
+// VnpuPlacement encodes how the chip a vNPU is carved from is chosen among
+// the chips of the devices allocated to the claim for vNPUs that have the
+// model of the chip chosen by the scheduler.
+type VnpuPlacement string
+
+// Validate ensures that VnpuPlacement has a valid value.
+func (p VnpuPlacement) Validate() error {
+	switch p {
+	case SchedulerPlacement, BinpackPlacement, SpreadPlacement, SameTemplatePlacement:
+		return nil
+	}
+	return fmt.Errorf("unknown vNPU placement policy: %v", p)
+}
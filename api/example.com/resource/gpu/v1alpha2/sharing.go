@@ -0,0 +1,41 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha2
+
+const (
+	// ExclusiveMode gives a single container of the claim access to the device.
+	ExclusiveMode NpuSharingMode = "Exclusive"
+	// TimeSharingMode lets all containers of the claim use the device, their
+	// tasks are scheduled on the AICores in turn.
+	TimeSharingMode NpuSharingMode = "TimeSharing"
+)
+
+// NpuSharingMode encodes how the containers of a claim share a device.
+type NpuSharingMode string
+
+// NpuSharing holds the current sharing mode of an NPU.
+type NpuSharing struct {
+	Mode NpuSharingMode `json:"mode"`
+}
+
+// IsTimeSharing checks if the TimeSharing mode is configured.
+func (s *NpuSharing) IsTimeSharing() bool {
+	if s == nil {
+		return false
+	}
+	return s.Mode == TimeSharingMode
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha2
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// reservedEnv are the environment variables set by the driver itself, which
+// the runtime options may not override. RANK_TABLE_FILE points at the HCCL
+// rank table generated for claims with several full NPUs.
+var reservedEnv = []string{"ASCEND_VISIBLE_DEVICES", "ASCEND_RUNTIME_OPTIONS", "ASCEND_VNPU_SPECS", "RANK_TABLE_FILE"}
+
+// Validate ensures that NpuSharingMode has a valid set of values.
+func (m NpuSharingMode) Validate() error {
+	switch m {
+	case ExclusiveMode, TimeSharingMode:
+		return nil
+	}
+	return fmt.Errorf("unknown NPU sharing mode: %v", m)
+}
+
+// Validate ensures that NpuSharing has a valid set of values.
+func (s *NpuSharing) Validate() error {
+	return s.Mode.Validate()
+}
+
+// Validate ensures that VnpuSpec has a valid set of values.
+func (v *VnpuSpec) Validate() error {
+	if v.AICore < 0 {
+		return fmt.Errorf("invalid vNPU AICore count: %v", v.AICore)
+	}
+	if v.MemoryGiB < 0 {
+		return fmt.Errorf("invalid vNPU memory: %vGiB", v.MemoryGiB)
+	}
+	if v.AICPU < 0 {
+		return fmt.Errorf("invalid vNPU AICPU count: %v", v.AICPU)
+	}
+	if v.DVPP != "" {
+		if err := v.DVPP.Validate(); err != nil {
+			return err
+		}
+	}
+	explicit := v.AICore != 0 || v.MemoryGiB != 0 || v.AICPU != 0 || v.DVPP != ""
+	if v.TemplateName != "" && explicit {
+		return fmt.Errorf("vNPU template name and explicit resources are mutually exclusive")
+	}
+	if v.TemplateName == "" && !explicit {
+		return fmt.Errorf("vNPU template name or resources are required")
+	}
+	if v.Placement != "" {
+		return v.Placement.Validate()
+	}
+	return nil
+}
+
+// Validate ensures that RuntimeOptions has a valid set of values.
+func (r *RuntimeOptions) Validate() error {
+	for name := range r.Env {
+		if name == "" || strings.ContainsAny(name, "= ") {
+			return fmt.Errorf("invalid environment variable name: %q", name)
+		}
+		if slices.Contains(reservedEnv, name) {
+			return fmt.Errorf("environment variable %s is set by the driver", name)
+		}
+	}
+	return nil
+}
+
+// Validate ensures that NpuConfig has a valid set of values.
+func (c *NpuConfig) Validate() error {
+	if c.Sharing == nil {
+		return fmt.Errorf("no sharing mode set")
+	}
+	if err := c.Sharing.Validate(); err != nil {
+		return err
+	}
+	if c.Vnpu != nil {
+		if err := c.Vnpu.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Runtime != nil {
+		if err := c.Runtime.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
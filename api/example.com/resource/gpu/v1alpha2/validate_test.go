@@ -0,0 +1,157 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha2
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNpuConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		npuConfig *NpuConfig
+		expected  error
+	}{
+		"empty NpuConfig": {
+			npuConfig: &NpuConfig{},
+			expected:  errors.New("no sharing mode set"),
+		},
+		"unknown NPU sharing mode": {
+			npuConfig: &NpuConfig{
+				Sharing: &NpuSharing{Mode: "TimeSlicing"},
+			},
+			expected: errors.New("unknown NPU sharing mode: TimeSlicing"),
+		},
+		"valid NpuConfig with template": {
+			npuConfig: &NpuConfig{
+				Vnpu:    &VnpuSpec{TemplateName: "vir02"},
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+			},
+			expected: nil,
+		},
+		"valid NpuConfig with resources": {
+			npuConfig: &NpuConfig{
+				Vnpu:    &VnpuSpec{AICore: 4},
+				Sharing: &NpuSharing{Mode: TimeSharingMode},
+			},
+			expected: nil,
+		},
+		"empty NpuConfig.Vnpu": {
+			npuConfig: &NpuConfig{
+				Vnpu:    &VnpuSpec{},
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+			},
+			expected: errors.New("vNPU template name or resources are required"),
+		},
+		"template with resources": {
+			npuConfig: &NpuConfig{
+				Vnpu:    &VnpuSpec{TemplateName: "vir02", MemoryGiB: 6},
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+			},
+			expected: errors.New("vNPU template name and explicit resources are mutually exclusive"),
+		},
+		"negative NpuConfig.Vnpu.AICore": {
+			npuConfig: &NpuConfig{
+				Vnpu:    &VnpuSpec{AICore: -1},
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+			},
+			expected: errors.New("invalid vNPU AICore count: -1"),
+		},
+		"valid NpuConfig with AICPU and DVPP": {
+			npuConfig: &NpuConfig{
+				Vnpu:    &VnpuSpec{AICore: 4, AICPU: 3, DVPP: DvppRequired},
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+			},
+			expected: nil,
+		},
+		"negative NpuConfig.Vnpu.AICPU": {
+			npuConfig: &NpuConfig{
+				Vnpu:    &VnpuSpec{AICPU: -1},
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+			},
+			expected: errors.New("invalid vNPU AICPU count: -1"),
+		},
+		"unknown DVPP requirement": {
+			npuConfig: &NpuConfig{
+				Vnpu:    &VnpuSpec{AICore: 4, DVPP: "Optional"},
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+			},
+			expected: errors.New("unknown vNPU DVPP requirement: Optional"),
+		},
+		"template with DVPP requirement": {
+			npuConfig: &NpuConfig{
+				Vnpu:    &VnpuSpec{TemplateName: "vir04", DVPP: DvppNone},
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+			},
+			expected: errors.New("vNPU template name and explicit resources are mutually exclusive"),
+		},
+		"valid NpuConfig with placement": {
+			npuConfig: &NpuConfig{
+				Vnpu:    &VnpuSpec{TemplateName: "vir02", Placement: BinpackPlacement},
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+			},
+			expected: nil,
+		},
+		"unknown vNPU placement": {
+			npuConfig: &NpuConfig{
+				Vnpu:    &VnpuSpec{TemplateName: "vir02", Placement: "Random"},
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+			},
+			expected: errors.New("unknown vNPU placement policy: Random"),
+		},
+		"valid NpuConfig with runtime options": {
+			npuConfig: &NpuConfig{
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+				Runtime: &RuntimeOptions{
+					NoDriverMounts: true,
+					Env:            map[string]string{"ASCEND_GLOBAL_LOG_LEVEL": "3"},
+				},
+			},
+			expected: nil,
+		},
+		"invalid environment variable name": {
+			npuConfig: &NpuConfig{
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+				Runtime: &RuntimeOptions{Env: map[string]string{"A=B": "3"}},
+			},
+			expected: errors.New(`invalid environment variable name: "A=B"`),
+		},
+		"reserved environment variable": {
+			npuConfig: &NpuConfig{
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+				Runtime: &RuntimeOptions{Env: map[string]string{"ASCEND_VISIBLE_DEVICES": "3"}},
+			},
+			expected: errors.New("environment variable ASCEND_VISIBLE_DEVICES is set by the driver"),
+		},
+		"rank table environment variable": {
+			npuConfig: &NpuConfig{
+				Sharing: &NpuSharing{Mode: ExclusiveMode},
+				Runtime: &RuntimeOptions{Env: map[string]string{"RANK_TABLE_FILE": "/tmp/hccl.json"}},
+			},
+			expected: errors.New("environment variable RANK_TABLE_FILE is set by the driver"),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.npuConfig.Validate()
+			assert.Equal(t, test.expected, err)
+		})
+	}
+}
@@ -0,0 +1,264 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	cgoresource "k8s.io/client-go/kubernetes/typed/resource/v1"
+	"k8s.io/klog/v2"
+
+	"Ascend-dra-driver/pkg/common"
+)
+
+const (
+	// ManagedByLabel marks the DeviceClasses owned by the controller. Only
+	// DeviceClasses carrying it are ever deleted.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "ascend-dra-controller"
+	// ModelLabel and TemplateLabel record the chip model and vNPU template a
+	// DeviceClass was built for.
+	ModelLabel    = DriverDomain + "model"
+	TemplateLabel = DriverDomain + "template"
+)
+
+// Controller creates the DeviceClasses its policy generates for the NPU models
+// and vNPU templates found in the ResourceSlices of the driver, and deletes
+// the DeviceClasses it created once they have not been generated for the GC
+// grace period.
+type Controller struct {
+	client        cgoresource.ResourceV1Interface
+	policy        *DeviceClassPolicy
+	gcGracePeriod time.Duration
+	now           func() time.Time
+
+	// unneededSince records when each managed DeviceClass was first found
+	// without a model or template in the ResourceSlices.
+	unneededSince map[string]time.Time
+}
+
+// NewController returns a Controller managing the DeviceClasses of the policy
+// through client.
+func NewController(client cgoresource.ResourceV1Interface, policy *DeviceClassPolicy, gcGracePeriod time.Duration) *Controller {
+	return &Controller{
+		client:        client,
+		policy:        policy,
+		gcGracePeriod: gcGracePeriod,
+		now:           time.Now,
+		unneededSince: make(map[string]time.Time),
+	}
+}
+
+// Run reconciles the DeviceClasses every interval until ctx is done.
+func (c *Controller) Run(ctx context.Context, interval time.Duration) {
+	klog.Infof("Reconciling DeviceClasses every %v", interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := c.Sync(ctx); err != nil {
+			klog.Errorf("Failed to reconcile DeviceClasses: %v", err)
+		}
+	}, interval)
+}
+
+// Sync creates or updates the DeviceClasses for the models and templates
+// currently published and garbage-collects the managed DeviceClasses no longer
+// needed.
+func (c *Controller) Sync(ctx context.Context) error {
+	models, err := c.publishedModels(ctx)
+	if err != nil {
+		return err
+	}
+	desired := c.policy.DeviceClasses(models)
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(desired)) {
+		if err := c.upsertDeviceClass(ctx, desired[name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := c.collectGarbage(ctx, desired); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d errors, first: %v", len(errs), errs[0])
+	}
+	return nil
+}
+
+// publishedModels returns the vNPU templates of each chip model published by
+// the devices of the driver in all ResourceSlices. Only the ResourceSlices of
+// the driver are listed.
+func (c *Controller) publishedModels(ctx context.Context) (map[string]map[string]common.TemplateResources, error) {
+	resourceSlices, err := c.client.ResourceSlices().List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.driver", DriverName).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ResourceSlices: %v", err)
+	}
+
+	models := make(map[string]map[string]common.TemplateResources)
+	for _, slice := range resourceSlices.Items {
+		if slice.Spec.Driver != DriverName {
+			continue
+		}
+		for _, device := range slice.Spec.Devices {
+			model := stringAttribute(device.Attributes, "model")
+			if model == "" {
+				model = "unknown"
+			}
+			if models[model] == nil {
+				models[model] = make(map[string]common.TemplateResources)
+			}
+			for name, value := range device.Attributes {
+				id := strings.TrimPrefix(string(name), DriverDomain)
+				templateName, ok := strings.CutPrefix(id, common.TemplateAttributePrefix)
+				if !ok || value.StringValue == nil {
+					continue
+				}
+				resources, err := common.ParseTemplateResources(*value.StringValue)
+				if err != nil {
+					klog.Warningf("Ignoring template %s of device %s in ResourceSlice %s: %v", templateName, device.Name, slice.Name, err)
+					continue
+				}
+				models[model][templateName] = resources
+			}
+		}
+	}
+	return models, nil
+}
+
+func stringAttribute(attributes map[resourceapi.QualifiedName]resourceapi.DeviceAttribute, id string) string {
+	for _, name := range []resourceapi.QualifiedName{resourceapi.QualifiedName(DriverDomain + id), resourceapi.QualifiedName(id)} {
+		if value, ok := attributes[name]; ok && value.StringValue != nil {
+			return *value.StringValue
+		}
+	}
+	return ""
+}
+
+// upsertDeviceClass idempotently creates/updates a DeviceClass. DeviceClasses
+// of the same name created before, e.g. by older kubelet plugins, are adopted.
+func (c *Controller) upsertDeviceClass(ctx context.Context, want *resourceapi.DeviceClass) error {
+	classes := c.client.DeviceClasses()
+	got, err := classes.Get(ctx, want.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if _, err := classes.Create(ctx, want, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create DeviceClass %s: %v", want.Name, err)
+		}
+		klog.Infof("Created DeviceClass %s", want.Name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get DeviceClass %s: %v", want.Name, err)
+	}
+
+	if deviceClassEquals(got, want) {
+		return nil
+	}
+	updated := got.DeepCopy()
+	if updated.Labels == nil {
+		updated.Labels = make(map[string]string)
+	}
+	maps.Copy(updated.Labels, want.Labels)
+	if len(want.Annotations) > 0 && updated.Annotations == nil {
+		updated.Annotations = make(map[string]string)
+	}
+	maps.Copy(updated.Annotations, want.Annotations)
+	updated.Spec = want.Spec
+	if _, err := classes.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update DeviceClass %s: %v", want.Name, err)
+	}
+	klog.Infof("Updated DeviceClass %s", want.Name)
+	return nil
+}
+
+// deviceClassEquals reports whether got has the spec, the labels and the
+// annotations of want. Labels and annotations added by others are kept.
+func deviceClassEquals(got, want *resourceapi.DeviceClass) bool {
+	for key, value := range want.Labels {
+		if got.Labels[key] != value {
+			return false
+		}
+	}
+	for key, value := range want.Annotations {
+		if got.Annotations[key] != value {
+			return false
+		}
+	}
+	gotSpec, _ := json.Marshal(got.Spec)
+	wantSpec, _ := json.Marshal(want.Spec)
+	return string(gotSpec) == string(wantSpec)
+}
+
+// collectGarbage deletes the managed DeviceClasses that have not been desired
+// for the GC grace period. The grace period keeps the DeviceClasses of chips
+// whose devices are briefly absent from the ResourceSlices, e.g. while their
+// kubelet plugin restarts.
+func (c *Controller) collectGarbage(ctx context.Context, desired map[string]*resourceapi.DeviceClass) error {
+	classes := c.client.DeviceClasses()
+	managed, err := classes.List(ctx, metav1.ListOptions{LabelSelector: ManagedByLabel + "=" + ManagedByValue})
+	if err != nil {
+		return fmt.Errorf("failed to list DeviceClasses: %v", err)
+	}
+
+	now := c.now()
+	existing := make(map[string]bool, len(managed.Items))
+	for _, class := range managed.Items {
+		existing[class.Name] = true
+		if _, ok := desired[class.Name]; ok {
+			delete(c.unneededSince, class.Name)
+			continue
+		}
+		since, ok := c.unneededSince[class.Name]
+		if !ok {
+			klog.Infof("DeviceClass %s is no longer needed, deleting it in %v", class.Name, c.gcGracePeriod)
+			c.unneededSince[class.Name] = now
+			since = now
+		}
+		if now.Sub(since) < c.gcGracePeriod {
+			continue
+		}
+		if err := classes.Delete(ctx, class.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete DeviceClass %s: %v", class.Name, err)
+		}
+		klog.Infof("Deleted DeviceClass %s", class.Name)
+		delete(c.unneededSince, class.Name)
+	}
+	for name := range c.unneededSince {
+		if !existing[name] {
+			delete(c.unneededSince, name)
+		}
+	}
+	return nil
+}
+
+// toSafeModelName removes extra characters from model and converts to lowercase
+func toSafeModelName(model string) string {
+	model = strings.ReplaceAll(model, " ", "-")
+	model = strings.ReplaceAll(model, "/", "-")
+	return strings.ToLower(model)
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+)
+
+func testSlice(name, driver string, devices ...resourceapi.Device) *resourceapi.ResourceSlice {
+	return &resourceapi.ResourceSlice{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       resourceapi.ResourceSliceSpec{Driver: driver, Devices: devices},
+	}
+}
+
+func testDevice(name, model string, templates map[string]string) resourceapi.Device {
+	attributes := map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{
+		DriverDomain + "model": {StringValue: ptr.To(model)},
+		DriverDomain + "type":  {StringValue: ptr.To("NPU")},
+	}
+	for template, value := range templates {
+		attributes[resourceapi.QualifiedName(DriverDomain+"vnpu_"+template)] = resourceapi.DeviceAttribute{StringValue: ptr.To(value)}
+	}
+	return resourceapi.Device{Name: name, Attributes: attributes}
+}
+
+func classNames(t *testing.T, client *fake.Clientset) []string {
+	classes, err := client.ResourceV1().DeviceClasses().List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	var names []string
+	for _, class := range classes.Items {
+		names = append(names, class.Name)
+	}
+	return names
+}
+
+func TestSync(t *testing.T) {
+	templates310P3 := map[string]string{"vir01": "aicore=1,memoryGiB=3", "vir02": "aicore=2,memoryGiB=6"}
+	tests := map[string]struct {
+		objects  []runtime.Object
+		expected []string
+	}{
+		"no slices": {},
+		"full card and templates": {
+			objects: []runtime.Object{
+				testSlice("node-1", DriverName, testDevice("npu-0", "310P3", templates310P3)),
+			},
+			expected: []string{
+				"npu-310p3-aicore1.example.com",
+				"npu-310p3-aicore2.example.com",
+				"npu-310p3-mem3.example.com",
+				"npu-310p3-mem6.example.com",
+				"npu-310p3.example.com",
+			},
+		},
+		"templates per model": {
+			objects: []runtime.Object{
+				testSlice("node-1", DriverName, testDevice("npu-0", "310P3", map[string]string{"vir01": "aicore=1,memoryGiB=3"})),
+				testSlice("node-2", DriverName, testDevice("npu-0", "910B3", map[string]string{"vir10_3c_32g": "aicore=10,memoryGiB=32"})),
+			},
+			expected: []string{
+				"npu-310p3-aicore1.example.com",
+				"npu-310p3-mem3.example.com",
+				"npu-310p3.example.com",
+				"npu-910b3-aicore10.example.com",
+				"npu-910b3-mem32.example.com",
+				"npu-910b3.example.com",
+			},
+		},
+		"other drivers and invalid templates are ignored": {
+			objects: []runtime.Object{
+				testSlice("gpu", "gpu.example.com", testDevice("gpu-0", "A100", nil)),
+				testSlice("node-1", DriverName, testDevice("npu-0", "310P3", map[string]string{"vir01": "aicore=one"})),
+			},
+			expected: []string{"npu-310p3.example.com"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(test.objects...)
+			controller := NewController(client.ResourceV1(), DefaultDeviceClassPolicy(), time.Minute)
+			require.NoError(t, controller.Sync(context.Background()))
+			assert.ElementsMatch(t, test.expected, classNames(t, client))
+
+			class, err := client.ResourceV1().DeviceClasses().Get(context.Background(), "npu-310p3-mem3.example.com", metav1.GetOptions{})
+			if err != nil {
+				return
+			}
+			assert.Equal(t, ManagedByValue, class.Labels[ManagedByLabel])
+			assert.Equal(t, "vir01", class.Labels[TemplateLabel])
+			assert.JSONEq(t, `{"apiVersion": "gpu.resource.example.com/v1alpha2", "kind": "NpuConfig", "vnpu": {"templateName": "vir01"}}`,
+				string(class.Spec.Config[0].Opaque.Parameters.Raw))
+		})
+	}
+}
+
+func TestSyncListsSlicesOfDriver(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	require.NoError(t, NewController(client.ResourceV1(), DefaultDeviceClassPolicy(), time.Minute).Sync(context.Background()))
+
+	var selectors []string
+	for _, action := range client.Actions() {
+		if list, ok := action.(k8stesting.ListAction); ok && action.GetResource().Resource == "resourceslices" {
+			selectors = append(selectors, list.GetListRestrictions().Fields.String())
+		}
+	}
+	assert.Equal(t, []string{"spec.driver=" + DriverName}, selectors)
+}
+
+func TestSyncAdoptsExistingDeviceClass(t *testing.T) {
+	existing := &resourceapi.DeviceClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "npu-310p3.example.com", Labels: map[string]string{"team": "ml"}},
+		Spec: resourceapi.DeviceClassSpec{
+			Selectors: []resourceapi.DeviceSelector{{CEL: &resourceapi.CELDeviceSelector{Expression: "true"}}},
+		},
+	}
+	client := fake.NewSimpleClientset(existing, testSlice("node-1", DriverName, testDevice("npu-0", "310P3", nil)))
+	require.NoError(t, NewController(client.ResourceV1(), DefaultDeviceClassPolicy(), time.Minute).Sync(context.Background()))
+
+	class, err := client.ResourceV1().DeviceClasses().Get(context.Background(), existing.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "ml", class.Labels["team"])
+	assert.Equal(t, ManagedByValue, class.Labels[ManagedByLabel])
+	assert.Equal(t, `device.attributes["npu.example.com"].model == "310P3" && device.attributes["npu.example.com"].type == "NPU"`,
+		class.Spec.Selectors[0].CEL.Expression)
+}
+
+func TestSyncCollectsGarbage(t *testing.T) {
+	unmanaged := &resourceapi.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "npu-custom.example.com"}}
+	slice := testSlice("node-1", DriverName, testDevice("npu-0", "310P3", map[string]string{"vir01": "aicore=1,memoryGiB=3"}))
+	client := fake.NewSimpleClientset(unmanaged, slice)
+	ctx := context.Background()
+
+	now := time.Now()
+	controller := NewController(client.ResourceV1(), DefaultDeviceClassPolicy(), 10*time.Minute)
+	controller.now = func() time.Time { return now }
+	require.NoError(t, controller.Sync(ctx))
+	require.Len(t, classNames(t, client), 4)
+
+	// The node is gone: the classes are kept for the grace period.
+	require.NoError(t, client.ResourceV1().ResourceSlices().Delete(ctx, slice.Name, metav1.DeleteOptions{}))
+	require.NoError(t, controller.Sync(ctx))
+	now = now.Add(5 * time.Minute)
+	require.NoError(t, controller.Sync(ctx))
+	assert.Len(t, classNames(t, client), 4)
+
+	// The node came back in time: the timer is reset.
+	_, err := client.ResourceV1().ResourceSlices().Create(ctx, slice, metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Sync(ctx))
+	assert.Empty(t, controller.unneededSince)
+
+	require.NoError(t, client.ResourceV1().ResourceSlices().Delete(ctx, slice.Name, metav1.DeleteOptions{}))
+	require.NoError(t, controller.Sync(ctx))
+	now = now.Add(10 * time.Minute)
+	require.NoError(t, controller.Sync(ctx))
+	assert.Equal(t, []string{unmanaged.Name}, classNames(t, client))
+	assert.Empty(t, controller.unneededSince)
+}
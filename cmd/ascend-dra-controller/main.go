@@ -0,0 +1,148 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	draclient "k8s.io/dynamic-resource-allocation/client"
+	"k8s.io/dynamic-resource-allocation/leaderelection"
+	"k8s.io/klog/v2"
+
+	"Ascend-dra-driver/pkg/flags"
+)
+
+const (
+	DriverName       = "npu.example.com"
+	DriverDomainName = "npu.example.com"
+	DriverDomain     = "npu.example.com/"
+
+	// LeaseName is the name of the Lease the controller replicas elect their
+	// leader with.
+	LeaseName = "ascend-dra-controller"
+)
+
+type Flags struct {
+	kubeClientConfig flags.KubeClientConfig
+	loggingConfig    *flags.LoggingConfig
+
+	namespace      string
+	leaderElection bool
+	resyncInterval time.Duration
+	gcGracePeriod  time.Duration
+	policyFile     string
+}
+
+func main() {
+	if err := newApp().Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func newApp() *cli.App {
+	flags := &Flags{
+		loggingConfig: flags.NewLoggingConfig(),
+	}
+	cliFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "namespace",
+			Usage:       "Namespace of the Lease used for leader election. Defaults to the namespace of the pod.",
+			Destination: &flags.namespace,
+			EnvVars:     []string{"NAMESPACE"},
+		},
+		&cli.BoolFlag{
+			Name:        "leader-election",
+			Usage:       "Elect a leader among the controller replicas, so that only one of them manages the DeviceClasses.",
+			Value:       true,
+			Destination: &flags.leaderElection,
+			EnvVars:     []string{"LEADER_ELECTION"},
+		},
+		&cli.DurationFlag{
+			Name:        "resync-interval",
+			Usage:       "Interval at which the DeviceClasses are reconciled with the devices published in the ResourceSlices.",
+			Value:       30 * time.Second,
+			Destination: &flags.resyncInterval,
+			EnvVars:     []string{"RESYNC_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:        "gc-grace-period",
+			Usage:       "How long a managed DeviceClass must have been without any matching model or template in the ResourceSlices before it is deleted.",
+			Value:       10 * time.Minute,
+			Destination: &flags.gcGracePeriod,
+			EnvVars:     []string{"GC_GRACE_PERIOD"},
+		},
+		&cli.StringFlag{
+			Name:        "device-class-policy",
+			Usage:       "Path to a JSON or YAML file declaring the DeviceClasses to generate per chip model and vNPU template. Defaults to a full-card class per model and a memory and an AICORE class per template.",
+			Destination: &flags.policyFile,
+			EnvVars:     []string{"DEVICE_CLASS_POLICY"},
+		},
+	}
+	cliFlags = append(cliFlags, flags.kubeClientConfig.Flags()...)
+	cliFlags = append(cliFlags, flags.loggingConfig.Flags()...)
+
+	app := &cli.App{
+		Name:  "ascend-dra-controller",
+		Usage: "ascend-dra-controller manages the DeviceClasses of the NPU models and vNPU templates published by the kubelet plugins.",
+		Flags: cliFlags,
+		Before: func(c *cli.Context) error {
+			return flags.loggingConfig.Apply()
+		},
+		Action: func(c *cli.Context) error {
+			ctx, cancel := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+			clientSets, err := flags.kubeClientConfig.NewClientSets()
+			if err != nil {
+				return fmt.Errorf("create client: %v", err)
+			}
+
+			policy := DefaultDeviceClassPolicy()
+			if flags.policyFile != "" {
+				policy, err = LoadDeviceClassPolicy(flags.policyFile)
+				if err != nil {
+					return err
+				}
+			}
+
+			controller := NewController(draclient.New(clientSets.Core), policy, flags.gcGracePeriod)
+			run := func(ctx context.Context) {
+				controller.Run(ctx, flags.resyncInterval)
+			}
+			if !flags.leaderElection {
+				run(ctx)
+				return nil
+			}
+
+			options := []leaderelection.Option{leaderelection.Context(ctx)}
+			if flags.namespace != "" {
+				options = append(options, leaderelection.Namespace(flags.namespace))
+			}
+			klog.Infof("Waiting to acquire the %s lease", LeaseName)
+			return leaderelection.New(clientSets.Core, LeaseName, run, options...).Run()
+		},
+	}
+
+	return app
+}
@@ -0,0 +1,345 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	configapi "Ascend-dra-driver/api/example.com/resource/gpu/v1alpha2"
+	"Ascend-dra-driver/pkg/common"
+)
+
+// DeviceClassTarget selects what a DeviceClass rule generates DeviceClasses for.
+type DeviceClassTarget string
+
+const (
+	// TargetModel generates one DeviceClass per chip model, selecting its
+	// full cards.
+	TargetModel DeviceClassTarget = "model"
+	// TargetTemplate generates one DeviceClass per chip model and vNPU
+	// template, selecting the devices with at least the AICORE and memory of
+	// the template.
+	TargetTemplate DeviceClassTarget = "template"
+	// TargetMemory generates one DeviceClass per chip model and vNPU template,
+	// selecting the devices with at least the memory of the template.
+	TargetMemory DeviceClassTarget = "memory"
+	// TargetAICore generates one DeviceClass per chip model and vNPU template,
+	// selecting the devices with at least the AICORE of the template.
+	TargetAICore DeviceClassTarget = "aicore"
+)
+
+// DefaultDomain is the domain the names of the DeviceClasses end in by default.
+const DefaultDomain = "example.com"
+
+// DeviceClassPolicy declares which DeviceClasses the controller generates
+// from the models and templates found in the ResourceSlices.
+type DeviceClassPolicy struct {
+	// Domain replaces the {domain} placeholder in the names of the classes.
+	Domain  string            `json:"domain,omitempty"`
+	Classes []DeviceClassRule `json:"classes"`
+}
+
+// DeviceClassRule generates DeviceClasses for every chip model, or every chip
+// model and vNPU template, it applies to.
+//
+// Name, Selectors, Config and the values of Labels and Annotations may contain
+// the placeholders {driver}, {domain}, {model}, {template}, {aicore},
+// {memoryGiB} and {aicpu}. In names and labels, the model and the template are
+// lowercased and spaces and slashes replaced by dashes.
+type DeviceClassRule struct {
+	Name string            `json:"name"`
+	For  DeviceClassTarget `json:"for"`
+	// Models and Templates restrict the rule to the listed chip models and
+	// vNPU templates. The rule applies to all of them if empty.
+	Models    []string `json:"models,omitempty"`
+	Templates []string `json:"templates,omitempty"`
+	// Selectors are CEL expressions the devices must match in addition to
+	// the built-in selector of the target.
+	Selectors   []string          `json:"selectors,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Config replaces the default opaque config of the classes, an NpuConfig
+	// carving a vNPU of the template, or the full card for TargetModel.
+	Config *runtime.RawExtension `json:"config,omitempty"`
+}
+
+// DefaultDeviceClassPolicy returns the policy generating a full-card
+// DeviceClass per model and a memory and an AICORE DeviceClass per model and
+// template.
+func DefaultDeviceClassPolicy() *DeviceClassPolicy {
+	return &DeviceClassPolicy{
+		Domain: DefaultDomain,
+		Classes: []DeviceClassRule{
+			{Name: "npu-{model}.{domain}", For: TargetModel},
+			{Name: "npu-{model}-mem{memoryGiB}.{domain}", For: TargetMemory},
+			{Name: "npu-{model}-aicore{aicore}.{domain}", For: TargetAICore},
+		},
+	}
+}
+
+// LoadDeviceClassPolicy reads a JSON or YAML DeviceClass policy file. It
+// replaces the default policy as a whole.
+func LoadDeviceClassPolicy(path string) (*DeviceClassPolicy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DeviceClass policy file: %v", err)
+	}
+	policy := &DeviceClassPolicy{}
+	if err := yaml.UnmarshalStrict(content, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse DeviceClass policy file %s: %v", path, err)
+	}
+	if policy.Domain == "" {
+		policy.Domain = DefaultDomain
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid DeviceClass policy file %s: %v", path, err)
+	}
+	return policy, nil
+}
+
+// Validate checks the rules of the policy. Whether the rendered names are
+// valid can only be checked when the classes are generated.
+func (p *DeviceClassPolicy) Validate() error {
+	for i, rule := range p.Classes {
+		if rule.Name == "" {
+			return fmt.Errorf("class %d: name is required", i)
+		}
+		switch rule.For {
+		case TargetModel:
+			if len(rule.Templates) > 0 {
+				return fmt.Errorf("class %s: templates cannot be restricted for target %s", rule.Name, rule.For)
+			}
+		case TargetTemplate, TargetMemory, TargetAICore:
+		default:
+			return fmt.Errorf("class %s: unknown target %q", rule.Name, rule.For)
+		}
+		for key := range rule.Labels {
+			if key == ManagedByLabel || key == ModelLabel || key == TemplateLabel {
+				return fmt.Errorf("class %s: label %s is reserved", rule.Name, key)
+			}
+			if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+				return fmt.Errorf("class %s: invalid label %s: %s", rule.Name, key, strings.Join(errs, ", "))
+			}
+		}
+		for key := range rule.Annotations {
+			if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+				return fmt.Errorf("class %s: invalid annotation %s: %s", rule.Name, key, strings.Join(errs, ", "))
+			}
+		}
+		if rule.Config != nil {
+			if _, err := decodeNpuConfig(rule.Config.Raw); err != nil {
+				return fmt.Errorf("class %s: %v", rule.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeNpuConfig decodes, normalizes and validates an opaque config the same
+// way the kubelet plugin does.
+func decodeNpuConfig(raw []byte) (*configapi.NpuConfig, error) {
+	decoded, err := runtime.Decode(configapi.Decoder, raw)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding config: %v", err)
+	}
+	config, err := configapi.ToNpuConfig(decoded)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Normalize(); err != nil {
+		return nil, fmt.Errorf("error normalizing config: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("error validating config: %v", err)
+	}
+	return config, nil
+}
+
+// DeviceClasses builds the DeviceClasses for the given models and templates,
+// keyed by name. If several rules, models or templates yield the same name,
+// the first rule and the model and template sorting first win.
+func (p *DeviceClassPolicy) DeviceClasses(models map[string]map[string]common.TemplateResources) map[string]*resourceapi.DeviceClass {
+	desired := make(map[string]*resourceapi.DeviceClass)
+	for _, rule := range p.Classes {
+		for _, model := range slices.Sorted(maps.Keys(models)) {
+			if len(rule.Models) > 0 && !slices.Contains(rule.Models, model) {
+				continue
+			}
+			if rule.For == TargetModel {
+				p.addDeviceClass(desired, rule, model, "", common.TemplateResources{})
+				continue
+			}
+			templates := models[model]
+			for _, templateName := range slices.Sorted(maps.Keys(templates)) {
+				if len(rule.Templates) > 0 && !slices.Contains(rule.Templates, templateName) {
+					continue
+				}
+				p.addDeviceClass(desired, rule, model, templateName, templates[templateName])
+			}
+		}
+	}
+	return desired
+}
+
+func (p *DeviceClassPolicy) addDeviceClass(desired map[string]*resourceapi.DeviceClass, rule DeviceClassRule,
+	model, templateName string, resources common.TemplateResources) {
+	values := map[string]string{
+		"driver":    DriverDomainName,
+		"domain":    p.Domain,
+		"model":     model,
+		"template":  templateName,
+		"aicore":    strconv.Itoa(resources.AICore),
+		"memoryGiB": strconv.Itoa(resources.MemoryGiB),
+		"aicpu":     strconv.Itoa(resources.AICPU),
+	}
+	nameValues := maps.Clone(values)
+	nameValues["model"] = toSafeModelName(model)
+	nameValues["template"] = toSafeModelName(templateName)
+	name := expand(rule.Name, nameValues)
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		klog.Warningf("Skipping DeviceClass %q of model %s: %s", name, model, strings.Join(errs, ", "))
+		return
+	}
+	if _, ok := desired[name]; ok {
+		return
+	}
+
+	class, err := buildDeviceClass(name, rule, model, templateName, values, nameValues)
+	if err != nil {
+		klog.Warningf("Skipping DeviceClass %s: %v", name, err)
+		return
+	}
+	desired[name] = class
+}
+
+// expand replaces the {key} placeholders in s.
+func expand(s string, values map[string]string) string {
+	for key, value := range values {
+		s = strings.ReplaceAll(s, "{"+key+"}", value)
+	}
+	return s
+}
+
+// targetSelector returns the built-in CEL selector of the target.
+func targetSelector(target DeviceClassTarget, values map[string]string) string {
+	switch target {
+	case TargetModel:
+		return expand(`device.attributes["{driver}"].model == "{model}" && device.attributes["{driver}"].type == "NPU"`, values)
+	case TargetTemplate:
+		return expand(`device.attributes["{driver}"].aicore >= {aicore} && device.attributes["{driver}"].memory >= {memoryGiB} && device.attributes["{driver}"].model == "{model}"`, values)
+	case TargetMemory:
+		return expand(`device.attributes["{driver}"].memory >= {memoryGiB} && device.attributes["{driver}"].model == "{model}"`, values)
+	default:
+		return expand(`device.attributes["{driver}"].aicore >= {aicore} && device.attributes["{driver}"].model == "{model}"`, values)
+	}
+}
+
+// buildDeviceClass generates the DeviceClass of a rule for a model and, unless
+// the rule targets models, a template.
+func buildDeviceClass(name string, rule DeviceClassRule, model, templateName string,
+	values, nameValues map[string]string) (*resourceapi.DeviceClass, error) {
+	var raw []byte
+	if rule.Config != nil {
+		raw = []byte(expand(string(rule.Config.Raw), values))
+		if _, err := decodeNpuConfig(raw); err != nil {
+			return nil, err
+		}
+	} else {
+		paramObj := map[string]interface{}{
+			"apiVersion": configapi.GroupName + "/" + configapi.Version,
+			"kind":       configapi.NpuConfigKind,
+		}
+		// Full-card classes carve no vNPU.
+		if templateName != "" {
+			paramObj["vnpu"] = map[string]interface{}{
+				"templateName": templateName,
+			}
+		}
+		var err error
+		raw, err = json.Marshal(paramObj)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	labels := make(map[string]string, len(rule.Labels)+3)
+	for key, value := range rule.Labels {
+		value = expand(value, nameValues)
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid value %q of label %s: %s", value, key, strings.Join(errs, ", "))
+		}
+		labels[key] = value
+	}
+	labels[ManagedByLabel] = ManagedByValue
+	labels[ModelLabel] = toSafeModelName(model)
+	if templateName != "" {
+		labels[TemplateLabel] = templateName
+	}
+
+	var annotations map[string]string
+	if len(rule.Annotations) > 0 {
+		annotations = make(map[string]string, len(rule.Annotations))
+		for key, value := range rule.Annotations {
+			annotations[key] = expand(value, values)
+		}
+	}
+
+	selectors := []resourceapi.DeviceSelector{{
+		CEL: &resourceapi.CELDeviceSelector{Expression: targetSelector(rule.For, values)},
+	}}
+	for _, selector := range rule.Selectors {
+		selectors = append(selectors, resourceapi.DeviceSelector{
+			CEL: &resourceapi.CELDeviceSelector{Expression: expand(selector, values)},
+		})
+	}
+
+	return &resourceapi.DeviceClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: resourceapi.DeviceClassSpec{
+			Selectors: selectors,
+			Config: []resourceapi.DeviceClassConfiguration{
+				{
+					DeviceConfiguration: resourceapi.DeviceConfiguration{
+						Opaque: &resourceapi.OpaqueDeviceConfiguration{
+							Driver: DriverName,
+							Parameters: runtime.RawExtension{
+								Raw: raw,
+							},
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
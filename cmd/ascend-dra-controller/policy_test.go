@@ -0,0 +1,155 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Ascend-dra-driver/pkg/common"
+)
+
+const testPolicy = `
+domain: npu.corp.example
+classes:
+- name: "{model}-full.{domain}"
+  for: model
+  labels:
+    catalog: curated
+  annotations:
+    description: "Full {model} card"
+- name: "{model}-small.{domain}"
+  for: template
+  templates: [vir02, vir05_1c_16g]
+  selectors:
+  - device.attributes["{driver}"].health == "Healthy"
+- name: "{model}-medium.{domain}"
+  for: template
+  models: [910B3]
+  templates: [vir10_3c_32g]
+  config:
+    apiVersion: gpu.resource.example.com/v1alpha2
+    kind: NpuConfig
+    vnpu:
+      templateName: "{template}"
+    sharing:
+      mode: TimeSharing
+`
+
+func writeTestPolicy(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadDeviceClassPolicy(t *testing.T) {
+	policy, err := LoadDeviceClassPolicy(writeTestPolicy(t, testPolicy))
+	require.NoError(t, err)
+	assert.Equal(t, "npu.corp.example", policy.Domain)
+	require.Len(t, policy.Classes, 3)
+	assert.Equal(t, TargetTemplate, policy.Classes[1].For)
+
+	policy, err = LoadDeviceClassPolicy(writeTestPolicy(t, `classes: [{name: "npu-{model}.{domain}", for: model}]`))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultDomain, policy.Domain)
+
+	invalid := map[string]string{
+		"unknown field":       `classes: [{name: a, for: model, selector: "true"}]`,
+		"missing name":        `classes: [{for: model}]`,
+		"unknown target":      `classes: [{name: a, for: card}]`,
+		"templates of model":  `classes: [{name: a, for: model, templates: [vir01]}]`,
+		"reserved label":      `classes: [{name: a, for: model, labels: {app.kubernetes.io/managed-by: me}}]`,
+		"invalid label":       `classes: [{name: a, for: model, labels: {"a b": c}}]`,
+		"invalid config":      `classes: [{name: a, for: model, config: {apiVersion: gpu.resource.example.com/v1alpha2, kind: NpuConfig, sharing: {mode: Spatial}}}]`,
+		"config of other API": `classes: [{name: a, for: model, config: {apiVersion: v1, kind: ConfigMap}}]`,
+	}
+	for name, content := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadDeviceClassPolicy(writeTestPolicy(t, content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDeviceClassPolicy(t *testing.T) {
+	policy, err := LoadDeviceClassPolicy(writeTestPolicy(t, testPolicy))
+	require.NoError(t, err)
+	models := map[string]map[string]common.TemplateResources{
+		"310P3": {
+			"vir01": {AICore: 1, MemoryGiB: 3},
+			"vir02": {AICore: 2, MemoryGiB: 6},
+		},
+		"910B3": {
+			"vir05_1c_16g": {AICore: 5, MemoryGiB: 16},
+			"vir10_3c_32g": {AICore: 10, MemoryGiB: 32},
+		},
+		"Invalid_Model": {},
+	}
+
+	classes := policy.DeviceClasses(models)
+	names := make([]string, 0, len(classes))
+	for name := range classes {
+		names = append(names, name)
+	}
+	assert.ElementsMatch(t, []string{
+		"310p3-full.npu.corp.example",
+		"310p3-small.npu.corp.example",
+		"910b3-full.npu.corp.example",
+		"910b3-small.npu.corp.example",
+		"910b3-medium.npu.corp.example",
+	}, names)
+
+	full := classes["310p3-full.npu.corp.example"]
+	assert.Equal(t, map[string]string{
+		"catalog":      "curated",
+		ManagedByLabel: ManagedByValue,
+		ModelLabel:     "310p3",
+	}, full.Labels)
+	assert.Equal(t, map[string]string{"description": "Full 310P3 card"}, full.Annotations)
+	assert.JSONEq(t, `{"apiVersion": "gpu.resource.example.com/v1alpha2", "kind": "NpuConfig"}`,
+		string(full.Spec.Config[0].Opaque.Parameters.Raw))
+
+	small := classes["910b3-small.npu.corp.example"]
+	require.Len(t, small.Spec.Selectors, 2)
+	assert.Equal(t, `device.attributes["npu.example.com"].aicore >= 5 && device.attributes["npu.example.com"].memory >= 16 && device.attributes["npu.example.com"].model == "910B3"`,
+		small.Spec.Selectors[0].CEL.Expression)
+	assert.Equal(t, `device.attributes["npu.example.com"].health == "Healthy"`, small.Spec.Selectors[1].CEL.Expression)
+	assert.Equal(t, "vir05_1c_16g", small.Labels[TemplateLabel])
+	assert.JSONEq(t, `{"apiVersion": "gpu.resource.example.com/v1alpha2", "kind": "NpuConfig", "vnpu": {"templateName": "vir05_1c_16g"}}`,
+		string(small.Spec.Config[0].Opaque.Parameters.Raw))
+
+	medium := classes["910b3-medium.npu.corp.example"]
+	assert.JSONEq(t, `{"apiVersion": "gpu.resource.example.com/v1alpha2", "kind": "NpuConfig", "vnpu": {"templateName": "vir10_3c_32g"}, "sharing": {"mode": "TimeSharing"}}`,
+		string(medium.Spec.Config[0].Opaque.Parameters.Raw))
+}
+
+func TestDefaultDeviceClassPolicy(t *testing.T) {
+	classes := DefaultDeviceClassPolicy().DeviceClasses(map[string]map[string]common.TemplateResources{
+		// Templates with the same memory share the memory class, the
+		// template sorting first wins.
+		"310P3": {"vir02": {AICore: 2, MemoryGiB: 6}, "vir02_1c": {AICore: 1, MemoryGiB: 6}},
+	})
+	require.Len(t, classes, 4)
+	assert.Equal(t, "vir02", classes["npu-310p3-mem6.example.com"].Labels[TemplateLabel])
+	assert.Equal(t, "vir02_1c", classes["npu-310p3-aicore1.example.com"].Labels[TemplateLabel])
+	assert.Equal(t, `device.attributes["npu.example.com"].model == "310P3" && device.attributes["npu.example.com"].type == "NPU"`,
+		classes["npu-310p3.example.com"].Spec.Selectors[0].CEL.Expression)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/utils/ptr"
+
+	configapi "Ascend-dra-driver/api/example.com/resource/gpu/v1alpha2"
+)
+
+// capacityAttributes are the device attributes publishing a ChipCapacity, in
+// the order of ChipCapacity.resources.
+var capacityAttributes = []resourceapi.QualifiedName{
+	DriverDomain + "aicore",
+	DriverDomain + "memory",
+	DriverDomain + "aicpu",
+	DriverDomain + "vpc",
+	DriverDomain + "venc",
+	DriverDomain + "vdec",
+	DriverDomain + "jpegd",
+	DriverDomain + "jpege",
+	DriverDomain + "pngd",
+}
+
+// resources lists the amounts of the capacity, AICore and memory first.
+func (c ChipCapacity) resources() []int {
+	return []int{
+		c.AICore, c.MemoryGiB, c.AICPU,
+		c.Media.VPC, c.Media.VENC, c.Media.VDEC, c.Media.JPEGD, c.Media.JPEGE, c.Media.PNGD,
+	}
+}
+
+// fits reports whether a vNPU of the given size can be carved from what is
+// left of a chip. Resources the chip does not report, i.e. that are zero in
+// its total capacity, are not checked.
+func (c ChipCapacity) fits(tpl ChipCapacity, total ChipCapacity) bool {
+	left, needed, reported := c.resources(), tpl.resources(), total.resources()
+	for i := range left {
+		if reported[i] > 0 && needed[i] > left[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// remainingCapacity returns what is left of a chip once the vNPUs carved from
+// it, allocated or adopted, are taken away. A slice allocated without a
+// template holds everything that was left on the chip.
+func remainingCapacity(npu *PhysicalNpuState) ChipCapacity {
+	remaining := npu.Capacity
+	for _, slice := range slices.Concat(npu.AllocatedSlices, npu.AvailableSlices) {
+		if slice.TemplateName == "" {
+			if slice.Allocated {
+				return ChipCapacity{}
+			}
+			continue
+		}
+		// vNPUs of templates the chip model no longer lists cannot be
+		// accounted for, dcmi rejects the vNPUs that do not fit anymore.
+		if tpl, ok := npu.Templates[slice.TemplateName]; ok {
+			remaining = remaining.sub(templateCapacity(tpl))
+		}
+	}
+	return remaining
+}
+
+// setCapacityAttributes publishes the resources of a device. AICore and memory
+// are always published, the AICPUs and media engines only if the chip
+// reports them.
+func setCapacityAttributes(attributes map[resourceapi.QualifiedName]resourceapi.DeviceAttribute, c ChipCapacity, total ChipCapacity) {
+	values, reported := c.resources(), total.resources()
+	for i, name := range capacityAttributes {
+		if i >= 2 && reported[i] == 0 {
+			delete(attributes, name)
+			continue
+		}
+		attributes[name] = resourceapi.DeviceAttribute{IntValue: ptr.To(int64(values[i]))}
+	}
+}
+
+// VnpuRequirements are what a vNPU must offer at least. The zero value asks
+// for the full card.
+type VnpuRequirements struct {
+	// Min holds the minimum amount of each resource.
+	Min  ChipCapacity
+	DVPP configapi.VnpuDvpp
+}
+
+// specRequirements returns the requirements of a VnpuSpec that does not name
+// a template.
+func specRequirements(spec *configapi.VnpuSpec) VnpuRequirements {
+	return VnpuRequirements{
+		Min:  ChipCapacity{AICore: spec.AICore, MemoryGiB: spec.MemoryGiB, AICPU: spec.AICPU},
+		DVPP: spec.DVPP,
+	}
+}
+
+// templateRequirements asks for a vNPU at least as large as a template in
+// every resource, which only the template itself meets exactly.
+func templateRequirements(tpl *VnpuTemplate) VnpuRequirements {
+	return VnpuRequirements{Min: templateCapacity(tpl)}
+}
+
+func (r VnpuRequirements) isZero() bool {
+	return r == VnpuRequirements{}
+}
+
+// metBy reports whether a template offers everything that is required.
+func (r VnpuRequirements) metBy(tpl *VnpuTemplate) bool {
+	offered, needed := templateCapacity(tpl).resources(), r.Min.resources()
+	for i := range needed {
+		if offered[i] < needed[i] {
+			return false
+		}
+	}
+	switch r.DVPP {
+	case configapi.DvppRequired:
+		return tpl.Attributes.Media.total() > 0
+	case configapi.DvppNone:
+		return tpl.Attributes.Media.total() == 0
+	}
+	return true
+}
+
+// excess is how many AICores, GiB of memory and AICPUs a template offers
+// beyond the requirements.
+func (r VnpuRequirements) excess(tpl *VnpuTemplate) int {
+	return tpl.Attributes.AICORE - r.Min.AICore +
+		tpl.Attributes.Memory - r.Min.MemoryGiB +
+		tpl.Attributes.AICPU - r.Min.AICPU
+}
+
+func (r VnpuRequirements) String() string {
+	s := fmt.Sprintf("AICORE>=%d, Memory>=%dGB", r.Min.AICore, r.Min.MemoryGiB)
+	for i, value := range r.Min.resources() {
+		if i >= 2 && value > 0 {
+			name := strings.TrimPrefix(string(capacityAttributes[i]), DriverDomain)
+			s += fmt.Sprintf(", %s>=%d", strings.ToUpper(name), value)
+		}
+	}
+	if r.DVPP != "" {
+		s += fmt.Sprintf(", DVPP %s", r.DVPP)
+	}
+	return s
+}
+
+// total returns the number of media engines of all kinds.
+func (m MediaEngines) total() int {
+	return m.VPC + m.VENC + m.VDEC + m.JPEGD + m.JPEGE + m.PNGD
+}
+
+// bestTemplate returns the smallest template that can still be carved from
+// the chip and meets the requirements, or nil if there is none. Among equally
+// small templates, the one with the fewest media engines is preferred so that
+// they are left to the vNPUs that need them.
+func bestTemplate(npu *PhysicalNpuState, requirements VnpuRequirements) *VnpuTemplate {
+	var best *VnpuTemplate
+	for _, name := range slices.Sorted(maps.Keys(npu.SupportTemplates)) {
+		tpl := npu.SupportTemplates[name]
+		if !requirements.metBy(tpl) {
+			continue
+		}
+		if best == nil || cmp.Or(
+			cmp.Compare(requirements.excess(tpl), requirements.excess(best)),
+			cmp.Compare(tpl.Attributes.Media.total(), best.Attributes.Media.total()),
+		) < 0 {
+			best = tpl
+		}
+	}
+	return best
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1"
+
+	configapi "Ascend-dra-driver/api/example.com/resource/gpu/v1alpha2"
+)
+
+const packingTestInventory = `
+templates:
+- {name: vir01, aicore: 1, memoryGiB: 3, aicpu: 1, jpegd: 1}
+- {name: vir02, aicore: 2, memoryGiB: 6, aicpu: 2, jpegd: 3}
+- {name: vir04, aicore: 4, memoryGiB: 12, aicpu: 4, jpegd: 4}
+devices:
+- logicID: 0
+  phyID: 0
+  chipName: 310P3
+  aicore: 8
+  memoryGiB: 24
+  aicpu: 8
+  jpegd: 10
+`
+
+func TestChipCapacityFits(t *testing.T) {
+	total := ChipCapacity{AICore: 8, MemoryGiB: 24, AICPU: 8, Media: MediaEngines{JPEGD: 10}}
+	testCases := map[string]struct {
+		left     ChipCapacity
+		tpl      ChipCapacity
+		expected bool
+	}{
+		"fits": {
+			left:     ChipCapacity{AICore: 4, MemoryGiB: 12, AICPU: 4, Media: MediaEngines{JPEGD: 4}},
+			tpl:      ChipCapacity{AICore: 4, MemoryGiB: 12, AICPU: 4, Media: MediaEngines{JPEGD: 4}},
+			expected: true,
+		},
+		"not enough memory": {
+			left: ChipCapacity{AICore: 4, MemoryGiB: 6, AICPU: 4, Media: MediaEngines{JPEGD: 4}},
+			tpl:  ChipCapacity{AICore: 2, MemoryGiB: 12},
+		},
+		"not enough media engines": {
+			left: ChipCapacity{AICore: 4, MemoryGiB: 12, AICPU: 4, Media: MediaEngines{JPEGD: 2}},
+			tpl:  ChipCapacity{AICore: 2, MemoryGiB: 6, AICPU: 2, Media: MediaEngines{JPEGD: 3}},
+		},
+		"resources the chip does not report": {
+			left:     ChipCapacity{AICore: 2, MemoryGiB: 6},
+			tpl:      ChipCapacity{AICore: 2, MemoryGiB: 6, Media: MediaEngines{VPC: 1}},
+			expected: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.left.fits(tc.tpl, total))
+		})
+	}
+}
+
+func TestVnpuPacking(t *testing.T) {
+	state, _ := newTestDeviceState(t, packingTestInventory)
+	npu := state.vnpuManager.PhysicalNpus["npu-0-0"]
+	attributes := state.allocatable["npu-0-0"].Attributes
+	assert.Equal(t, int64(8), *attributes[DriverDomain+"aicore"].IntValue)
+	assert.Equal(t, int64(24), *attributes[DriverDomain+"memory"].IntValue)
+	assert.Equal(t, int64(10), *attributes[DriverDomain+"jpegd"].IntValue)
+	assert.NotContains(t, attributes, resourceapi.QualifiedName(DriverDomain+"vpc"))
+	assert.Equal(t, "aicore=2,memoryGiB=6,aicpu=2", *attributes[DriverDomain+"vnpu_vir02"].StringValue)
+
+	_, err := state.Prepare(newTestClaim(t, "uid-1", "vir04", "npu-0-0"))
+	require.NoError(t, err)
+	require.Contains(t, state.allocatable, "npu-0-1")
+	attributes = state.allocatable["npu-0-1"].Attributes
+	assert.Equal(t, int64(4), *attributes[DriverDomain+"aicore"].IntValue)
+	assert.Equal(t, int64(12), *attributes[DriverDomain+"memory"].IntValue)
+	assert.Equal(t, int64(4), *attributes[DriverDomain+"aicpu"].IntValue)
+	assert.Equal(t, int64(6), *attributes[DriverDomain+"jpegd"].IntValue)
+	assert.ElementsMatch(t, []string{"vir01", "vir02", "vir04"}, templateNames(npu.SupportTemplates))
+
+	_, err = state.Prepare(newTestClaim(t, "uid-2", "vir02", "npu-0-1"))
+	require.NoError(t, err)
+	require.Contains(t, state.allocatable, "npu-0-2")
+	attributes = state.allocatable["npu-0-2"].Attributes
+	assert.Equal(t, int64(2), *attributes[DriverDomain+"aicore"].IntValue)
+	assert.Equal(t, int64(6), *attributes[DriverDomain+"memory"].IntValue)
+	assert.Equal(t, int64(3), *attributes[DriverDomain+"jpegd"].IntValue)
+	assert.ElementsMatch(t, []string{"vir01", "vir02"}, templateNames(npu.SupportTemplates))
+
+	// The chip is used up, no remainder is offered anymore.
+	_, err = state.Prepare(newTestClaim(t, "uid-3", "vir02", "npu-0-2"))
+	require.NoError(t, err)
+	assert.Empty(t, npu.AvailableSlices)
+	assert.Empty(t, npu.SupportTemplates)
+
+	// Releasing a vNPU offers its resources again.
+	require.NoError(t, state.Unprepare("uid-2"))
+	require.Len(t, npu.AvailableSlices, 1)
+	remainder := npu.AvailableSlices[0].SliceID
+	require.Contains(t, state.allocatable, remainder)
+	assert.Equal(t, int64(2), *state.allocatable[remainder].Attributes[DriverDomain+"aicore"].IntValue)
+	assert.ElementsMatch(t, []string{"vir01", "vir02"}, templateNames(npu.SupportTemplates))
+}
+
+func TestVnpuPackingMediaEngines(t *testing.T) {
+	state, _ := newTestDeviceState(t, packingTestInventory)
+	npu := state.vnpuManager.PhysicalNpus["npu-0-0"]
+
+	// Three vir02 take 9 of the 10 JPEG decoders, the fourth one does not fit
+	// although AICore and memory are left.
+	device := "npu-0-0"
+	for _, uid := range []string{"uid-1", "uid-2", "uid-3"} {
+		_, err := state.Prepare(newTestClaim(t, uid, "vir02", device))
+		require.NoError(t, err)
+		require.NotEmpty(t, npu.AvailableSlices)
+		device = npu.AvailableSlices[0].SliceID
+	}
+	assert.ElementsMatch(t, []string{"vir01"}, templateNames(npu.SupportTemplates))
+	_, err := state.vnpuManager.AllocateSlice(device, VnpuRequirements{Min: ChipCapacity{AICore: 2, MemoryGiB: 6}}, "", nil)
+	assert.EqualError(t, err, "no partition scheme found that meets the requirements: AICORE>=2, Memory>=6GB")
+}
+
+func templateNames(templates map[string]*VnpuTemplate) []string {
+	var names []string
+	for name := range templates {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestBestTemplate(t *testing.T) {
+	media := MediaEngines{VPC: 3, VENC: 1, VDEC: 3, JPEGD: 4, JPEGE: 2, PNGD: 8}
+	npu := &PhysicalNpuState{SupportTemplates: map[string]*VnpuTemplate{
+		"vir02":          {Name: "vir02", Attributes: VnpuTemplateAttribute{AICORE: 2, Memory: 6, AICPU: 2, Media: MediaEngines{VPC: 1}}},
+		"vir04":          {Name: "vir04", Attributes: VnpuTemplateAttribute{AICORE: 4, Memory: 12, AICPU: 4, Media: media}},
+		"vir04_3c":       {Name: "vir04_3c", Attributes: VnpuTemplateAttribute{AICORE: 4, Memory: 12, AICPU: 3, Media: media}},
+		"vir04_3c_ndvpp": {Name: "vir04_3c_ndvpp", Attributes: VnpuTemplateAttribute{AICORE: 4, Memory: 12, AICPU: 3}},
+		"vir04_4c_dvpp":  {Name: "vir04_4c_dvpp", Attributes: VnpuTemplateAttribute{AICORE: 4, Memory: 12, AICPU: 4, Media: MediaEngines{VPC: 12, VENC: 3, VDEC: 12, JPEGD: 16, JPEGE: 8, PNGD: 24}}},
+	}}
+	testCases := map[string]struct {
+		requirements VnpuRequirements
+		expected     string
+	}{
+		"smallest template": {
+			requirements: VnpuRequirements{Min: ChipCapacity{AICore: 1}},
+			expected:     "vir02",
+		},
+		"fewest media engines among equally small templates": {
+			requirements: VnpuRequirements{Min: ChipCapacity{AICore: 4, MemoryGiB: 8}},
+			expected:     "vir04_3c_ndvpp",
+		},
+		"AICPUs": {
+			requirements: VnpuRequirements{Min: ChipCapacity{AICore: 4, AICPU: 4}},
+			expected:     "vir04",
+		},
+		"DVPP required": {
+			requirements: VnpuRequirements{Min: ChipCapacity{AICore: 3}, DVPP: configapi.DvppRequired},
+			expected:     "vir04_3c",
+		},
+		"DVPP required only": {
+			requirements: VnpuRequirements{DVPP: configapi.DvppRequired},
+			expected:     "vir02",
+		},
+		"template with more media engines": {
+			requirements: templateRequirements(npu.SupportTemplates["vir04_4c_dvpp"]),
+			expected:     "vir04_4c_dvpp",
+		},
+		"no template without DVPP": {
+			requirements: VnpuRequirements{Min: ChipCapacity{AICPU: 4}, DVPP: configapi.DvppNone},
+		},
+		"too large": {
+			requirements: VnpuRequirements{Min: ChipCapacity{AICore: 8}},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tpl := bestTemplate(npu, tc.requirements)
+			if tc.expected == "" {
+				assert.Nil(t, tpl)
+				return
+			}
+			require.NotNil(t, tpl)
+			assert.Equal(t, tc.expected, tpl.Name)
+		})
+	}
+}
+
+func TestVnpuRequirementsString(t *testing.T) {
+	requirements := VnpuRequirements{
+		Min:  ChipCapacity{AICore: 4, MemoryGiB: 8, AICPU: 3, Media: MediaEngines{JPEGD: 2}},
+		DVPP: configapi.DvppRequired,
+	}
+	assert.Equal(t, "AICORE>=4, Memory>=8GB, AICPU>=3, JPEGD>=2, DVPP Required", requirements.String())
+}
+
+func TestPrepareWithVnpuRequirements(t *testing.T) {
+	state, backend := newTestDeviceState(t, packingTestInventory)
+	config := configapi.DefaultNpuConfig()
+	config.Vnpu = &configapi.VnpuSpec{AICore: 1, AICPU: 2, DVPP: configapi.DvppRequired}
+
+	_, err := state.Prepare(newTestClaimWithConfig(t, "uid-1", config, "npu-0-0"))
+	require.NoError(t, err)
+
+	info, err := backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	require.Len(t, info.VDevInfo, 1)
+	assert.Equal(t, "vir02", info.VDevInfo[0].QueryInfo.Name)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/klog/v2"
+)
+
+// supportedResourceAPIVersions are the versions of the resource.k8s.io API
+// the plugin can talk to, newest first. The objects are always handled as v1
+// in the plugin and converted by the client of the dynamic-resource-allocation
+// library, which picks the version itself and falls back to the older versions
+// in this order.
+var supportedResourceAPIVersions = []string{"v1", "v1beta2", "v1beta1"}
+
+// checkResourceAPI verifies that the API server serves one of the versions of
+// the resource.k8s.io API supported by the plugin, so that the plugin fails at
+// startup if DRA is not enabled in the cluster.
+func checkResourceAPI(client discovery.DiscoveryInterface) error {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return fmt.Errorf("failed to discover API groups: %v", err)
+	}
+
+	var served []string
+	for _, group := range groups.Groups {
+		if group.Name != resourceapi.GroupName {
+			continue
+		}
+		for _, version := range group.Versions {
+			served = append(served, version.Version)
+		}
+	}
+	for _, version := range supportedResourceAPIVersions {
+		if slices.Contains(served, version) {
+			klog.Infof("The API server serves %s/%s, served versions: %v", resourceapi.GroupName, version, served)
+			return nil
+		}
+	}
+	return fmt.Errorf("the API server serves none of the %s versions %v, served versions: %v",
+		resourceapi.GroupName, supportedResourceAPIVersions, served)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckResourceAPI(t *testing.T) {
+	tests := map[string]struct {
+		groupVersions []string
+		expectedErr   bool
+	}{
+		"v1":               {groupVersions: []string{"resource.k8s.io/v1beta1", "resource.k8s.io/v1beta2", "resource.k8s.io/v1"}},
+		"v1beta2":          {groupVersions: []string{"resource.k8s.io/v1beta1", "resource.k8s.io/v1beta2"}},
+		"v1beta1":          {groupVersions: []string{"resource.k8s.io/v1alpha3", "resource.k8s.io/v1beta1"}},
+		"only v1alpha3":    {groupVersions: []string{"resource.k8s.io/v1alpha3"}, expectedErr: true},
+		"DRA not enabled":  {groupVersions: []string{"v1", "apps/v1"}, expectedErr: true},
+		"other group name": {groupVersions: []string{"resource.example.com/v1"}, expectedErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			discovery := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+			for _, groupVersion := range test.groupVersions {
+				discovery.Resources = append(discovery.Resources, &metav1.APIResourceList{GroupVersion: groupVersion})
+			}
+
+			err := checkResourceAPI(discovery)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
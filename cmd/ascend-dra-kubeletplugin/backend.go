@@ -0,0 +1,40 @@
+package main
+
+import (
+	"huawei.com/npu-exporter/v5/devmanager"
+	npuCommon "huawei.com/npu-exporter/v5/devmanager/common"
+)
+
+// NpuBackend is the subset of the NPU management library used by the driver.
+// It covers device listing, chip info and topology, device IPs, health and
+// error codes, telemetry, virtual-device info (which carries the AICore and memory totals
+// of a chip) and vNPU create/destroy, so that discovery and the prepare path
+// can run against either real hardware or a simulated inventory.
+type NpuBackend interface {
+	GetDeviceList() (int32, []int32, error)
+	GetChipInfo(logicID int32) (*npuCommon.ChipInfo, error)
+	GetPhysicIDFromLogicID(logicID int32) (int32, error)
+	GetCardIDDeviceID(logicID int32) (int32, int32, error)
+	GetDeviceIPAddress(logicID int32) (string, error)
+	GetPCIeBusInfo(logicID int32) (string, error)
+	GetDeviceHealth(logicID int32) (uint32, error)
+	GetDeviceAllErrorCode(logicID int32) (int32, []int64, error)
+	GetDeviceTemperature(logicID int32) (int32, error)
+	GetDevicePowerInfo(logicID int32) (float32, error)
+	GetDeviceUtilizationRate(logicID int32, deviceType npuCommon.DeviceType) (uint32, error)
+	GetDeviceHbmInfo(logicID int32) (*npuCommon.HbmInfo, error)
+	GetVirtualDeviceInfo(logicID int32) (npuCommon.VirtualDevInfo, error)
+	CreateVirtualDevice(logicID int32, vDevInfo npuCommon.CgoCreateVDevRes) (npuCommon.CgoCreateVDevOut, error)
+	DestroyVirtualDevice(logicID int32, vDevID uint32) error
+}
+
+var _ NpuBackend = &devmanager.DeviceManager{}
+
+// NewDcmiBackend initializes the dcmi based devmanager for the real hardware.
+func NewDcmiBackend() (NpuBackend, error) {
+	mgr, err := devmanager.AutoInit("")
+	if err != nil {
+		return nil, err
+	}
+	return mgr, nil
+}
@@ -17,7 +17,8 @@ const (
 )
 
 type CDIHandler struct {
-	cache *cdiapi.Cache
+	cache    *cdiapi.Cache
+	profiles CDIProfiles
 }
 
 func NewCDIHandler(config *Config) (*CDIHandler, error) {
@@ -27,8 +28,13 @@ func NewCDIHandler(config *Config) (*CDIHandler, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to create a new CDI cache: %w", err)
 	}
+	profiles := config.cdiProfiles
+	if profiles == nil {
+		profiles = DefaultCDIProfiles()
+	}
 	handler := &CDIHandler{
-		cache: cache,
+		cache:    cache,
+		profiles: profiles,
 	}
 
 	return handler, nil
@@ -67,11 +73,24 @@ func (cdi *CDIHandler) CreateCommonSpecFile() error {
 func (cdi *CDIHandler) CreateClaimSpecFile(claimUID string, devices PreparedDevices) error {
 	specName := cdiapi.GenerateTransientSpecName(cdiVendor, cdiClass, claimUID)
 	var merged cdispec.ContainerEdits
+	// Device nodes and mounts shared by the chips of a claim are added once.
+	deviceNodes := make(map[string]bool)
+	mounts := make(map[string]bool)
 	for _, d := range devices {
 		merged.Env = append(merged.Env, d.ContainerEdits.Env...)
-		merged.DeviceNodes = append(merged.DeviceNodes, d.ContainerEdits.DeviceNodes...)
+		for _, node := range d.ContainerEdits.DeviceNodes {
+			if !deviceNodes[node.Path] {
+				deviceNodes[node.Path] = true
+				merged.DeviceNodes = append(merged.DeviceNodes, node)
+			}
+		}
 		merged.Hooks = append(merged.Hooks, d.ContainerEdits.Hooks...)
-		merged.Mounts = append(merged.Mounts, d.ContainerEdits.Mounts...)
+		for _, mount := range d.ContainerEdits.Mounts {
+			if !mounts[mount.ContainerPath] {
+				mounts[mount.ContainerPath] = true
+				merged.Mounts = append(merged.Mounts, mount)
+			}
+		}
 	}
 
 	spec := &cdispec.Spec{
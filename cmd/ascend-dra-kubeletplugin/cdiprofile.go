@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+	cdispec "tags.cncf.io/container-device-interface/specs-go"
+
+	"Ascend-dra-driver/pkg/common"
+)
+
+// DefaultCDIProfile is the profile used for chip models without a profile of
+// their own.
+const DefaultCDIProfile = "default"
+
+// defaultMountOptions make the driver files read-only inside the container.
+var defaultMountOptions = []string{"ro", "nosuid", "nodev", "bind"}
+
+// CDIProfile lists the host files a container needs to use an NPU of a given
+// chip model, next to the /dev/davinciN or /dev/vdavinciN node of the device
+// itself: the device nodes shared by all NPUs and the driver libraries and
+// tools mounted from the host.
+type CDIProfile struct {
+	DeviceNodes []string   `json:"deviceNodes,omitempty"`
+	Mounts      []CDIMount `json:"mounts,omitempty"`
+}
+
+// CDIMount is a host path mounted into the container. The container path
+// defaults to the host path and the options to a read-only bind mount.
+type CDIMount struct {
+	HostPath      string   `json:"hostPath"`
+	ContainerPath string   `json:"containerPath,omitempty"`
+	Options       []string `json:"options,omitempty"`
+}
+
+// CDIProfiles maps chip models, as reported by dcmi (e.g. 310P3, 910B3), to
+// their CDI profile.
+type CDIProfiles map[string]CDIProfile
+
+// DefaultCDIProfiles returns the built-in profiles. They match the files the
+// Ascend docker runtime injects for a standard driver installation.
+func DefaultCDIProfiles() CDIProfiles {
+	return CDIProfiles{
+		DefaultCDIProfile: {
+			DeviceNodes: []string{
+				common.DavinciManagerPath,
+				common.DevmmSvmPath,
+				common.HisiHdcPath,
+			},
+			Mounts: []CDIMount{
+				{HostPath: "/usr/local/Ascend/driver/lib64"},
+				{HostPath: "/usr/local/Ascend/driver/include"},
+				{HostPath: "/usr/local/Ascend/driver/version.info"},
+				{HostPath: "/etc/ascend_install.info"},
+				{HostPath: "/usr/local/sbin/npu-smi"},
+			},
+		},
+	}
+}
+
+// LoadCDIProfiles reads a JSON or YAML file of CDI profiles. A profile in the
+// file replaces the built-in profile of the same model, including the default
+// one.
+func LoadCDIProfiles(path string) (CDIProfiles, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CDI profile file: %v", err)
+	}
+	file := CDIProfiles{}
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse CDI profile file %s: %v", path, err)
+	}
+
+	profiles := DefaultCDIProfiles()
+	for model, profile := range file {
+		for _, mount := range profile.Mounts {
+			if mount.HostPath == "" {
+				return nil, fmt.Errorf("mount without host path in CDI profile %s", model)
+			}
+		}
+		profiles[model] = profile
+	}
+	return profiles, nil
+}
+
+// ForModel returns the profile of a chip model, or the default profile.
+func (p CDIProfiles) ForModel(model string) CDIProfile {
+	if profile, ok := p[model]; ok {
+		return profile
+	}
+	return p[DefaultCDIProfile]
+}
+
+// addTo appends the device nodes and mounts of the profile to the edits.
+func (p CDIProfile) addTo(edits *cdispec.ContainerEdits) {
+	for _, path := range p.DeviceNodes {
+		edits.DeviceNodes = append(edits.DeviceNodes, &cdispec.DeviceNode{Path: path})
+	}
+	for _, mount := range p.Mounts {
+		containerPath := mount.ContainerPath
+		if containerPath == "" {
+			containerPath = mount.HostPath
+		}
+		options := mount.Options
+		if len(options) == 0 {
+			options = defaultMountOptions
+		}
+		edits.Mounts = append(edits.Mounts, &cdispec.Mount{
+			HostPath:      mount.HostPath,
+			ContainerPath: containerPath,
+			Options:       options,
+		})
+	}
+}
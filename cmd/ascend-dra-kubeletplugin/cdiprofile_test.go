@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cdiapi "tags.cncf.io/container-device-interface/pkg/cdi"
+)
+
+const cdiTestInventory = `
+devices:
+- logicID: 0
+  phyID: 4
+  cardID: 0
+  chipName: 910B3
+  aicore: 20
+  memoryGiB: 64
+- logicID: 1
+  phyID: 5
+  cardID: 1
+  chipName: 910B3
+  aicore: 20
+  memoryGiB: 64
+`
+
+func TestLoadCDIProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+910B3:
+  deviceNodes:
+  - /dev/davinci_manager
+  mounts:
+  - hostPath: /usr/local/Ascend/driver
+    containerPath: /opt/driver
+`), 0600))
+
+	profiles, err := LoadCDIProfiles(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/dev/davinci_manager"}, profiles.ForModel("910B3").DeviceNodes)
+	assert.Equal(t, DefaultCDIProfiles()[DefaultCDIProfile], profiles.ForModel("310P3"))
+
+	require.NoError(t, os.WriteFile(path, []byte("310P3:\n  mounts:\n  - containerPath: /opt\n"), 0600))
+	_, err = LoadCDIProfiles(path)
+	assert.Error(t, err)
+}
+
+func TestPrepareInjectsHostEdits(t *testing.T) {
+	state, _ := newTestDeviceState(t, cdiTestInventory)
+	state.cdi.profiles["910B3"] = CDIProfile{
+		DeviceNodes: []string{"/dev/davinci_manager", "/dev/devmm_svm"},
+		Mounts: []CDIMount{
+			{HostPath: "/usr/local/Ascend/driver", ContainerPath: "/opt/driver"},
+			{HostPath: "/usr/local/sbin/npu-smi", Options: []string{"ro", "bind"}},
+		},
+	}
+
+	_, err := state.Prepare(newTestClaim(t, "uid-1", "", "npu-0-0", "npu-1-0"))
+	require.NoError(t, err)
+
+	specDir := state.cdi.cache.GetSpecDirectories()[0]
+	specName := cdiapi.GenerateTransientSpecName(cdiVendor, cdiClass, "uid-1")
+	spec, err := cdiapi.ReadSpec(filepath.Join(specDir, specName+".yaml"), 0)
+	require.NoError(t, err)
+	require.Len(t, spec.Devices, 1)
+	edits := spec.Devices[0].ContainerEdits
+
+	// The chips are addressed by their physical ID, the shared nodes and
+	// mounts of the profile are added once per claim.
+	var deviceNodes []string
+	for _, node := range edits.DeviceNodes {
+		deviceNodes = append(deviceNodes, node.Path)
+	}
+	assert.ElementsMatch(t, []string{
+		"/dev/davinci4",
+		"/dev/davinci5",
+		"/dev/davinci_manager",
+		"/dev/devmm_svm",
+	}, deviceNodes)
+	require.Len(t, edits.Mounts, 2)
+	assert.Equal(t, "/usr/local/Ascend/driver", edits.Mounts[0].HostPath)
+	assert.Equal(t, "/opt/driver", edits.Mounts[0].ContainerPath)
+	assert.Equal(t, defaultMountOptions, edits.Mounts[0].Options)
+	assert.Equal(t, "/usr/local/sbin/npu-smi", edits.Mounts[1].ContainerPath)
+	assert.Equal(t, []string{"ro", "bind"}, edits.Mounts[1].Options)
+}
+
+func TestSimulatedPrepareSkipsHostEdits(t *testing.T) {
+	state, _ := newTestDeviceState(t, cdiTestInventory)
+	state.simulated = true
+
+	devices, err := state.Prepare(newTestClaim(t, "uid-1", "", "npu-0-0"))
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+
+	checkpoint := newCheckpoint()
+	require.NoError(t, state.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint))
+	prepared := checkpoint.V1.PreparedClaims["uid-1"]
+	require.Len(t, prepared, 1)
+	assert.Empty(t, prepared[0].ContainerEdits.DeviceNodes)
+	assert.Empty(t, prepared[0].ContainerEdits.Mounts)
+}
@@ -3,105 +3,101 @@ package main
 import (
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"strings"
 
-	resourceapi "k8s.io/api/resource/v1beta1"
+	resourceapi "k8s.io/api/resource/v1"
 	"k8s.io/utils/ptr"
-)
 
-// fetchAiCore attempts to retrieve the total number of AI Cores on the card.
-func fetchAiCore(mgr *AscendManager) (int, error) {
-	aiCoreCount, err := mgr.GetChipAiCoreCount()
-	if err == nil {
-		return int(aiCoreCount), nil
-	}
-	return 0, err
-}
-
-// fetchMemory attempts to retrieve total memory from the card.
-func fetchMemory(hdm *AscendManager) (int, error) {
-	memSize, err := hdm.GetChipMem()
-	if err == nil {
-		return int(memSize), nil
-	}
-	return 0, err
-}
-
-// getDeviceResources returns the maximum AI Core and memory for a device
-// depending on whether it has been split into vNPUs or not.
-func getDeviceResources(mgr *AscendManager, devType string, vnpuManager *VnpuManager, deviceName string) (int, int) {
-	if vnpuManager == nil {
-		return 0, 0
-	}
-	physicalNpu := vnpuManager.PhysicalNpus[deviceName]
-	if physicalNpu == nil {
-		return 0, 0
-	}
+	"Ascend-dra-driver/pkg/common"
+)
 
-	// If the device has not been split yet, return the full card resources
-	if len(physicalNpu.AllocatedSlices) == 0 {
-		aiCores, errCore := fetchAiCore(mgr)
-		if errCore != nil {
-			log.Printf("Failed to fetch AI Core count: %v", errCore)
+// addTemplateAttributes publishes the vNPU templates supported by the chip of a
+// device, from which the controller builds the DeviceClasses. Every device of
+// the chip carries them, so that they are still published while the chip is
+// split or partly allocated.
+func addTemplateAttributes(attributes map[resourceapi.QualifiedName]resourceapi.DeviceAttribute, templates map[string]*VnpuTemplate) {
+	for name, tpl := range templates {
+		id, ok := common.TemplateAttributeID(name)
+		if !ok {
+			log.Printf("Template name %s is too long to be published", name)
+			continue
 		}
-		mem, errMem := fetchMemory(mgr)
-		if errMem != nil {
-			log.Printf("Failed to fetch memory size: %v", errMem)
+		resources := common.TemplateResources{
+			AICore:    tpl.Attributes.AICORE,
+			MemoryGiB: tpl.Attributes.Memory,
+			AICPU:     tpl.Attributes.AICPU,
 		}
-		return aiCores, mem
+		attributes[resourceapi.QualifiedName(DriverDomain+id)] = resourceapi.DeviceAttribute{StringValue: ptr.To(resources.String())}
 	}
+}
 
-	// If the device has already been split, find the largest remaining
-	// AI Core and memory values from the available templates
-	maxAicore, maxMemory := 0, 0
-	for _, tpl := range physicalNpu.SupportTemplates {
-		if tpl.Attributes.AICORE > maxAicore {
-			maxAicore = tpl.Attributes.AICORE
-		}
-		if tpl.Attributes.Memory > maxMemory {
-			maxMemory = tpl.Attributes.Memory
-		}
-	}
-	return maxAicore, maxMemory
+// setTemplateAttributes replaces the published templates of a device.
+func setTemplateAttributes(attributes map[resourceapi.QualifiedName]resourceapi.DeviceAttribute, templates map[string]*VnpuTemplate) {
+	maps.DeleteFunc(attributes, func(name resourceapi.QualifiedName, _ resourceapi.DeviceAttribute) bool {
+		return strings.HasPrefix(string(name), DriverDomain+common.TemplateAttributePrefix)
+	})
+	addTemplateAttributes(attributes, templates)
 }
 
-// enumerateAllPossibleDevices initializes the devmanager, creates a vNPU manager if possible,
-// and enumerates all possible devices to produce an AllocatableDevices map.
-func enumerateAllPossibleDevices() (AllocatableDevices, *VnpuManager, error) {
-	mgr, err := NewAscendManager()
-	allInfo, _ := mgr.NewHwDevManager()
-	vnpuManager, err := NewVnpuManager()
+// enumerateAllPossibleDevices queries the NPU backend, creates a vNPU manager if possible,
+// and enumerates all devices to produce an AllocatableDevices map.
+// The vNPUs already present on the chips are returned alongside, so that they
+// can be adopted or cleaned up once the checkpoint has been restored.
+func enumerateAllPossibleDevices(backend NpuBackend, catalog TemplateCatalog) (AllocatableDevices, *VnpuManager, []common.NpuDevice, error) {
+	mgr := NewAscendManager(backend)
+	allInfo, err := mgr.NewHwDevManager()
 	if err != nil {
-		log.Printf("Failed to initialize vNPU manager: %v. Only full-card allocation is supported.", err)
+		return nil, nil, nil, fmt.Errorf("failed to list NPUs: %v", err)
 	}
+	vnpuManager := NewVnpuManager(catalog)
 
 	alldevices := make(AllocatableDevices)
+	var existingVnpus []common.NpuDevice
+	seen := make(map[int32]bool)
 	for _, dev := range allInfo.AllDevs {
+		// A chip that has been split is reported once per vNPU, the physical
+		// device is still published once under the chip model.
+		if dev.VDevID != 0 {
+			log.Printf("Found existing vNPU %d (template: %s) on logic ID %d", dev.VDevID, dev.TemplateName, dev.LogicID)
+			existingVnpus = append(existingVnpus, dev)
+		}
+		if seen[dev.LogicID] {
+			continue
+		}
+		seen[dev.LogicID] = true
+
+		// Unhealthy devices are enumerated as well, the health monitor keeps
+		// them out of the ResourceSlice until they recover.
 		deviceName := fmt.Sprintf("npu-%d-0", dev.LogicID)
 		uuidStr := fmt.Sprintf("%s-%d", os.Getenv("NODE_NAME"), dev.LogicID)
 
 		devAttributes := map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{
 			DriverDomain + "index": {IntValue: ptr.To(int64(dev.LogicID))},
 			DriverDomain + "uuid":  {StringValue: ptr.To(uuidStr)},
-			DriverDomain + "model": {StringValue: ptr.To(dev.DevType)},
+			DriverDomain + "model": {StringValue: ptr.To(dev.ChipName)},
 			DriverDomain + "type":  {StringValue: ptr.To("NPU")},
 		}
+		topology := getNpuTopology(backend, dev)
+		topology.addTo(devAttributes)
 
 		if vnpuManager != nil {
-			vnpuManager.InitPhysicalNpu(deviceName, dev.LogicID, dev.DevType)
-			maxAicore, maxMemory := getDeviceResources(mgr, dev.DevType, vnpuManager, deviceName)
-			devAttributes[DriverDomain+"aicore"] = resourceapi.DeviceAttribute{IntValue: ptr.To(int64(maxAicore))}
-			devAttributes[DriverDomain+"memory"] = resourceapi.DeviceAttribute{IntValue: ptr.To(int64(maxMemory))}
+			capacity, err := getChipCapacity(backend, dev.LogicID)
+			if err != nil {
+				log.Printf("Failed to get capacity of logic ID %d, vNPUs are not checked against it: %v", dev.LogicID, err)
+			}
+			vnpuManager.InitPhysicalNpu(deviceName, dev.LogicID, dev.ChipName, topology, capacity)
+			setCapacityAttributes(devAttributes, capacity, capacity)
+			addTemplateAttributes(devAttributes, vnpuManager.PhysicalNpus[deviceName].Templates)
 		}
 
 		device := resourceapi.Device{
-			Name: deviceName,
-			Basic: &resourceapi.BasicDevice{
-				Attributes: devAttributes,
-			},
+			Name:       deviceName,
+			Attributes: devAttributes,
 		}
 		alldevices[device.Name] = device
-		log.Printf("Discovered NPU device: %s, Type: NPU, Model: %s", deviceName, dev.DevType)
+		log.Printf("Discovered NPU device: %s, Type: NPU, Model: %s", deviceName, dev.ChipName)
 	}
-	return alldevices, vnpuManager, nil
+	return alldevices, vnpuManager, existingVnpus, nil
 }
@@ -18,27 +18,37 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"time"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/runtime"
 	coreclientset "k8s.io/client-go/kubernetes"
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+	"k8s.io/dynamic-resource-allocation/resourceslice"
 	"k8s.io/klog/v2"
-
-	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
 )
 
-var _ drapbv1.DRAPluginServer = &driver{}
+var _ kubeletplugin.DRAPlugin = &driver{}
 
 type driver struct {
-	client coreclientset.Interface
-	plugin kubeletplugin.DRAPlugin
-	state  *DeviceState
+	client     coreclientset.Interface
+	helper     *kubeletplugin.Helper
+	state      *DeviceState
+	health     *HealthMonitor
+	metrics    *Metrics
+	nodeName   string
+	stopHealth context.CancelFunc
 }
 
 func NewDriver(ctx context.Context, config *Config) (*driver, error) {
 	driver := &driver{
-		client: config.coreclient,
+		client:   config.coreclient,
+		health:   NewHealthMonitor(config.backend, config.flags.healthCheckInterval, config.faultCodes),
+		nodeName: config.flags.nodeName,
 	}
 
 	state, err := NewDeviceState(config)
@@ -46,114 +56,189 @@ func NewDriver(ctx context.Context, config *Config) (*driver, error) {
 		return nil, err
 	}
 	driver.state = state
+	if state.vnpuManager != nil {
+		state.vnpuManager.SetPlacement(config.vnpuPlacement, driver.health.Usable)
+	}
+	driver.metrics = NewMetrics(state, config.backend)
+	driver.health.Check()
 
-	plugin, err := kubeletplugin.Start(
+	helper, err := kubeletplugin.Start(
 		ctx,
-		[]any{driver},
+		driver,
 		kubeletplugin.KubeClient(config.coreclient),
 		kubeletplugin.NodeName(config.flags.nodeName),
 		kubeletplugin.DriverName(DriverName),
-		kubeletplugin.RegistrarSocketPath(PluginRegistrationPath),
-		kubeletplugin.PluginSocketPath(DriverPluginSocketPath),
-		kubeletplugin.KubeletPluginSocketPath(DriverPluginSocketPath))
+		kubeletplugin.RegistrarDirectoryPath(PluginRegistrationDirectoryPath),
+		kubeletplugin.PluginDataDirectoryPath(DriverPluginPath),
+		// Serve both DRA gRPC versions, the kubelet picks the newest one it
+		// supports when the plugin registers.
+		kubeletplugin.NodeV1(true),
+		kubeletplugin.NodeV1beta1(true))
 	if err != nil {
 		return nil, err
 	}
-	driver.plugin = plugin
+	driver.helper = helper
 
-	var resources kubeletplugin.Resources
-	for _, device := range state.allocatable {
-		resources.Devices = append(resources.Devices, device)
+	// Drops devices that were consumed by claims restored from the checkpoint.
+	if err := driver.publishResources(ctx); err != nil {
+		return nil, err
 	}
 
-	if err := plugin.PublishResources(ctx, resources); err != nil {
-		return nil, err
+	healthCtx, stopHealth := context.WithCancel(ctx)
+	driver.stopHealth = stopHealth
+	if config.flags.metricsAddress != "" {
+		go func() {
+			if err := driver.metrics.Serve(healthCtx, config.flags.metricsAddress); err != nil {
+				klog.Errorf("Failed to serve metrics: %v", err)
+			}
+		}()
+	}
+	err = config.templates.Watch(healthCtx, func(catalog TemplateCatalog) {
+		driver.state.UpdateTemplates(catalog)
+		if err := driver.publishResources(healthCtx); err != nil {
+			klog.Errorf("Failed to publish resources after vNPU template change: %v", err)
+		} else {
+			klog.Infof("Successfully published updated resources after vNPU template change")
+		}
+	})
+	if err != nil {
+		klog.Errorf("Not reloading vNPU templates on changes: %v", err)
 	}
+	go driver.health.Run(healthCtx, func() {
+		if err := driver.publishResources(healthCtx); err != nil {
+			klog.Errorf("Failed to publish resources after device health change: %v", err)
+		} else {
+			klog.Infof("Successfully published updated resources after device health change")
+		}
+	})
 
 	return driver, nil
 }
 
 func (d *driver) Shutdown(ctx context.Context) error {
-	d.plugin.Stop()
+	d.stopHealth()
+	d.helper.Stop()
 	return nil
 }
 
-func (d *driver) NodePrepareResources(ctx context.Context, req *drapbv1.NodePrepareResourcesRequest) (*drapbv1.NodePrepareResourcesResponse, error) {
-	klog.Infof("NodePrepareResource is called: number of claims: %d", len(req.Claims))
-	preparedResources := &drapbv1.NodePrepareResourcesResponse{Claims: map[string]*drapbv1.NodePrepareResourceResponse{}}
-
-	for _, claim := range req.Claims {
-		preparedResources.Claims[claim.UID] = d.nodePrepareResource(ctx, claim)
+// publishResources publishes the allocatable devices together with the health
+// of their chips. Devices of faulty chips are tainted, or withdrawn if the
+// cluster does not support device taints.
+func (d *driver) publishResources(ctx context.Context) error {
+	d.state.Lock()
+	if d.state.vnpuManager != nil && !d.state.partitionable {
+		d.syncAllocatable()
+	}
+	devices := d.health.ApplyHealth(d.state.allocatable)
+	poolSlices := d.state.resourceSlices(devices)
+	d.state.Unlock()
+
+	resources := resourceslice.DriverResources{
+		Pools: map[string]resourceslice.Pool{
+			d.nodeName: {
+				Slices: poolSlices,
+			},
+		},
+	}
+	if err := d.helper.PublishResources(ctx, resources); err != nil {
+		d.metrics.PublishFailed()
+		return err
 	}
+	return nil
+}
 
-	d.syncAllocatable()
+func (d *driver) PrepareResourceClaims(ctx context.Context, claims []*resourceapi.ResourceClaim) (map[types.UID]kubeletplugin.PrepareResult, error) {
+	klog.Infof("PrepareResourceClaims is called: number of claims: %d", len(claims))
+	defer d.metrics.ObserveRequest(MethodNodePrepareResources, time.Now())
+	result := make(map[types.UID]kubeletplugin.PrepareResult)
 
-	var resources kubeletplugin.Resources
-	for _, deviceName := range d.state.allocatable {
-		resources.Devices = append(resources.Devices, deviceName)
+	for _, claim := range claims {
+		result[claim.UID] = d.prepareResourceClaim(ctx, claim)
 	}
 
-	if err := d.plugin.PublishResources(ctx, resources); err != nil {
+	if err := d.publishResources(ctx); err != nil {
 		klog.Errorf("Failed to publish resources after preparing claims: %v", err)
 	} else {
-		klog.Infof("Successfully published updated resources after preparing %d claims", len(req.Claims))
+		klog.Infof("Successfully published updated resources after preparing %d claims", len(claims))
 	}
 
-	return preparedResources, nil
+	return result, nil
 }
 
-func (d *driver) nodePrepareResource(ctx context.Context, claim *drapbv1.Claim) *drapbv1.NodePrepareResourceResponse {
-	resourceClaim, err := d.client.ResourceV1beta1().ResourceClaims(claim.Namespace).Get(
-		ctx,
-		claim.Name,
-		metav1.GetOptions{})
+func (d *driver) prepareResourceClaim(ctx context.Context, claim *resourceapi.ResourceClaim) kubeletplugin.PrepareResult {
+	preparedPBs, err := d.state.Prepare(claim)
 	if err != nil {
-		return &drapbv1.NodePrepareResourceResponse{
-			Error: fmt.Sprintf("failed to fetch ResourceClaim %s in namespace %s", claim.Name, claim.Namespace),
+		d.metrics.PrepareFailed(err)
+		return kubeletplugin.PrepareResult{
+			Err: fmt.Errorf("error preparing devices for claim %v: %w", claim.UID, err),
 		}
 	}
 
-	prepared, err := d.state.Prepare(resourceClaim)
-	if err != nil {
-		return &drapbv1.NodePrepareResourceResponse{
-			Error: fmt.Sprintf("error preparing devices for claim %v: %v", claim.UID, err),
-		}
+	var prepared []kubeletplugin.Device
+	for _, preparedPB := range preparedPBs {
+		prepared = append(prepared, kubeletplugin.Device{
+			Requests:     preparedPB.GetRequestNames(),
+			PoolName:     preparedPB.GetPoolName(),
+			DeviceName:   preparedPB.GetDeviceName(),
+			CDIDeviceIDs: preparedPB.GetCDIDeviceIDs(),
+		})
 	}
 
 	klog.Infof("Returning newly prepared devices for claim '%v': %v", claim.UID, prepared)
-	return &drapbv1.NodePrepareResourceResponse{Devices: prepared}
+	return kubeletplugin.PrepareResult{Devices: prepared}
 }
 
-func (d *driver) NodeUnprepareResources(ctx context.Context, req *drapbv1.NodeUnprepareResourcesRequest) (*drapbv1.NodeUnprepareResourcesResponse, error) {
-	klog.Infof("NodeUnPrepareResource is called: number of claims: %d", len(req.Claims))
-	unpreparedResources := &drapbv1.NodeUnprepareResourcesResponse{Claims: map[string]*drapbv1.NodeUnprepareResourceResponse{}}
+func (d *driver) UnprepareResourceClaims(ctx context.Context, claims []kubeletplugin.NamespacedObject) (map[types.UID]error, error) {
+	klog.Infof("UnprepareResourceClaims is called: number of claims: %d", len(claims))
+	defer d.metrics.ObserveRequest(MethodNodeUnprepareResources, time.Now())
+	result := make(map[types.UID]error)
 
-	for _, claim := range req.Claims {
-		unpreparedResources.Claims[claim.UID] = d.nodeUnprepareResource(ctx, claim)
+	for _, claim := range claims {
+		result[claim.UID] = d.unprepareResourceClaim(ctx, claim)
 	}
 
-	d.syncAllocatable()
-
-	var resources kubeletplugin.Resources
-	for _, device := range d.state.allocatable {
-		resources.Devices = append(resources.Devices, device)
-	}
-
-	if err := d.plugin.PublishResources(ctx, resources); err != nil {
+	if err := d.publishResources(ctx); err != nil {
 		klog.Errorf("Failed to publish resources after unpreparing claims: %v", err)
 	} else {
-		klog.Infof("Successfully published updated resources after unpreparing %d claims", len(req.Claims))
+		klog.Infof("Successfully published updated resources after unpreparing %d claims", len(claims))
 	}
 
-	return unpreparedResources, nil
+	return result, nil
 }
 
-func (d *driver) nodeUnprepareResource(ctx context.Context, claim *drapbv1.Claim) *drapbv1.NodeUnprepareResourceResponse {
-	if err := d.state.Unprepare(claim.UID); err != nil {
-		return &drapbv1.NodeUnprepareResourceResponse{
-			Error: fmt.Sprintf("error unpreparing devices for claim %v: %v", claim.UID, err),
-		}
+func (d *driver) unprepareResourceClaim(ctx context.Context, claim kubeletplugin.NamespacedObject) error {
+	if err := d.state.Unprepare(string(claim.UID)); err != nil {
+		return fmt.Errorf("error unpreparing devices for claim %v: %w", claim.UID, err)
 	}
 
-	return &drapbv1.NodeUnprepareResourceResponse{}
+	return nil
+}
+
+// HandleError is called for errors encountered in the background, e.g. while
+// publishing ResourceSlices. If the apiserver drops the device taints because
+// the DRADeviceTaints feature is disabled, the devices of unhealthy chips are
+// withdrawn instead. Dropped counters cannot be worked around and are only
+// reported. Other publish errors are counted in the metrics.
+func (d *driver) HandleError(ctx context.Context, err error, msg string) {
+	var droppedFields *resourceslice.DroppedFieldsError
+	if errors.As(err, &droppedFields) && slices.Contains(droppedFields.DisabledFeatures(), "DRAPartitionableDevices") {
+		klog.Errorf("The cluster dropped the counters of the partitionable devices, vNPUs may be overcommitted: " +
+			"enable the DRAPartitionableDevices feature gate or restart the plugin without --partitionable-devices")
+	}
+	if errors.As(err, &droppedFields) && slices.Contains(droppedFields.DisabledFeatures(), "DRADeviceTaints") {
+		if d.health.DisableTaints() {
+			klog.Warningf("Device taints are not supported by the cluster, withdrawing the devices of unhealthy NPUs instead")
+			go func() {
+				if err := d.publishResources(ctx); err != nil {
+					klog.Errorf("Failed to publish resources without device taints: %v", err)
+				}
+			}()
+		}
+		return
+	}
+	// Only the ResourceSlice controller reports recoverable errors.
+	if errors.Is(err, kubeletplugin.ErrRecoverable) {
+		d.metrics.PublishFailed()
+	}
+	runtime.HandleErrorWithContext(ctx, err, msg)
 }
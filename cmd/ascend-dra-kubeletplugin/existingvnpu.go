@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"Ascend-dra-driver/pkg/common"
+)
+
+// ExistingVnpuPolicy decides what happens at startup to vNPUs that are found on
+// the chips but are not owned by any checkpointed claim, e.g. because they were
+// created by an operator or left behind by an earlier run of the plugin.
+type ExistingVnpuPolicy string
+
+const (
+	// ExistingVnpuPolicyAdopt publishes the vNPUs as devices of their own, which
+	// are handed out as-is and kept on the chip when released.
+	ExistingVnpuPolicyAdopt ExistingVnpuPolicy = "adopt"
+	// ExistingVnpuPolicyCleanup destroys the vNPUs so that their chip
+	// resources can be allocated again.
+	ExistingVnpuPolicyCleanup ExistingVnpuPolicy = "cleanup"
+)
+
+// ParseExistingVnpuPolicy validates the policy given on the command line.
+func ParseExistingVnpuPolicy(value string) (ExistingVnpuPolicy, error) {
+	switch policy := ExistingVnpuPolicy(value); policy {
+	case ExistingVnpuPolicyAdopt, ExistingVnpuPolicyCleanup:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown existing vNPU policy %q, must be %q or %q",
+			value, ExistingVnpuPolicyAdopt, ExistingVnpuPolicyCleanup)
+	}
+}
+
+// reconcileExistingVnpus applies the policy to the vNPUs found on the chips at
+// startup. It must run after restoreFromCheckpoint, so that the vNPUs still
+// held by prepared claims are recognized and left alone.
+func (s *DeviceState) reconcileExistingVnpus(existing []common.NpuDevice, policy ExistingVnpuPolicy) {
+	var prepared map[PreparedVirtualDevice]bool
+	if s.partitionable {
+		var err error
+		if prepared, err = s.preparedVirtualDevices(); err != nil {
+			log.Printf("Warning: failed to read the vNPUs of prepared claims: %v", err)
+		}
+	}
+
+	for _, vdev := range existing {
+		deviceName := fmt.Sprintf("npu-%d-0", vdev.LogicID)
+		if s.partitionable {
+			// vNPUs created for prepared claims are accounted for by the
+			// scheduler, adopted ones must be published again whatever the
+			// policy as a claim still holds them.
+			adopted, ok := prepared[PreparedVirtualDevice{LogicID: vdev.LogicID, VDevID: vdev.VDevID}]
+			if ok && !adopted {
+				continue
+			}
+			if ok || policy == ExistingVnpuPolicyAdopt {
+				if err := s.adoptPartitionVnpu(vdev); err != nil {
+					log.Printf("Warning: failed to adopt vNPU %d on logic ID %d: %v", vdev.VDevID, vdev.LogicID, err)
+				}
+				continue
+			}
+		} else if s.vnpuManager != nil && s.vnpuManager.HasVnpu(deviceName, vdev.VDevID) {
+			continue
+		}
+
+		switch policy {
+		case ExistingVnpuPolicyCleanup:
+			if err := s.destroyVnpu(&PreparedVirtualDevice{LogicID: vdev.LogicID, VDevID: vdev.VDevID}); err != nil {
+				log.Printf("Warning: failed to clean up orphaned vNPU %d on logic ID %d: %v", vdev.VDevID, vdev.LogicID, err)
+			}
+		case ExistingVnpuPolicyAdopt:
+			if s.vnpuManager == nil {
+				log.Printf("Warning: cannot adopt vNPU %d on logic ID %d without vNPU support", vdev.VDevID, vdev.LogicID)
+				continue
+			}
+			if _, err := s.vnpuManager.AdoptVnpu(deviceName, vdev.VDevID, vdev.TemplateName); err != nil {
+				log.Printf("Warning: failed to adopt vNPU %d on logic ID %d: %v", vdev.VDevID, vdev.LogicID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+
+	npuCommon "huawei.com/npu-exporter/v5/devmanager/common"
+	"sigs.k8s.io/yaml"
+
+	"Ascend-dra-driver/pkg/common"
+)
+
+// firstFakeVDevID mirrors the numbering dcmi uses for virtual devices.
+const firstFakeVDevID = 100
+
+// Health values accepted in an inventory.
+const (
+	InventoryHealthy   = common.HealthyState
+	InventoryWarning   = common.WarningState
+	InventoryUnhealthy = common.UnHealthyState
+)
+
+// Inventory describes the NPUs of a simulated node together with the vNPU
+// templates they support. It can be written as either JSON or YAML.
+type Inventory struct {
+	Templates []InventoryTemplate `json:"templates,omitempty"`
+	// Models replaces the templates for the listed chip models, to simulate
+	// nodes mixing chip models.
+	Models  map[string][]InventoryTemplate `json:"models,omitempty"`
+	Devices []InventoryDevice              `json:"devices"`
+}
+
+// InventoryTemplate describes a vNPU template, i.e. one row of the
+// `npu-smi info -t template-info` table.
+type InventoryTemplate struct {
+	Name      string `json:"name"`
+	AICore    int    `json:"aicore"`
+	MemoryGiB int    `json:"memoryGiB"`
+	AICPU     int    `json:"aicpu,omitempty"`
+	MediaEngines
+}
+
+// InventoryDevice describes a single simulated NPU chip.
+type InventoryDevice struct {
+	LogicID     int32  `json:"logicID"`
+	PhyID       int32  `json:"phyID"`
+	CardID      int32  `json:"cardID"`
+	DeviceID    int32  `json:"deviceID"`
+	DeviceIP    string `json:"deviceIP,omitempty"`
+	PCIeBusID   string `json:"pcieBusID,omitempty"`
+	NUMANode    *int32 `json:"numaNode,omitempty"`
+	ChipType    string `json:"chipType,omitempty"`
+	ChipName    string `json:"chipName"`
+	ChipVersion string `json:"chipVersion,omitempty"`
+	AICore      int32  `json:"aicore"`
+	MemoryGiB   int32  `json:"memoryGiB"`
+	AICPU       int32  `json:"aicpu,omitempty"`
+	MediaEngines
+	Health         string                   `json:"health,omitempty"`
+	ErrorCodes     []int64                  `json:"errorCodes,omitempty"`
+	Telemetry      InventoryTelemetry       `json:"telemetry,omitempty"`
+	VirtualDevices []InventoryVirtualDevice `json:"virtualDevices,omitempty"`
+}
+
+// InventoryTelemetry holds the readings reported by a simulated NPU chip. The
+// HBM size is taken from the memory of the chip.
+type InventoryTelemetry struct {
+	TemperatureCelsius int32   `json:"temperatureCelsius,omitempty"`
+	PowerWatts         float32 `json:"powerWatts,omitempty"`
+	AICoreUtilization  uint32  `json:"aicoreUtilization,omitempty"`
+	HBMUsedMiB         uint64  `json:"hbmUsedMiB,omitempty"`
+}
+
+// InventoryVirtualDevice describes a vNPU that already exists on a simulated chip.
+type InventoryVirtualDevice struct {
+	VDevID       uint32 `json:"vdevID"`
+	TemplateName string `json:"templateName"`
+}
+
+// FakeBackend is an NpuBackend driven by an Inventory instead of dcmi.
+type FakeBackend struct {
+	sync.Mutex
+	templates  map[string]map[string]InventoryTemplate
+	logicIDs   []int32
+	devices    map[int32]*fakeDevice
+	nextVDevID uint32
+}
+
+type fakeDevice struct {
+	InventoryDevice
+	vdevs []npuCommon.CgoVDevQueryStru
+}
+
+var _ NpuBackend = &FakeBackend{}
+
+// LoadInventory reads a JSON or YAML inventory file.
+func LoadInventory(path string) (*Inventory, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory file: %v", err)
+	}
+	inventory := &Inventory{}
+	if err := yaml.UnmarshalStrict(content, inventory); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory file %s: %v", path, err)
+	}
+	return inventory, nil
+}
+
+// NewFakeBackendFromFile creates a FakeBackend from an inventory file.
+func NewFakeBackendFromFile(path string) (*FakeBackend, error) {
+	inventory, err := LoadInventory(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewFakeBackend(inventory)
+}
+
+// NewFakeBackend creates a FakeBackend serving the devices of the given inventory.
+func NewFakeBackend(inventory *Inventory) (*FakeBackend, error) {
+	b := &FakeBackend{
+		templates:  make(map[string]map[string]InventoryTemplate),
+		devices:    make(map[int32]*fakeDevice),
+		nextVDevID: firstFakeVDevID,
+	}
+	if err := b.addTemplates(DefaultTemplateModel, inventory.Templates); err != nil {
+		return nil, err
+	}
+	for model, templates := range inventory.Models {
+		if err := b.addTemplates(model, templates); err != nil {
+			return nil, fmt.Errorf("invalid templates of model %s: %v", model, err)
+		}
+	}
+	for _, dev := range inventory.Devices {
+		if _, exists := b.devices[dev.LogicID]; exists {
+			return nil, fmt.Errorf("duplicate logic ID %d in inventory", dev.LogicID)
+		}
+		if dev.ChipName == "" {
+			return nil, fmt.Errorf("chip name is required for logic ID %d", dev.LogicID)
+		}
+		if _, err := inventoryHealthState(dev.Health); err != nil {
+			return nil, fmt.Errorf("invalid health for logic ID %d: %v", dev.LogicID, err)
+		}
+		fd := &fakeDevice{InventoryDevice: dev}
+		for _, vdev := range dev.VirtualDevices {
+			info, err := b.newVDevQueryStru(dev.ChipName, vdev.VDevID, vdev.TemplateName)
+			if err != nil {
+				return nil, fmt.Errorf("invalid virtual device on logic ID %d: %v", dev.LogicID, err)
+			}
+			fd.vdevs = append(fd.vdevs, info)
+			if vdev.VDevID >= b.nextVDevID {
+				b.nextVDevID = vdev.VDevID + 1
+			}
+		}
+		b.devices[dev.LogicID] = fd
+		b.logicIDs = append(b.logicIDs, dev.LogicID)
+	}
+	slices.Sort(b.logicIDs)
+	return b, nil
+}
+
+// addTemplates registers the templates of a chip model.
+func (b *FakeBackend) addTemplates(model string, templates []InventoryTemplate) error {
+	if len(templates) == 0 {
+		return nil
+	}
+	b.templates[model] = make(map[string]InventoryTemplate, len(templates))
+	for _, tpl := range templates {
+		if tpl.Name == "" {
+			return fmt.Errorf("template name is required")
+		}
+		b.templates[model][tpl.Name] = tpl
+	}
+	return nil
+}
+
+// GetDeviceList returns the number of devices and their logic IDs.
+func (b *FakeBackend) GetDeviceList() (int32, []int32, error) {
+	b.Lock()
+	defer b.Unlock()
+	return int32(len(b.logicIDs)), slices.Clone(b.logicIDs), nil
+}
+
+// GetChipInfo returns the chip type, name and version of a device.
+func (b *FakeBackend) GetChipInfo(logicID int32) (*npuCommon.ChipInfo, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return nil, err
+	}
+	chipType := dev.ChipType
+	if chipType == "" {
+		chipType = "Ascend"
+	}
+	return &npuCommon.ChipInfo{
+		Type:    chipType,
+		Name:    dev.ChipName,
+		Version: dev.ChipVersion,
+	}, nil
+}
+
+// GetPhysicIDFromLogicID returns the physical ID of a device.
+func (b *FakeBackend) GetPhysicIDFromLogicID(logicID int32) (int32, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return 0, err
+	}
+	return dev.PhyID, nil
+}
+
+// GetCardIDDeviceID returns the card ID and the device ID within the card.
+func (b *FakeBackend) GetCardIDDeviceID(logicID int32) (int32, int32, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return dev.CardID, dev.DeviceID, nil
+}
+
+// GetDeviceIPAddress returns the IP of the RoCE port of a device. Devices
+// without a deviceIP in the inventory have no such port.
+func (b *FakeBackend) GetDeviceIPAddress(logicID int32) (string, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return "", err
+	}
+	if dev.DeviceIP == "" {
+		return "", fmt.Errorf("logic ID %d has no device IP", logicID)
+	}
+	return dev.DeviceIP, nil
+}
+
+// GetPCIeBusInfo returns the PCIe bus ID of a device.
+func (b *FakeBackend) GetPCIeBusInfo(logicID int32) (string, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return "", err
+	}
+	if dev.PCIeBusID == "" {
+		return "", fmt.Errorf("logic ID %d has no PCIe bus ID", logicID)
+	}
+	return dev.PCIeBusID, nil
+}
+
+// GetNUMANode returns the NUMA node of a device, or -1 if the inventory does
+// not place it on one. The simulated node has no sysfs to read it from.
+func (b *FakeBackend) GetNUMANode(logicID int32) (int32, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return 0, err
+	}
+	if dev.NUMANode == nil {
+		return -1, nil
+	}
+	return *dev.NUMANode, nil
+}
+
+// GetDeviceHealth returns the dcmi health state of a device.
+func (b *FakeBackend) GetDeviceHealth(logicID int32) (uint32, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return 0, err
+	}
+	return inventoryHealthState(dev.Health)
+}
+
+// GetDeviceAllErrorCode returns the error codes currently reported by a device.
+func (b *FakeBackend) GetDeviceAllErrorCode(logicID int32) (int32, []int64, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return int32(len(dev.ErrorCodes)), slices.Clone(dev.ErrorCodes), nil
+}
+
+// GetDeviceTemperature returns the temperature of a device in degrees Celsius.
+func (b *FakeBackend) GetDeviceTemperature(logicID int32) (int32, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return 0, err
+	}
+	return dev.Telemetry.TemperatureCelsius, nil
+}
+
+// GetDevicePowerInfo returns the power draw of a device in watts.
+func (b *FakeBackend) GetDevicePowerInfo(logicID int32) (float32, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return 0, err
+	}
+	return dev.Telemetry.PowerWatts, nil
+}
+
+// GetDeviceUtilizationRate returns the utilization of a device in percent.
+// Only the AICore utilization is simulated.
+func (b *FakeBackend) GetDeviceUtilizationRate(logicID int32, deviceType npuCommon.DeviceType) (uint32, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return 0, err
+	}
+	if deviceType != npuCommon.AICore {
+		return 0, fmt.Errorf("utilization of device type %d is not simulated", deviceType)
+	}
+	return dev.Telemetry.AICoreUtilization, nil
+}
+
+// GetDeviceHbmInfo returns the HBM size and usage of a device in MiB.
+func (b *FakeBackend) GetDeviceHbmInfo(logicID int32) (*npuCommon.HbmInfo, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return nil, err
+	}
+	return &npuCommon.HbmInfo{
+		MemorySize: uint64(dev.MemoryGiB) * 1024,
+		Usage:      dev.Telemetry.HBMUsedMiB,
+	}, nil
+}
+
+// SetDeviceHealth changes the health and error codes reported by a device, to
+// simulate a chip failing or recovering.
+func (b *FakeBackend) SetDeviceHealth(logicID int32, health string, errorCodes []int64) error {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return err
+	}
+	if _, err := inventoryHealthState(health); err != nil {
+		return err
+	}
+	dev.Health = health
+	dev.ErrorCodes = slices.Clone(errorCodes)
+	return nil
+}
+
+// GetVirtualDeviceInfo returns the total and free resources of a device along
+// with the virtual devices carved from it.
+func (b *FakeBackend) GetVirtualDeviceInfo(logicID int32) (npuCommon.VirtualDevInfo, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return npuCommon.VirtualDevInfo{}, err
+	}
+
+	total := npuCommon.CgoComputingResource{
+		Aic:         float32(dev.AICore),
+		MemorySize:  uint64(dev.MemoryGiB) * 1024,
+		DeviceAicpu: uint16(dev.AICPU),
+	}
+	media := npuCommon.CgoMediaResource{
+		Vpc:   float32(dev.VPC),
+		Venc:  float32(dev.VENC),
+		Vdec:  float32(dev.VDEC),
+		Jpegd: float32(dev.JPEGD),
+		Jpege: float32(dev.JPEGE),
+		Pngd:  float32(dev.PNGD),
+	}
+	free := total
+	var vdevIDs []uint32
+	for _, vdev := range dev.vdevs {
+		vdevIDs = append(vdevIDs, vdev.VDevID)
+		free.Aic -= vdev.QueryInfo.Computing.Aic
+		if vdev.QueryInfo.Computing.MemorySize <= free.MemorySize {
+			free.MemorySize -= vdev.QueryInfo.Computing.MemorySize
+		}
+	}
+
+	return npuCommon.VirtualDevInfo{
+		TotalResource: npuCommon.CgoSocTotalResource{
+			VDevNum:   uint32(len(dev.vdevs)),
+			VDevID:    vdevIDs,
+			Computing: total,
+			Media:     media,
+		},
+		FreeResource: npuCommon.CgoSocFreeResource{
+			Computing: free,
+		},
+		VDevInfo: slices.Clone(dev.vdevs),
+	}, nil
+}
+
+// CreateVirtualDevice carves a vNPU with the requested template from a device.
+func (b *FakeBackend) CreateVirtualDevice(logicID int32, vDevInfo npuCommon.CgoCreateVDevRes) (npuCommon.CgoCreateVDevOut, error) {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return npuCommon.CgoCreateVDevOut{}, err
+	}
+
+	vdevID := vDevInfo.VDevID
+	if vdevID == 0 || vdevID == common.DefaultIDForCreateVNPU {
+		vdevID = b.nextVDevID
+	}
+	for _, fd := range b.devices {
+		for _, vdev := range fd.vdevs {
+			if vdev.VDevID == vdevID {
+				return npuCommon.CgoCreateVDevOut{}, fmt.Errorf("virtual device %d already exists", vdevID)
+			}
+		}
+	}
+
+	info, err := b.newVDevQueryStru(dev.ChipName, vdevID, vDevInfo.TemplateName)
+	if err != nil {
+		return npuCommon.CgoCreateVDevOut{}, err
+	}
+	usedAic := float32(0)
+	for _, vdev := range dev.vdevs {
+		usedAic += vdev.QueryInfo.Computing.Aic
+	}
+	if usedAic+info.QueryInfo.Computing.Aic > float32(dev.AICore) {
+		return npuCommon.CgoCreateVDevOut{}, fmt.Errorf("not enough AICore left on logic ID %d for template %s",
+			logicID, vDevInfo.TemplateName)
+	}
+
+	dev.vdevs = append(dev.vdevs, info)
+	if vdevID >= b.nextVDevID {
+		b.nextVDevID = vdevID + 1
+	}
+	return npuCommon.CgoCreateVDevOut{VDevID: vdevID}, nil
+}
+
+// DestroyVirtualDevice removes a vNPU from a device.
+func (b *FakeBackend) DestroyVirtualDevice(logicID int32, vDevID uint32) error {
+	b.Lock()
+	defer b.Unlock()
+	dev, err := b.getDevice(logicID)
+	if err != nil {
+		return err
+	}
+	for i, vdev := range dev.vdevs {
+		if vdev.VDevID == vDevID {
+			dev.vdevs = slices.Delete(dev.vdevs, i, i+1)
+			return nil
+		}
+	}
+	return fmt.Errorf("virtual device %d not found on logic ID %d", vDevID, logicID)
+}
+
+// getDevice looks up a device by logic ID. The caller must hold the lock.
+func (b *FakeBackend) getDevice(logicID int32) (*fakeDevice, error) {
+	dev, ok := b.devices[logicID]
+	if !ok {
+		return nil, fmt.Errorf("logic ID %d not found in inventory", logicID)
+	}
+	return dev, nil
+}
+
+// newVDevQueryStru builds the query info dcmi would report for a vNPU on a
+// chip of the given model. The resources come from the inventory templates of
+// the model if present, otherwise the template must be one of the well-known
+// ones, whose AICore count is derived from the template name.
+func (b *FakeBackend) newVDevQueryStru(model string, vdevID uint32, templateName string) (npuCommon.CgoVDevQueryStru, error) {
+	computing := npuCommon.CgoComputingResource{}
+	media := npuCommon.CgoMediaResource{}
+	templates, ok := b.templates[model]
+	if !ok {
+		templates = b.templates[DefaultTemplateModel]
+	}
+	if tpl, ok := templates[templateName]; ok {
+		computing.Aic = float32(tpl.AICore)
+		computing.MemorySize = uint64(tpl.MemoryGiB) * 1024
+		computing.DeviceAicpu = uint16(tpl.AICPU)
+		media = npuCommon.CgoMediaResource{
+			Vpc:   float32(tpl.VPC),
+			Venc:  float32(tpl.VENC),
+			Vdec:  float32(tpl.VDEC),
+			Jpegd: float32(tpl.JPEGD),
+			Jpege: float32(tpl.JPEGE),
+			Pngd:  float32(tpl.PNGD),
+		}
+	} else {
+		devType, ok := common.GetTemplateName2DeviceTypeMap()[templateName]
+		if !ok {
+			return npuCommon.CgoVDevQueryStru{}, fmt.Errorf("unknown template name %s", templateName)
+		}
+		aicore, err := strconv.Atoi(regexp.MustCompile(`^\d+`).FindString(devType))
+		if err != nil {
+			return npuCommon.CgoVDevQueryStru{}, fmt.Errorf("failed to derive AICore count from template %s: %v", templateName, err)
+		}
+		computing.Aic = float32(aicore)
+	}
+	return npuCommon.CgoVDevQueryStru{
+		VDevID: vdevID,
+		QueryInfo: npuCommon.CgoVDevQueryInfo{
+			Name:      templateName,
+			Computing: computing,
+			Media:     media,
+		},
+	}, nil
+}
+
+// VnpuTemplates returns the default templates of the inventory in the form
+// used by the VnpuManager, or nil if the inventory does not define any.
+func (inv *Inventory) VnpuTemplates() map[string]*VnpuTemplate {
+	return vnpuTemplates(inv.Templates)
+}
+
+// vnpuTemplates converts inventory templates into the form used by the
+// VnpuManager, or nil if there are none.
+func vnpuTemplates(inventoryTemplates []InventoryTemplate) map[string]*VnpuTemplate {
+	if len(inventoryTemplates) == 0 {
+		return nil
+	}
+	templates := make(map[string]*VnpuTemplate, len(inventoryTemplates))
+	for _, tpl := range inventoryTemplates {
+		templates[tpl.Name] = tpl.vnpuTemplate()
+	}
+	return templates
+}
+
+// vnpuTemplate converts the template into the form used by the VnpuManager.
+func (t InventoryTemplate) vnpuTemplate() *VnpuTemplate {
+	return &VnpuTemplate{
+		Name: t.Name,
+		Attributes: VnpuTemplateAttribute{
+			AICORE: t.AICore,
+			Memory: t.MemoryGiB,
+			AICPU:  t.AICPU,
+			Media:  t.MediaEngines,
+		},
+	}
+}
+
+// TemplateCatalog returns the templates of the inventory, the default ones
+// for all chip models not listed under Models, or nil if the inventory does
+// not define any.
+func (inv *Inventory) TemplateCatalog() TemplateCatalog {
+	catalog := make(TemplateCatalog)
+	if templates := inv.VnpuTemplates(); templates != nil {
+		catalog[DefaultTemplateModel] = templates
+	}
+	for model, inventoryTemplates := range inv.Models {
+		if templates := vnpuTemplates(inventoryTemplates); templates != nil {
+			catalog[model] = templates
+		}
+	}
+	if len(catalog) == 0 {
+		return nil
+	}
+	return catalog
+}
+
+// inventoryHealthState maps an inventory health value to a dcmi health state.
+func inventoryHealthState(health string) (uint32, error) {
+	switch health {
+	case "", InventoryHealthy:
+		return common.HealthStateNormal, nil
+	case InventoryWarning:
+		return common.HealthStateGeneralAlarm, nil
+	case InventoryUnhealthy:
+		return common.HealthStateUrgentAlarm, nil
+	}
+	return 0, fmt.Errorf("unknown health %q", health)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	npuCommon "huawei.com/npu-exporter/v5/devmanager/common"
+	resourceapi "k8s.io/api/resource/v1"
+
+	configapi "Ascend-dra-driver/api/example.com/resource/gpu/v1alpha2"
+)
+
+const testInventory = `
+templates:
+- name: vir01
+  aicore: 1
+  memoryGiB: 3
+- name: vir02
+  aicore: 2
+  memoryGiB: 6
+- name: vir04
+  aicore: 4
+  memoryGiB: 12
+devices:
+- logicID: 0
+  phyID: 0
+  cardID: 0
+  chipName: 310P3
+  aicore: 8
+  memoryGiB: 21
+- logicID: 1
+  phyID: 1
+  cardID: 0
+  deviceID: 1
+  chipName: 310P3
+  aicore: 8
+  memoryGiB: 21
+  virtualDevices:
+  - vdevID: 100
+    templateName: vir02
+- logicID: 2
+  phyID: 2
+  cardID: 1
+  chipName: 310P3
+  aicore: 8
+  memoryGiB: 21
+  health: Unhealthy
+`
+
+func writeTestInventory(t *testing.T, inventory string) string {
+	path := filepath.Join(t.TempDir(), "inventory.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(inventory), 0600))
+	return path
+}
+
+func newTestFakeBackend(t *testing.T, inventory string) *FakeBackend {
+	backend, err := NewFakeBackendFromFile(writeTestInventory(t, inventory))
+	require.NoError(t, err)
+	return backend
+}
+
+func TestNewFakeBackend(t *testing.T) {
+	tests := map[string]struct {
+		inventory   *Inventory
+		expectedErr string
+	}{
+		"empty inventory": {
+			inventory: &Inventory{},
+		},
+		"duplicate logic ID": {
+			inventory: &Inventory{Devices: []InventoryDevice{
+				{LogicID: 0, ChipName: "310P3"},
+				{LogicID: 0, ChipName: "310P3"},
+			}},
+			expectedErr: "duplicate logic ID 0 in inventory",
+		},
+		"missing chip name": {
+			inventory: &Inventory{Devices: []InventoryDevice{
+				{LogicID: 3},
+			}},
+			expectedErr: "chip name is required for logic ID 3",
+		},
+		"unknown health": {
+			inventory: &Inventory{Devices: []InventoryDevice{
+				{LogicID: 0, ChipName: "310P3", Health: "Broken"},
+			}},
+			expectedErr: `invalid health for logic ID 0: unknown health "Broken"`,
+		},
+		"unknown template": {
+			inventory: &Inventory{Devices: []InventoryDevice{
+				{LogicID: 0, ChipName: "310P3", VirtualDevices: []InventoryVirtualDevice{
+					{VDevID: 100, TemplateName: "vir99"},
+				}},
+			}},
+			expectedErr: "invalid virtual device on logic ID 0: unknown template name vir99",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewFakeBackend(test.inventory)
+			if test.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, test.expectedErr)
+		})
+	}
+}
+
+func TestFakeBackendVirtualDevices(t *testing.T) {
+	backend := newTestFakeBackend(t, testInventory)
+
+	num, logicIDs, err := backend.GetDeviceList()
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), num)
+	assert.Equal(t, []int32{0, 1, 2}, logicIDs)
+
+	info, err := backend.GetVirtualDeviceInfo(1)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), info.TotalResource.VDevNum)
+	assert.Equal(t, float32(6), info.FreeResource.Computing.Aic)
+	assert.Equal(t, uint64(15*1024), info.FreeResource.Computing.MemorySize)
+
+	out, err := backend.CreateVirtualDevice(0, npuCommon.CgoCreateVDevRes{TemplateName: "vir04"})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(101), out.VDevID)
+
+	_, err = backend.CreateVirtualDevice(0, npuCommon.CgoCreateVDevRes{TemplateName: "vir08"})
+	assert.EqualError(t, err, "not enough AICore left on logic ID 0 for template vir08")
+
+	require.NoError(t, backend.DestroyVirtualDevice(0, out.VDevID))
+	assert.EqualError(t, backend.DestroyVirtualDevice(0, out.VDevID), "virtual device 101 not found on logic ID 0")
+
+	_, err = backend.GetChipInfo(7)
+	assert.EqualError(t, err, "logic ID 7 not found in inventory")
+}
+
+func TestFakeBackendInventoryTemplates(t *testing.T) {
+	backend := newTestFakeBackend(t, `
+templates:
+- {name: vir05_1c_16g, aicore: 5, memoryGiB: 16, aicpu: 1}
+devices:
+- {logicID: 0, phyID: 0, chipName: 910B3, aicore: 20, memoryGiB: 64}
+`)
+
+	// Templates the inventory defines need not be well-known ones.
+	out, err := backend.CreateVirtualDevice(0, npuCommon.CgoCreateVDevRes{TemplateName: "vir05_1c_16g"})
+	require.NoError(t, err)
+	info, err := backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	require.Len(t, info.VDevInfo, 1)
+	assert.Equal(t, out.VDevID, info.VDevInfo[0].VDevID)
+	assert.Equal(t, float32(5), info.VDevInfo[0].QueryInfo.Computing.Aic)
+	assert.Equal(t, uint64(16*1024), info.VDevInfo[0].QueryInfo.Computing.MemorySize)
+
+	_, err = backend.CreateVirtualDevice(0, npuCommon.CgoCreateVDevRes{TemplateName: "vir10_3c_32g"})
+	assert.EqualError(t, err, "unknown template name vir10_3c_32g")
+}
+
+func TestLoadInventoryJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	content := `{"templates": [{"name": "vir08", "aicore": 8, "memoryGiB": 32}],
+		"devices": [{"logicID": 4, "phyID": 4, "chipName": "910B", "aicore": 24, "memoryGiB": 64}]}`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	inventory, err := LoadInventory(path)
+	require.NoError(t, err)
+	require.Len(t, inventory.Devices, 1)
+	assert.Equal(t, "910B", inventory.Devices[0].ChipName)
+	assert.Equal(t, map[string]*VnpuTemplate{
+		"vir08": {Name: "vir08", Attributes: VnpuTemplateAttribute{AICORE: 8, Memory: 32}},
+	}, inventory.VnpuTemplates())
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"devices": [{"logicID": 0, "cores": 8}]}`), 0600))
+	_, err = LoadInventory(path)
+	assert.Error(t, err)
+}
+
+func TestEnumerateDevicesWithFakeBackend(t *testing.T) {
+	backend := newTestFakeBackend(t, testInventory)
+	inventory, err := LoadInventory(writeTestInventory(t, testInventory))
+	require.NoError(t, err)
+
+	allocatable, vnpuManager, existingVnpus, err := enumerateAllPossibleDevices(backend, inventory.TemplateCatalog())
+	require.NoError(t, err)
+	require.NotNil(t, vnpuManager)
+	assert.Len(t, vnpuManager.Catalog.ForModel("310P3"), 3)
+	require.Len(t, existingVnpus, 1)
+	assert.Equal(t, int32(1), existingVnpus[0].LogicID)
+	assert.Equal(t, uint32(100), existingVnpus[0].VDevID)
+	assert.Equal(t, "vir02", existingVnpus[0].TemplateName)
+
+	// The unhealthy chip is enumerated too, the health monitor decides
+	// whether it is published.
+	assert.Len(t, allocatable, 3)
+	assert.Contains(t, allocatable, "npu-0-0")
+	assert.Contains(t, allocatable, "npu-1-0")
+	assert.Contains(t, allocatable, "npu-2-0")
+	assert.Equal(t, "310P3", *allocatable["npu-0-0"].Attributes[DriverDomain+"model"].StringValue)
+	// A split chip is still published under its chip model.
+	assert.Equal(t, "310P3", *allocatable["npu-1-0"].Attributes[DriverDomain+"model"].StringValue)
+	assert.Equal(t, int64(8), *allocatable["npu-0-0"].Attributes[DriverDomain+"aicore"].IntValue)
+	assert.Equal(t, int64(21), *allocatable["npu-0-0"].Attributes[DriverDomain+"memory"].IntValue)
+	// The templates of the chip are published for the controller.
+	assert.Equal(t, "aicore=2,memoryGiB=6", *allocatable["npu-0-0"].Attributes[DriverDomain+"vnpu_vir02"].StringValue)
+	assert.Equal(t, "aicore=4,memoryGiB=12", *allocatable["npu-1-0"].Attributes[DriverDomain+"vnpu_vir04"].StringValue)
+}
+
+func TestEnumerateVnpusOfDriverTemplates(t *testing.T) {
+	inventory := `
+templates:
+- {name: vir05_1c_16g, aicore: 5, memoryGiB: 16, aicpu: 1}
+devices:
+- logicID: 0
+  phyID: 0
+  chipName: 910B3
+  aicore: 20
+  memoryGiB: 64
+  virtualDevices:
+  - {vdevID: 100, templateName: vir05_1c_16g}
+`
+	backend := newTestFakeBackend(t, inventory)
+	parsed, err := LoadInventory(writeTestInventory(t, inventory))
+	require.NoError(t, err)
+
+	// vNPUs of templates outside the well-known ones are found too.
+	allocatable, _, existingVnpus, err := enumerateAllPossibleDevices(backend, parsed.TemplateCatalog())
+	require.NoError(t, err)
+	assert.Contains(t, allocatable, "npu-0-0")
+	require.Len(t, existingVnpus, 1)
+	assert.Equal(t, uint32(100), existingVnpus[0].VDevID)
+	assert.Equal(t, "vir05_1c_16g", existingVnpus[0].TemplateName)
+}
+
+const mixedTestInventory = `
+templates:
+- {name: vir02, aicore: 2, memoryGiB: 6}
+- {name: vir04, aicore: 4, memoryGiB: 12}
+models:
+  310P1:
+  - {name: vir01, aicore: 1, memoryGiB: 2}
+  - {name: vir08, aicore: 8, memoryGiB: 16, aicpu: 7}
+devices:
+- {logicID: 0, phyID: 0, cardID: 0, chipName: 310P3, aicore: 8, memoryGiB: 21}
+- {logicID: 1, phyID: 1, cardID: 1, chipName: 310P1, aicore: 8, memoryGiB: 16, aicpu: 7}
+`
+
+func TestEnumerateMixedChipModels(t *testing.T) {
+	state, backend := newTestDeviceState(t, mixedTestInventory)
+
+	// Each chip is published with its own model, resources and templates.
+	require.Len(t, state.allocatable, 2)
+	attributes := state.allocatable["npu-0-0"].Attributes
+	assert.Equal(t, "310P3", *attributes[DriverDomain+"model"].StringValue)
+	assert.Equal(t, int64(8), *attributes[DriverDomain+"aicore"].IntValue)
+	assert.Equal(t, int64(21), *attributes[DriverDomain+"memory"].IntValue)
+	assert.Contains(t, attributes, resourceapi.QualifiedName(DriverDomain+"vnpu_vir04"))
+	assert.NotContains(t, attributes, resourceapi.QualifiedName(DriverDomain+"vnpu_vir08"))
+
+	attributes = state.allocatable["npu-1-0"].Attributes
+	assert.Equal(t, "310P1", *attributes[DriverDomain+"model"].StringValue)
+	assert.Equal(t, int64(8), *attributes[DriverDomain+"aicore"].IntValue)
+	assert.Equal(t, int64(16), *attributes[DriverDomain+"memory"].IntValue)
+	assert.Equal(t, "aicore=8,memoryGiB=16,aicpu=7", *attributes[DriverDomain+"vnpu_vir08"].StringValue)
+	assert.NotContains(t, attributes, resourceapi.QualifiedName(DriverDomain+"vnpu_vir04"))
+
+	// vNPUs are created with the templates of their chip model, the 310P1
+	// has no vir04.
+	config := configapi.DefaultNpuConfig()
+	config.Vnpu = &configapi.VnpuSpec{AICore: 4}
+	_, err := state.Prepare(newTestClaimWithConfig(t, "uid-1", config, "npu-1-0"))
+	require.NoError(t, err)
+	info, err := backend.GetVirtualDeviceInfo(1)
+	require.NoError(t, err)
+	require.Len(t, info.VDevInfo, 1)
+	assert.Equal(t, "vir08", info.VDevInfo[0].QueryInfo.Name)
+	assert.Equal(t, float32(8), info.VDevInfo[0].QueryInfo.Computing.Aic)
+}
+
+// chipInfoFailingBackend fails to report the chip info of one logic ID.
+type chipInfoFailingBackend struct {
+	*FakeBackend
+	logicID int32
+}
+
+func (b *chipInfoFailingBackend) GetChipInfo(logicID int32) (*npuCommon.ChipInfo, error) {
+	if logicID == b.logicID {
+		return nil, fmt.Errorf("dcmi error")
+	}
+	return b.FakeBackend.GetChipInfo(logicID)
+}
+
+func TestEnumerateDevicesSkipsChipWithoutInfo(t *testing.T) {
+	backend := &chipInfoFailingBackend{FakeBackend: newTestFakeBackend(t, mixedTestInventory), logicID: 1}
+
+	allocatable, _, _, err := enumerateAllPossibleDevices(backend, nil)
+	require.NoError(t, err)
+	assert.Len(t, allocatable, 1)
+	assert.Contains(t, allocatable, "npu-0-0")
+}
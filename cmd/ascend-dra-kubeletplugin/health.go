@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/ptr"
+
+	"Ascend-dra-driver/pkg/common"
+)
+
+// DeviceHealthState is the last health observed for a physical NPU.
+type DeviceHealthState struct {
+	common.DeviceHealth
+	// HealthCode is the health state reported by dcmi.
+	HealthCode uint32
+	ErrorCodes []int64
+	// Since is the time the device entered this state.
+	Since metav1.Time
+}
+
+// HealthMonitor polls the health and error codes of the physical NPUs and
+// keeps the last known state of each of them, so that devices of faulty chips
+// can be tainted or withdrawn from the ResourceSlice.
+type HealthMonitor struct {
+	sync.Mutex
+	mgr        *AscendManager
+	interval   time.Duration
+	faultCodes map[int64]string
+	taints     bool
+	devices    map[int32]*DeviceHealthState
+	status     common.DevStatusSet
+}
+
+// NewHealthMonitor creates a HealthMonitor polling the backend at the given
+// interval. A zero interval disables polling after the initial check. The
+// fault codes map dcmi error codes to fault classes, see LoadFaultCodes.
+func NewHealthMonitor(backend NpuBackend, interval time.Duration, faultCodes map[int64]string) *HealthMonitor {
+	return &HealthMonitor{
+		mgr:        NewAscendManager(backend),
+		interval:   interval,
+		faultCodes: faultCodes,
+		taints:     true,
+		devices:    make(map[int32]*DeviceHealthState),
+		status: common.DevStatusSet{
+			UnHealthyDevice:    sets.NewString(),
+			NetUnHealthyDevice: sets.NewString(),
+			FreeHealthyDevice:  make(map[string]sets.String),
+		},
+	}
+}
+
+// Check polls every device once and reports whether the health of any of
+// them changed since the previous check.
+func (h *HealthMonitor) Check() bool {
+	_, logicIDs, err := h.mgr.mgr.GetDeviceList()
+	if err != nil {
+		log.Printf("Failed to list NPU devices for health check: %v", err)
+		return false
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	changed := false
+	for _, logicID := range logicIDs {
+		deviceName := fmt.Sprintf("npu-%d-0", logicID)
+		state, err := h.mgr.GetDeviceHealthState(logicID)
+		if err != nil {
+			log.Printf("Failed to get health of NPU device %s: %v", deviceName, err)
+		}
+
+		previous, known := h.devices[logicID]
+		if known && previous.Health == state.Health && previous.HealthCode == state.HealthCode &&
+			slices.Equal(previous.ErrorCodes, state.ErrorCodes) {
+			continue
+		}
+		changed = true
+		state.Since = metav1.Now().Rfc3339Copy()
+		h.devices[logicID] = &state
+		if state.Health == common.UnHealthyState {
+			h.status.UnHealthyDevice.Insert(deviceName)
+		} else {
+			h.status.UnHealthyDevice.Delete(deviceName)
+		}
+		if known || state.Health != common.HealthyState {
+			log.Printf("NPU device %s is %s, health code: %d, error codes: %v",
+				deviceName, state.Health, state.HealthCode, formatErrorCodes(state.ErrorCodes))
+		}
+	}
+	return changed
+}
+
+// Run polls the devices until the context is done and calls onChange whenever
+// the health of a device changed.
+func (h *HealthMonitor) Run(ctx context.Context, onChange func()) {
+	if h.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if h.Check() {
+				onChange()
+			}
+		}
+	}
+}
+
+// Health returns the last observed health of a device. Devices that have not
+// been checked yet are considered healthy.
+func (h *HealthMonitor) Health(logicID int32) DeviceHealthState {
+	h.Lock()
+	defer h.Unlock()
+
+	state, ok := h.devices[logicID]
+	if !ok {
+		return DeviceHealthState{DeviceHealth: common.DeviceHealth{Health: common.HealthyState}}
+	}
+	return *state
+}
+
+// Usable reports whether vNPUs may be carved from a chip, i.e. it is not
+// known to be faulty.
+func (h *HealthMonitor) Usable(logicID int32) bool {
+	return h.Health(logicID).Health != common.UnHealthyState
+}
+
+// UnhealthyDevices returns the names of the physical devices currently
+// considered unhealthy.
+func (h *HealthMonitor) UnhealthyDevices() []string {
+	h.Lock()
+	defer h.Unlock()
+	return h.status.UnHealthyDevice.List()
+}
+
+// DisableTaints makes the monitor withdraw the devices of unhealthy chips
+// instead of tainting them, for clusters without device taint support. It
+// reports whether taints were enabled before.
+func (h *HealthMonitor) DisableTaints() bool {
+	h.Lock()
+	defer h.Unlock()
+	enabled := h.taints
+	h.taints = false
+	return enabled
+}
+
+// ApplyHealth returns the devices to publish, sorted by name. Every device
+// carries the health of its chip, and devices of faulty chips are tainted
+// with the fault class of the chip. If taints are disabled, devices of
+// unhealthy chips are left out instead, so that the scheduler stops placing
+// new claims on them.
+func (h *HealthMonitor) ApplyHealth(allocatable AllocatableDevices) []resourceapi.Device {
+	h.Lock()
+	taints := h.taints
+	h.Unlock()
+
+	var devices []resourceapi.Device
+	for _, device := range allocatable {
+		index, ok := device.Attributes[DriverDomain+"index"]
+		if !ok || index.IntValue == nil {
+			devices = append(devices, device)
+			continue
+		}
+		state := h.Health(int32(*index.IntValue))
+		if !taints && state.Health == common.UnHealthyState {
+			continue
+		}
+		device = *device.DeepCopy()
+		device.Attributes[DriverDomain+"health"] = resourceapi.DeviceAttribute{StringValue: ptr.To(state.Health)}
+		if taints {
+			if taint := FaultTaint(state, h.faultCodes); taint != nil {
+				device.Taints = append(device.Taints, *taint)
+			}
+		}
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+	return devices
+}
+
+// formatErrorCodes formats dcmi error codes the way npu-smi prints them.
+func formatErrorCodes(codes []int64) []string {
+	formatted := make([]string, 0, len(codes))
+	for _, code := range codes {
+		formatted = append(formatted, fmt.Sprintf("0x%X", code))
+	}
+	return formatted
+}
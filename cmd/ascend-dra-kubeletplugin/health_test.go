@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"Ascend-dra-driver/pkg/common"
+)
+
+// publishedHealth returns the health attribute of every published device.
+func publishedHealth(monitor *HealthMonitor, allocatable AllocatableDevices) map[string]string {
+	health := make(map[string]string)
+	for _, device := range monitor.ApplyHealth(allocatable) {
+		health[device.Name] = *device.Attributes[DriverDomain+"health"].StringValue
+	}
+	return health
+}
+
+// publishedTaints returns the fault taint of every published device, as
+// "<fault class>:<effect>".
+func publishedTaints(monitor *HealthMonitor, allocatable AllocatableDevices) map[string]string {
+	taints := make(map[string]string)
+	for _, device := range monitor.ApplyHealth(allocatable) {
+		for _, taint := range device.Taints {
+			if taint.Key == FaultTaintKey {
+				taints[device.Name] = taint.Value + ":" + string(taint.Effect)
+			}
+		}
+	}
+	return taints
+}
+
+func TestHealthMonitor(t *testing.T) {
+	state, backend := newTestDeviceState(t, testInventory)
+	faultCodes := map[int64]string{
+		0x80E01801: common.NotHandleFault,
+		0x80C98009: common.RestartNPU,
+	}
+	monitor := NewHealthMonitor(backend, 0, faultCodes)
+	// Drop the full card of the chip carrying the adopted vNPU, as the driver
+	// does before publishing.
+	(&driver{state: state}).syncAllocatable()
+
+	assert.True(t, monitor.Check())
+	assert.False(t, monitor.Check())
+	assert.Equal(t, []string{"npu-2-0"}, monitor.UnhealthyDevices())
+	assert.Equal(t, map[string]string{
+		"npu-0-0": common.HealthyState,
+		"npu-1-1": common.HealthyState,
+		"npu-1-2": common.HealthyState,
+		"npu-2-0": common.UnHealthyState,
+	}, publishedHealth(monitor, state.allocatable))
+	assert.Equal(t, map[string]string{
+		"npu-2-0": common.SeparateNPU + ":NoExecute",
+	}, publishedTaints(monitor, state.allocatable))
+	// Neither the health attribute nor the taints leak into the allocatable
+	// devices.
+	assert.NotContains(t, state.allocatable["npu-2-0"].Attributes, DriverDomain+"health")
+	assert.Empty(t, state.allocatable["npu-2-0"].Taints)
+
+	// Faults are classified by the fault code table, the taint records when
+	// the chip entered its state.
+	require.NoError(t, backend.SetDeviceHealth(0, InventoryHealthy, []int64{0x80E01801}))
+	require.NoError(t, backend.SetDeviceHealth(1, InventoryUnhealthy, []int64{0x80C98009}))
+	assert.True(t, monitor.Check())
+	health := monitor.Health(0)
+	assert.Equal(t, common.WarningState, health.Health)
+	assert.Equal(t, []int64{0x80E01801}, health.ErrorCodes)
+	assert.False(t, health.Since.IsZero())
+	assert.Equal(t, []string{"npu-1-0", "npu-2-0"}, monitor.UnhealthyDevices())
+	assert.Equal(t, map[string]string{
+		"npu-1-1": common.RestartNPU + ":NoExecute",
+		"npu-1-2": common.RestartNPU + ":NoExecute",
+		"npu-2-0": common.SeparateNPU + ":NoExecute",
+	}, publishedTaints(monitor, state.allocatable))
+	for _, device := range monitor.ApplyHealth(state.allocatable) {
+		if device.Name == "npu-1-1" {
+			assert.Equal(t, monitor.Health(1).Since, *device.Taints[0].TimeAdded)
+		}
+	}
+
+	// Recovered chips are published without taints again.
+	require.NoError(t, backend.SetDeviceHealth(1, InventoryHealthy, nil))
+	require.NoError(t, backend.SetDeviceHealth(2, InventoryWarning, nil))
+	assert.True(t, monitor.Check())
+	assert.Empty(t, monitor.UnhealthyDevices())
+	assert.Equal(t, map[string]string{
+		"npu-0-0": common.WarningState,
+		"npu-1-1": common.HealthyState,
+		"npu-1-2": common.HealthyState,
+		"npu-2-0": common.WarningState,
+	}, publishedHealth(monitor, state.allocatable))
+	assert.Empty(t, publishedTaints(monitor, state.allocatable))
+}
+
+func TestHealthMonitorWithoutTaints(t *testing.T) {
+	state, backend := newTestDeviceState(t, testInventory)
+	monitor := NewHealthMonitor(backend, 0, nil)
+	(&driver{state: state}).syncAllocatable()
+	assert.True(t, monitor.Check())
+
+	assert.True(t, monitor.DisableTaints())
+	assert.False(t, monitor.DisableTaints())
+
+	// Devices of unhealthy chips are withdrawn instead of tainted.
+	require.NoError(t, backend.SetDeviceHealth(0, InventoryWarning, nil))
+	require.NoError(t, backend.SetDeviceHealth(1, InventoryUnhealthy, nil))
+	assert.True(t, monitor.Check())
+	assert.Equal(t, map[string]string{
+		"npu-0-0": common.WarningState,
+	}, publishedHealth(monitor, state.allocatable))
+	assert.Empty(t, publishedTaints(monitor, state.allocatable))
+}
@@ -22,12 +22,14 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
 	coreclientset "k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 
+	configapi "Ascend-dra-driver/api/example.com/resource/gpu/v1alpha2"
 	"Ascend-dra-driver/pkg/flags"
 )
 
@@ -36,23 +38,41 @@ const (
 	DriverDomainName = "npu.example.com"
 	DriverDomain     = "npu.example.com/"
 
-	PluginRegistrationPath     = "/var/lib/kubelet/plugins_registry/" + DriverName + ".sock"
-	DriverPluginPath           = "/var/lib/kubelet/plugins/" + DriverName
-	DriverPluginSocketPath     = DriverPluginPath + "/plugin.sock"
-	DriverPluginCheckpointFile = "checkpoint.json"
+	PluginRegistrationDirectoryPath = "/var/lib/kubelet/plugins_registry"
+	DriverPluginPath                = "/var/lib/kubelet/plugins/" + DriverName
+	DriverPluginCheckpointFile      = "checkpoint.json"
+	RankTableDirName                = "ranktables"
 )
 
 type Flags struct {
 	kubeClientConfig flags.KubeClientConfig
 	loggingConfig    *flags.LoggingConfig
 
-	nodeName string
-	cdiRoot  string
+	nodeName            string
+	cdiRoot             string
+	simulateInventory   string
+	existingVnpuPolicy  string
+	vnpuPlacement       string
+	healthCheckInterval time.Duration
+	faultCodeFile       string
+	metricsAddress      string
+	cdiProfileFile      string
+	templatePaths       cli.StringSlice
+	npuSmiPath          string
+
+	partitionableDevices bool
 }
 
 type Config struct {
 	flags      *Flags
 	coreclient coreclientset.Interface
+	backend    NpuBackend
+	templates  *TemplateRegistry
+
+	existingVnpuPolicy ExistingVnpuPolicy
+	vnpuPlacement      configapi.VnpuPlacement
+	faultCodes         map[int64]string
+	cdiProfiles        CDIProfiles
 }
 
 func main() {
@@ -81,6 +101,72 @@ func newApp() *cli.App {
 			Destination: &flags.cdiRoot,
 			EnvVars:     []string{"CDI_ROOT"},
 		},
+		&cli.StringFlag{
+			Name:        "cdi-profile-file",
+			Usage:       "Path to a JSON or YAML file mapping chip models to the device nodes and driver mounts injected into containers through CDI. Models not listed use the 'default' profile.",
+			Destination: &flags.cdiProfileFile,
+			EnvVars:     []string{"CDI_PROFILE_FILE"},
+		},
+		&cli.StringSliceFlag{
+			Name:        "template-path",
+			Usage:       "Files or directories to read the vNPU templates from: YAML or JSON files listing the templates per chip model, or the output of 'npu-smi info -t template-info' in template-info.txt (all models) or template-info-<model>.txt. The templates are reloaded when the files change.",
+			Value:       cli.NewStringSlice(DefaultTemplatePath),
+			Destination: &flags.templatePaths,
+			EnvVars:     []string{"TEMPLATE_PATHS"},
+		},
+		&cli.StringFlag{
+			Name:        "npu-smi-path",
+			Usage:       "Path to npu-smi, used to query the vNPU templates supported by the installed driver at startup. The template files add to these templates, only those listed for a model replace them. Empty to only use the template files.",
+			Value:       DefaultNpuSmiPath,
+			Destination: &flags.npuSmiPath,
+			EnvVars:     []string{"NPU_SMI_PATH"},
+		},
+		&cli.StringFlag{
+			Name:        "simulate-inventory",
+			Usage:       "Path to a JSON or YAML inventory file describing simulated NPUs. If set, the plugin runs without Ascend hardware and serves the devices from this file instead of dcmi.",
+			Destination: &flags.simulateInventory,
+			EnvVars:     []string{"SIMULATE_INVENTORY"},
+		},
+		&cli.StringFlag{
+			Name:        "existing-vnpu-policy",
+			Usage:       "What to do with vNPUs found on the chips at startup that no prepared claim owns: 'adopt' publishes them as devices of their own, 'cleanup' destroys them.",
+			Value:       string(ExistingVnpuPolicyAdopt),
+			Destination: &flags.existingVnpuPolicy,
+			EnvVars:     []string{"EXISTING_VNPU_POLICY"},
+		},
+		&cli.StringFlag{
+			Name:        "vnpu-placement",
+			Usage:       "Default policy choosing the chip a vNPU is carved from among the chips of the devices allocated to the claim for vNPUs that have the model named by the scheduler: 'Scheduler' keeps the named chip, 'Binpack' prefers the most used chip, 'Spread' the least used one and 'SameTemplate' the chips running vNPUs of the same template. The policies only move vNPUs between the devices of a claim and have no effect on claims with a single device. The vnpu.placement field of an NpuConfig overrides it.",
+			Value:       string(configapi.SchedulerPlacement),
+			Destination: &flags.vnpuPlacement,
+			EnvVars:     []string{"VNPU_PLACEMENT"},
+		},
+		&cli.BoolFlag{
+			Name:        "partitionable-devices",
+			Usage:       "Publish every NPU as a set of shared counters with each vNPU its templates allow as a device consuming from them, so that the scheduler packs the vNPUs. Requires the DRAPartitionableDevices feature gate.",
+			Destination: &flags.partitionableDevices,
+			EnvVars:     []string{"PARTITIONABLE_DEVICES"},
+		},
+		&cli.DurationFlag{
+			Name:        "health-check-interval",
+			Usage:       "Interval at which the health and error codes of the NPUs are polled. Devices of faulty NPUs are tainted in the ResourceSlice. 0 disables polling after startup.",
+			Value:       5 * time.Second,
+			Destination: &flags.healthCheckInterval,
+			EnvVars:     []string{"HEALTH_CHECK_INTERVAL"},
+		},
+		&cli.StringFlag{
+			Name:        "fault-code-file",
+			Usage:       "Path to a JSON or YAML file in the faultCode.json format of the Ascend device plugin, mapping dcmi error codes to fault classes. Codes not listed are classified by the health state of the NPU.",
+			Destination: &flags.faultCodeFile,
+			EnvVars:     []string{"FAULT_CODE_FILE"},
+		},
+		&cli.StringFlag{
+			Name:        "metrics-address",
+			Usage:       "The address the Prometheus /metrics endpoint listens on. An empty address disables it.",
+			Value:       ":8080",
+			Destination: &flags.metricsAddress,
+			EnvVars:     []string{"METRICS_ADDRESS"},
+		},
 	}
 	cliFlags = append(cliFlags, flags.kubeClientConfig.Flags()...)
 	cliFlags = append(cliFlags, flags.loggingConfig.Flags()...)
@@ -96,9 +182,40 @@ func newApp() *cli.App {
 				return fmt.Errorf("create client: %v", err)
 			}
 
+			if err := checkResourceAPI(clientSets.Core.Discovery()); err != nil {
+				return err
+			}
+
+			existingVnpuPolicy, err := ParseExistingVnpuPolicy(flags.existingVnpuPolicy)
+			if err != nil {
+				return err
+			}
+
+			vnpuPlacement, err := ParseVnpuPlacement(flags.vnpuPlacement)
+			if err != nil {
+				return err
+			}
+
 			config := &Config{
-				flags:      flags,
-				coreclient: clientSets.Core,
+				flags:              flags,
+				coreclient:         clientSets.Core,
+				existingVnpuPolicy: existingVnpuPolicy,
+				vnpuPlacement:      vnpuPlacement,
+			}
+			if flags.cdiProfileFile != "" {
+				config.cdiProfiles, err = LoadCDIProfiles(flags.cdiProfileFile)
+				if err != nil {
+					return err
+				}
+			}
+			if flags.faultCodeFile != "" {
+				config.faultCodes, err = LoadFaultCodes(flags.faultCodeFile)
+				if err != nil {
+					return err
+				}
+			}
+			if err := config.initBackend(ctx); err != nil {
+				return fmt.Errorf("create NPU backend: %v", err)
 			}
 
 			return StartPlugin(ctx, config)
@@ -108,6 +225,47 @@ func newApp() *cli.App {
 	return app
 }
 
+// initBackend selects the NPU backend: the simulated inventory if one was
+// given, the dcmi based devmanager otherwise.
+func (c *Config) initBackend(ctx context.Context) error {
+	if c.flags.simulateInventory == "" {
+		backend, err := NewDcmiBackend()
+		if err != nil {
+			return err
+		}
+		c.backend = backend
+		var driverTemplates TemplateCatalog
+		if c.flags.npuSmiPath != "" {
+			driverTemplates, err = QueryDriverTemplates(ctx, backend, NewNpuSmi(c.flags.npuSmiPath))
+			if err != nil {
+				klog.Warningf("Failed to query the vNPU templates from the driver, using the template files only: %v", err)
+			} else {
+				klog.Infof("Driver reports vNPU templates %v", driverTemplates.Names())
+			}
+		}
+		c.templates, err = NewTemplateRegistry(c.flags.templatePaths.Value(), driverTemplates)
+		return err
+	}
+
+	inventory, err := LoadInventory(c.flags.simulateInventory)
+	if err != nil {
+		return err
+	}
+	backend, err := NewFakeBackend(inventory)
+	if err != nil {
+		return err
+	}
+	c.backend = backend
+	// Templates of the inventory take precedence over the template files.
+	if catalog := inventory.TemplateCatalog(); catalog != nil {
+		c.templates = NewStaticTemplateRegistry(catalog)
+	} else if c.templates, err = NewTemplateRegistry(c.flags.templatePaths.Value(), nil); err != nil {
+		return err
+	}
+	klog.Infof("Simulating %d NPU devices from inventory %s", len(inventory.Devices), c.flags.simulateInventory)
+	return nil
+}
+
 func StartPlugin(ctx context.Context, config *Config) error {
 	err := os.MkdirAll(DriverPluginPath, 0750)
 	if err != nil {
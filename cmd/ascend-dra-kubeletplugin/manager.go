@@ -19,10 +19,8 @@ package main
 import (
 	"Ascend-dra-driver/pkg/common"
 	"fmt"
-	"strconv"
-	"strings"
+	"log"
 
-	"huawei.com/npu-exporter/v5/devmanager"
 	npuCommon "huawei.com/npu-exporter/v5/devmanager/common"
 )
 
@@ -38,84 +36,44 @@ type Device struct {
 }
 
 type AscendManager struct {
-	mgr *devmanager.DeviceManager
+	mgr NpuBackend
 	//nodeName string
 	devs []*Device
 }
 
-func NewAscendManager() (*AscendManager, error) {
-	mgr, err := devmanager.AutoInit("")
-	if err != nil {
-		return nil, err
-	}
+func NewAscendManager(backend NpuBackend) *AscendManager {
 	return &AscendManager{
-		mgr:  mgr,
+		mgr:  backend,
 		devs: []*Device{},
-	}, nil
-}
-
-func (am *AscendManager) getAiCoreCount(cgoVDevInfo npuCommon.VirtualDevInfo) (int32, error) {
-	chipAICore := cgoVDevInfo.TotalResource.Computing.Aic
-	if chipAICore < common.MinAICoreNum || chipAICore > common.MaxAICoreNum {
-		return 0, fmt.Errorf("invalid ai core num %f", chipAICore)
 	}
-	return int32(chipAICore), nil
 }
 
-func (am *AscendManager) getMemorySize(cgoVDevInfo npuCommon.VirtualDevInfo) (int32, error) {
-	memorySize := cgoVDevInfo.TotalResource.Computing.MemorySize
-	memorySize = memorySize / 1024
-	if memorySize <= 0 || memorySize > 1024 {
-		return 0, fmt.Errorf("invalid memory size %d", memorySize)
+// GetDeviceHealthState reads the dcmi health and error codes of a device and
+// maps them to the health published in the ResourceSlice. Minor alarms are
+// tolerated, anything worse makes the device unhealthy. A device that cannot
+// be queried is treated like one reporting a major alarm.
+func (am *AscendManager) GetDeviceHealthState(logicID int32) (DeviceHealthState, error) {
+	state := DeviceHealthState{
+		DeviceHealth: common.DeviceHealth{Health: common.UnHealthyState},
+		HealthCode:   common.HealthStateImportantAlarm,
 	}
-	return int32(memorySize), nil
-}
-
-// GetChipMem get chip memory size
-func (am *AscendManager) GetChipMem() (int32, error) {
-	_, logicIDs, err := am.mgr.GetDeviceList()
+	health, err := am.mgr.GetDeviceHealth(logicID)
 	if err != nil {
-		return 0, err
-	}
-	if len(logicIDs) < 1 {
-		return 0, fmt.Errorf("not found logicIDs")
-	}
-	for _, logicID := range logicIDs {
-		cgoVDevInfo, err := am.mgr.GetVirtualDeviceInfo(logicID)
-		if err != nil && strings.Contains(err.Error(), strconv.Itoa(common.DeviceNotSupport)) {
-			return common.DeviceNotSupport, nil
-		}
-		if err != nil {
-			// if not support found memory size, setting a default value
-			return 32, nil
-		}
-		return am.getMemorySize(cgoVDevInfo)
+		return state, fmt.Errorf("get device health failed: %v", err)
 	}
-	return 0, fmt.Errorf("not get memory size")
-}
-
-// GetChipAiCoreCount get chip aicore count
-func (am *AscendManager) GetChipAiCoreCount() (int32, error) {
-	_, logicIDs, err := am.mgr.GetDeviceList()
+	_, errorCodes, err := am.mgr.GetDeviceAllErrorCode(logicID)
 	if err != nil {
-		return 0, err
-	}
-	if len(logicIDs) < 1 {
-		return 0, fmt.Errorf("not found logicIDs")
+		return state, fmt.Errorf("get device error codes failed: %v", err)
 	}
-	for _, logicID := range logicIDs {
-		cgoVDevInfo, err := am.mgr.GetVirtualDeviceInfo(logicID)
-		if err != nil && strings.Contains(err.Error(), strconv.Itoa(common.DeviceNotSupport)) {
-			return common.DeviceNotSupport, nil
-		}
-		if err != nil {
-			// if not support found aicore number, setting a default value
-
-			return common.DefaultAiCoreNum, nil
-		}
-		return am.getAiCoreCount(cgoVDevInfo)
+	state.HealthCode = health
+	state.ErrorCodes = errorCodes
+	switch {
+	case health == common.HealthStateNormal && len(errorCodes) == 0:
+		state.Health = common.HealthyState
+	case health == common.HealthStateNormal, health == common.HealthStateGeneralAlarm:
+		state.Health = common.WarningState
 	}
-	return 0, fmt.Errorf("not get aicore count")
+	return state, nil
 }
 
 func (am *AscendManager) getDavinCiDev(logicID int32) (common.DavinCiDev, error) {
@@ -147,6 +105,7 @@ func (am *AscendManager) assemblePhyDevices(devType string, davinCiDev common.Da
 ) {
 	deviceName := fmt.Sprintf("%s-%d", devType, davinCiDev.PhyID)
 	device := am.assembleNpuDeviceStruct(devType, deviceName, davinCiDev)
+	device.ChipName = devType
 	*devices = append(*devices, device)
 }
 
@@ -162,17 +121,28 @@ func (am *AscendManager) assembleNpuDeviceStruct(deviType, deviceName string,
 	}
 }
 
+// assembleVirtualDevices lists the vNPUs of a chip. The chip itself is listed
+// if none of its vNPUs can be read, so that it is published all the same.
 func (am *AscendManager) assembleVirtualDevices(chipType string, davinCiDev common.DavinCiDev,
 	vDevInfos npuCommon.VirtualDevInfo,
 	devices *[]common.NpuDevice) {
+	listed := false
 	for _, subVDevInfo := range vDevInfos.VDevInfo {
 		vDeviType, deviceName, err := am.assembleSpecVirtualDevice(chipType, davinCiDev.PhyID, subVDevInfo)
 		if err != nil {
+			log.Printf("Ignoring vNPU %d on logic ID %d: %v", subVDevInfo.VDevID, davinCiDev.LogicID, err)
 			continue
 		}
+		listed = true
 		device := am.assembleNpuDeviceStruct(vDeviType, deviceName, davinCiDev)
+		device.ChipName = chipType
+		device.VDevID = subVDevInfo.VDevID
+		device.TemplateName = subVDevInfo.QueryInfo.Name
 		*devices = append(*devices, device)
 	}
+	if !listed {
+		am.assemblePhyDevices(chipType, davinCiDev, devices)
+	}
 }
 
 func (am *AscendManager) assembleSpecVirtualDevice(chipType string, phyID int32,
@@ -182,11 +152,15 @@ func (am *AscendManager) assembleSpecVirtualDevice(chipType string, phyID int32,
 	if coreNum <= 0 {
 		return "", "", fmt.Errorf("invalid vdev info, ai core is 0")
 	}
-	vDeviType, exist := common.GetTemplateName2DeviceTypeMap()[vDevInfo.QueryInfo.Name]
-	if !exist {
-		return "", "", fmt.Errorf("check templatename failed, templatename is %s", vDevInfo.QueryInfo.Name)
+	if vDevInfo.QueryInfo.Name == "" {
+		return "", "", fmt.Errorf("invalid vdev info, template name is empty")
+	}
+	// The device type is derived from the resources dcmi reports, so that
+	// vNPUs of any template the driver supports are recognized.
+	vDeviType := fmt.Sprintf("%s-%dc", chipType, coreNum)
+	if aicpu := vDevInfo.QueryInfo.Computing.DeviceAicpu; aicpu > 0 {
+		vDeviType = fmt.Sprintf("%s.%dcpu", vDeviType, aicpu)
 	}
-	vDeviType = fmt.Sprintf("%s-%s", chipType, vDeviType)
 	devID := fmt.Sprintf("%s-%d-%d", vDeviType, vDevInfo.VDevID, phyID)
 	return vDeviType, devID, nil
 }
@@ -197,23 +171,22 @@ func (am *AscendManager) NewHwDevManager() (common.NpuAllInfo, error) {
 		return common.NpuAllInfo{}, err
 	}
 	var allDevices []common.NpuDevice
-	var chipType = ""
 	for i := int32(0); i < devNum; i++ {
 		davinCiDev, err := am.getDavinCiDev(devList[i])
 		if err != nil {
 			return common.NpuAllInfo{}, err
 		}
-		if chipType == "" {
-			chipInfo, err := am.mgr.GetChipInfo(davinCiDev.LogicID)
-			if err != nil {
-				return common.NpuAllInfo{}, nil
-			}
-			chipType = chipInfo.Name
-		}
-		vDevInfos, err := am.getVirtualDevice(devList[i])
+		// A node may mix chip models, so the model is queried for every chip.
+		// A chip whose model cannot be read is left out, not the whole node.
+		chipInfo, err := am.mgr.GetChipInfo(davinCiDev.LogicID)
 		if err != nil {
-
+			log.Printf("Skipping logic ID %d, failed to get chip info: %v", davinCiDev.LogicID, err)
+			continue
 		}
+		chipType := chipInfo.Name
+		// Chips that do not support vNPUs fail the query and are listed as
+		// physical devices.
+		vDevInfos, _ := am.getVirtualDevice(devList[i])
 		if vDevInfos.TotalResource.VDevNum > common.MaxVirtualDeviceNum {
 			return common.NpuAllInfo{}, fmt.Errorf("invalid virtual device count")
 		}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	npuCommon "huawei.com/npu-exporter/v5/devmanager/common"
+	"k8s.io/klog/v2"
+)
+
+const (
+	metricsNamespace         = "ascend_dra"
+	metricsReadHeaderTimeout = 10 * time.Second
+	metricsShutdownTimeout   = 5 * time.Second
+	bytesPerMiB              = 1024 * 1024
+
+	// Methods of the kubelet calls counted by the request metrics.
+	MethodNodePrepareResources   = "NodePrepareResources"
+	MethodNodeUnprepareResources = "NodeUnprepareResources"
+)
+
+// Reasons a claim failed to prepare, as reported by the prepare failure metric.
+const (
+	PrepareFailureNotAllocated   = "not_allocated"
+	PrepareFailureInvalidConfig  = "invalid_config"
+	PrepareFailureNotAllocatable = "device_not_allocatable"
+	PrepareFailureVnpu           = "vnpu_create_failed"
+	PrepareFailureCDI            = "cdi"
+	PrepareFailureCheckpoint     = "checkpoint"
+	PrepareFailureRankTable      = "rank_table"
+	PrepareFailureUnknown        = "unknown"
+)
+
+// prepareError tags an error of the prepare path with the reason reported in
+// the prepare failure metric.
+type prepareError struct {
+	reason string
+	err    error
+}
+
+func newPrepareError(reason string, err error) error {
+	return &prepareError{reason: reason, err: err}
+}
+
+func (e *prepareError) Error() string {
+	return e.err.Error()
+}
+
+func (e *prepareError) Unwrap() error {
+	return e.err
+}
+
+// prepareFailureReason returns the reason a prepare error was tagged with.
+func prepareFailureReason(err error) string {
+	var perr *prepareError
+	if errors.As(err, &perr) {
+		return perr.reason
+	}
+	return PrepareFailureUnknown
+}
+
+// Metrics holds the Prometheus metrics of the kubelet plugin.
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	prepareFailures *prometheus.CounterVec
+	publishErrors   prometheus.Counter
+}
+
+// NewMetrics creates the metrics of the plugin. The devices and slices of the
+// state and the telemetry of the chips are collected on every scrape.
+func NewMetrics(state *DeviceState, backend NpuBackend) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "node_requests_total",
+			Help:      "Number of NodePrepareResources and NodeUnprepareResources calls from the kubelet.",
+		}, []string{"method"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "node_request_duration_seconds",
+			Help:      "Latency of NodePrepareResources and NodeUnprepareResources calls from the kubelet.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"method"}),
+		prepareFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "prepare_failures_total",
+			Help:      "Number of claims that failed to prepare, by reason.",
+		}, []string{"reason"}),
+		publishErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "resourceslice_publish_errors_total",
+			Help:      "Number of errors publishing the ResourceSlices of the node.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.prepareFailures,
+		m.publishErrors,
+		&npuCollector{state: state, backend: backend},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// ObserveRequest records a call from the kubelet that started at the given time.
+func (m *Metrics) ObserveRequest(method string, start time.Time) {
+	m.requests.WithLabelValues(method).Inc()
+	m.requestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// PrepareFailed records a claim that failed to prepare.
+func (m *Metrics) PrepareFailed(err error) {
+	m.prepareFailures.WithLabelValues(prepareFailureReason(err)).Inc()
+}
+
+// PublishFailed records an error publishing the ResourceSlices.
+func (m *Metrics) PublishFailed() {
+	m.publishErrors.Inc()
+}
+
+// Serve serves the metrics on /metrics at the given address until the context
+// is done.
+func (m *Metrics) Serve(ctx context.Context, address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: metricsReadHeaderTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			klog.Errorf("Failed to shut down metrics server: %v", err)
+		}
+	}()
+
+	klog.Infof("Serving metrics on %s/metrics", address)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("metrics server failed: %v", err)
+	}
+	return nil
+}
+
+var (
+	devicesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "npu", "devices"),
+		"Number of devices of a physical NPU published as allocatable or prepared for claims.",
+		[]string{"npu", "state"}, nil)
+	slicesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "npu", "slices"),
+		"Number of slices a physical NPU is partitioned into, by type and state.",
+		[]string{"npu", "type", "state"}, nil)
+	templateAllocationsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "template", "allocations"),
+		"Number of allocated vNPU slices per template.",
+		[]string{"template"}, nil)
+	temperatureDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "npu", "temperature_celsius"),
+		"Temperature of a physical NPU.",
+		[]string{"npu"}, nil)
+	powerDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "npu", "power_watts"),
+		"Power draw of a physical NPU.",
+		[]string{"npu"}, nil)
+	aicoreUtilizationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "npu", "aicore_utilization_percent"),
+		"AICore utilization of a physical NPU.",
+		[]string{"npu"}, nil)
+	hbmTotalDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "npu", "hbm_total_bytes"),
+		"HBM size of a physical NPU.",
+		[]string{"npu"}, nil)
+	hbmUsedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "npu", "hbm_used_bytes"),
+		"HBM usage of a physical NPU.",
+		[]string{"npu"}, nil)
+)
+
+// npuCollector collects the per-NPU device, slice and template gauges from the
+// device state and reads the chip telemetry through the backend.
+type npuCollector struct {
+	state   *DeviceState
+	backend NpuBackend
+}
+
+var _ prometheus.Collector = &npuCollector{}
+
+func (c *npuCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- devicesDesc
+	ch <- slicesDesc
+	ch <- templateAllocationsDesc
+	ch <- temperatureDesc
+	ch <- powerDesc
+	ch <- aicoreUtilizationDesc
+	ch <- hbmTotalDesc
+	ch <- hbmUsedDesc
+}
+
+func (c *npuCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collectDevices(ch)
+	c.collectTelemetry(ch)
+}
+
+func (c *npuCollector) collectDevices(ch chan<- prometheus.Metric) {
+	c.state.Lock()
+	defer c.state.Unlock()
+
+	allocatable := make(map[string]int)
+	for _, device := range c.state.allocatable {
+		index, ok := device.Attributes[DriverDomain+"index"]
+		if !ok || index.IntValue == nil {
+			continue
+		}
+		allocatable[fmt.Sprintf("npu-%d-0", *index.IntValue)]++
+	}
+	prepared, err := c.state.preparedDevicesPerNpu()
+	if err != nil {
+		klog.Errorf("Failed to count prepared devices for metrics: %v", err)
+	}
+	for npu := range prepared {
+		if _, ok := allocatable[npu]; !ok {
+			allocatable[npu] = 0
+		}
+	}
+	for npu, count := range allocatable {
+		ch <- prometheus.MustNewConstMetric(devicesDesc, prometheus.GaugeValue, float64(count), npu, "allocatable")
+		ch <- prometheus.MustNewConstMetric(devicesDesc, prometheus.GaugeValue, float64(prepared[npu]), npu, "prepared")
+	}
+
+	// In partitionable mode the scheduler tracks the vNPUs, the slices of the
+	// VnpuManager are not used.
+	if c.state.vnpuManager == nil || c.state.partitionable {
+		return
+	}
+	c.state.vnpuManager.Lock()
+	defer c.state.vnpuManager.Unlock()
+
+	templates := make(map[string]int)
+	for _, name := range c.state.vnpuManager.Catalog.Names() {
+		templates[name] = 0
+	}
+	for npu, physicalNpu := range c.state.vnpuManager.PhysicalNpus {
+		type sliceKey struct{ sliceType, state string }
+		counts := make(map[sliceKey]int)
+		for _, slice := range physicalNpu.AvailableSlices {
+			counts[sliceKey{slice.Type, "available"}]++
+		}
+		for _, slice := range physicalNpu.AllocatedSlices {
+			counts[sliceKey{slice.Type, "allocated"}]++
+			if slice.TemplateName != "" {
+				templates[slice.TemplateName]++
+			}
+		}
+		for key, count := range counts {
+			ch <- prometheus.MustNewConstMetric(slicesDesc, prometheus.GaugeValue, float64(count), npu, key.sliceType, key.state)
+		}
+	}
+	for name, count := range templates {
+		ch <- prometheus.MustNewConstMetric(templateAllocationsDesc, prometheus.GaugeValue, float64(count), name)
+	}
+}
+
+// collectTelemetry reads the telemetry of every chip. Readings a chip does not
+// support, e.g. HBM on chips with DDR memory, are left out.
+func (c *npuCollector) collectTelemetry(ch chan<- prometheus.Metric) {
+	_, logicIDs, err := c.backend.GetDeviceList()
+	if err != nil {
+		klog.Errorf("Failed to list NPU devices for metrics: %v", err)
+		return
+	}
+	for _, logicID := range logicIDs {
+		npu := fmt.Sprintf("npu-%d-0", logicID)
+		if temperature, err := c.backend.GetDeviceTemperature(logicID); err == nil {
+			ch <- prometheus.MustNewConstMetric(temperatureDesc, prometheus.GaugeValue, float64(temperature), npu)
+		} else {
+			klog.V(4).Infof("Failed to get temperature of NPU device %s: %v", npu, err)
+		}
+		if power, err := c.backend.GetDevicePowerInfo(logicID); err == nil {
+			ch <- prometheus.MustNewConstMetric(powerDesc, prometheus.GaugeValue, float64(power), npu)
+		} else {
+			klog.V(4).Infof("Failed to get power of NPU device %s: %v", npu, err)
+		}
+		if utilization, err := c.backend.GetDeviceUtilizationRate(logicID, npuCommon.AICore); err == nil {
+			ch <- prometheus.MustNewConstMetric(aicoreUtilizationDesc, prometheus.GaugeValue, float64(utilization), npu)
+		} else {
+			klog.V(4).Infof("Failed to get AICore utilization of NPU device %s: %v", npu, err)
+		}
+		if hbm, err := c.backend.GetDeviceHbmInfo(logicID); err == nil && hbm != nil {
+			ch <- prometheus.MustNewConstMetric(hbmTotalDesc, prometheus.GaugeValue, float64(hbm.MemorySize*bytesPerMiB), npu)
+			ch <- prometheus.MustNewConstMetric(hbmUsedDesc, prometheus.GaugeValue, float64(hbm.Usage*bytesPerMiB), npu)
+		} else {
+			klog.V(4).Infof("Failed to get HBM info of NPU device %s: %v", npu, err)
+		}
+	}
+}
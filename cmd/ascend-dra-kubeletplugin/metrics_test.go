@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const metricsTestInventory = `
+templates:
+- name: vir02
+  aicore: 2
+  memoryGiB: 6
+devices:
+- logicID: 0
+  phyID: 0
+  cardID: 0
+  chipName: 310P3
+  aicore: 8
+  memoryGiB: 21
+  telemetry:
+    temperatureCelsius: 52
+    powerWatts: 38.5
+    aicoreUtilization: 75
+    hbmUsedMiB: 2048
+`
+
+func TestPrepareFailureReason(t *testing.T) {
+	tagged := newPrepareError(PrepareFailureCDI, fmt.Errorf("disk full"))
+	assert.Equal(t, PrepareFailureCDI, prepareFailureReason(tagged))
+	assert.Equal(t, PrepareFailureCDI, prepareFailureReason(fmt.Errorf("prepare failed: %w", tagged)))
+	assert.Equal(t, PrepareFailureUnknown, prepareFailureReason(fmt.Errorf("prepare failed: %v", tagged)))
+	assert.Equal(t, "disk full", tagged.Error())
+}
+
+func TestMetrics(t *testing.T) {
+	state, backend := newTestDeviceState(t, metricsTestInventory)
+	metrics := NewMetrics(state, backend)
+	d := &driver{state: state, metrics: metrics}
+
+	result := d.prepareResourceClaim(context.Background(), newTestClaim(t, "uid-1", "vir02", "npu-0-0"))
+	require.NoError(t, result.Err)
+	unallocated := newTestClaim(t, "uid-2", "")
+	unallocated.Status.Allocation = nil
+	result = d.prepareResourceClaim(context.Background(), unallocated)
+	require.Error(t, result.Err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.prepareFailures.WithLabelValues(PrepareFailureNotAllocated)))
+
+	collector := &npuCollector{state: state, backend: backend}
+	expected := `
+# HELP ascend_dra_npu_aicore_utilization_percent AICore utilization of a physical NPU.
+# TYPE ascend_dra_npu_aicore_utilization_percent gauge
+ascend_dra_npu_aicore_utilization_percent{npu="npu-0-0"} 75
+# HELP ascend_dra_npu_hbm_used_bytes HBM usage of a physical NPU.
+# TYPE ascend_dra_npu_hbm_used_bytes gauge
+ascend_dra_npu_hbm_used_bytes{npu="npu-0-0"} 2.147483648e+09
+# HELP ascend_dra_npu_power_watts Power draw of a physical NPU.
+# TYPE ascend_dra_npu_power_watts gauge
+ascend_dra_npu_power_watts{npu="npu-0-0"} 38.5
+# HELP ascend_dra_npu_temperature_celsius Temperature of a physical NPU.
+# TYPE ascend_dra_npu_temperature_celsius gauge
+ascend_dra_npu_temperature_celsius{npu="npu-0-0"} 52
+# HELP ascend_dra_template_allocations Number of allocated vNPU slices per template.
+# TYPE ascend_dra_template_allocations gauge
+ascend_dra_template_allocations{template="vir02"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"ascend_dra_npu_aicore_utilization_percent",
+		"ascend_dra_npu_hbm_used_bytes",
+		"ascend_dra_npu_power_watts",
+		"ascend_dra_npu_temperature_celsius",
+		"ascend_dra_template_allocations",
+	))
+
+	// The prepared vNPU is counted on its chip. The claimed slice keeps the
+	// name of the chip, the remainder is available as a vNPU slice.
+	families, err := metrics.registry.Gather()
+	require.NoError(t, err)
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var labels []string
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, label.GetValue())
+			}
+			values[family.GetName()+"{"+strings.Join(labels, ",")+"}"] = metric.GetGauge().GetValue()
+		}
+	}
+	assert.Equal(t, 1.0, values["ascend_dra_npu_devices{npu-0-0,prepared}"])
+	assert.Equal(t, 2.0, values["ascend_dra_npu_devices{npu-0-0,allocatable}"])
+	assert.Equal(t, 1.0, values["ascend_dra_npu_slices{npu-0-0,allocated,vNPU}"])
+	assert.Equal(t, 1.0, values["ascend_dra_npu_slices{npu-0-0,available,vNPU}"])
+}
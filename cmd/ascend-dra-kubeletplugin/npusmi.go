@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultNpuSmiPath is where the driver installs npu-smi on the host.
+const DefaultNpuSmiPath = "/usr/local/sbin/npu-smi"
+
+// npuSmiTimeout bounds a single npu-smi invocation.
+const npuSmiTimeout = 30 * time.Second
+
+// CommandRunner runs an external command and returns its standard output.
+// Tests replace it with a fake.
+type CommandRunner interface {
+	Output(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// execRunner runs commands through os/exec.
+type execRunner struct{}
+
+func (execRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return out, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return out, err
+}
+
+// NpuSmi queries the driver through the npu-smi tool.
+type NpuSmi struct {
+	path   string
+	runner CommandRunner
+}
+
+// NewNpuSmi returns an NpuSmi running the npu-smi binary at path.
+func NewNpuSmi(path string) *NpuSmi {
+	return &NpuSmi{path: path, runner: execRunner{}}
+}
+
+// TemplateInfo returns the output of `npu-smi info -t template-info`, the
+// vNPU templates supported by the installed driver.
+func (n *NpuSmi) TemplateInfo(ctx context.Context) (string, error) {
+	return n.run(ctx, "info", "-t", "template-info")
+}
+
+// CardTemplateInfo returns the output of `npu-smi info -t template-info -i`,
+// the vNPU templates supported by the chips of a card.
+func (n *NpuSmi) CardTemplateInfo(ctx context.Context, cardID int32) (string, error) {
+	return n.run(ctx, "info", "-t", "template-info", "-i", strconv.Itoa(int(cardID)))
+}
+
+func (n *NpuSmi) run(ctx context.Context, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, npuSmiTimeout)
+	defer cancel()
+	out, err := n.runner.Output(ctx, n.path, args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s %s: %v", n.path, strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
+
+// QueryDriverTemplates asks the driver for the vNPU templates it supports and
+// returns them for each chip model found by the backend, so that they take
+// precedence over the default templates of the template files. On nodes
+// mixing chip models, the templates of each model are queried from the first
+// card holding a chip of that model.
+func QueryDriverTemplates(ctx context.Context, backend NpuBackend, smi *NpuSmi) (TemplateCatalog, error) {
+	allInfo, err := NewAscendManager(backend).NewHwDevManager()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list NPUs: %v", err)
+	}
+	var models []string
+	cards := make(map[string]int32)
+	for _, dev := range allInfo.AllDevs {
+		if dev.ChipName != "" && !slices.Contains(models, dev.ChipName) {
+			models = append(models, dev.ChipName)
+			cards[dev.ChipName] = dev.CardID
+		}
+	}
+	if len(models) == 0 {
+		return nil, nil
+	}
+
+	catalog := make(TemplateCatalog)
+	if len(models) == 1 {
+		output, err := smi.TemplateInfo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := catalog.addTemplateInfo(models[0], output); err != nil {
+			return nil, fmt.Errorf("invalid npu-smi template info: %v", err)
+		}
+		return catalog, nil
+	}
+	for _, model := range models {
+		output, err := smi.CardTemplateInfo(ctx, cards[model])
+		if err != nil {
+			return nil, err
+		}
+		if err := catalog.addTemplateInfo(model, output); err != nil {
+			return nil, fmt.Errorf("invalid npu-smi template info of %s: %v", model, err)
+		}
+	}
+	return catalog, nil
+}
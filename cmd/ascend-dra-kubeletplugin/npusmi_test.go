@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRunner serves canned command outputs keyed by the command line.
+type fakeRunner struct {
+	outputs map[string]string
+	calls   []string
+}
+
+func (r *fakeRunner) Output(_ context.Context, name string, args ...string) ([]byte, error) {
+	command := strings.Join(append([]string{name}, args...), " ")
+	r.calls = append(r.calls, command)
+	out, ok := r.outputs[command]
+	if !ok {
+		return nil, fmt.Errorf("exit status 1")
+	}
+	return []byte(out), nil
+}
+
+func TestQueryDriverTemplates(t *testing.T) {
+	testCases := map[string]struct {
+		outputs     map[string]string
+		expected    TemplateCatalog
+		expectedErr string
+	}{
+		"templates": {
+			outputs: map[string]string{"npu-smi info -t template-info": testTemplateInfo},
+			expected: TemplateCatalog{"310P3": {
+				"vir01": {Name: "vir01", Attributes: VnpuTemplateAttribute{AICORE: 1, Memory: 3, AICPU: 1, Media: MediaEngines{VPC: 1}}},
+				"vir02": {Name: "vir02", Attributes: VnpuTemplateAttribute{AICORE: 2, Memory: 6, AICPU: 2, Media: MediaEngines{VPC: 3, VENC: 1}}},
+			}},
+		},
+		"npu-smi fails": {
+			outputs:     map[string]string{},
+			expectedErr: "failed to run npu-smi info -t template-info: exit status 1",
+		},
+		"unexpected output": {
+			outputs:     map[string]string{"npu-smi info -t template-info": "Error: not supported"},
+			expectedErr: "invalid npu-smi template info: failed to find template info header",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			runner := &fakeRunner{outputs: tc.outputs}
+			smi := &NpuSmi{path: "npu-smi", runner: runner}
+			catalog, err := QueryDriverTemplates(context.Background(), newTestFakeBackend(t, testInventory), smi)
+			// The templates are shared by all chips of the node.
+			assert.Equal(t, []string{"npu-smi info -t template-info"}, runner.calls)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, catalog)
+		})
+	}
+}
+
+func TestTemplateRegistryDriverTemplates(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplateFile(t, dir, "templates.yaml", testTemplateFile)
+	writeTemplateFile(t, dir, "defaults.yaml", `
+models:
+  default:
+  - {name: vir02, aicore: 2, memoryGiB: 7}
+  - {name: vir04, aicore: 4, memoryGiB: 12}
+`)
+	driver := TemplateCatalog{"310P3": {
+		"vir01": {Name: "vir01", Attributes: VnpuTemplateAttribute{AICORE: 1, Memory: 3, AICPU: 1}},
+		"vir02": {Name: "vir02", Attributes: VnpuTemplateAttribute{AICORE: 2, Memory: 6, AICPU: 2}},
+	}}
+	registry, err := NewTemplateRegistry([]string{dir}, driver)
+	require.NoError(t, err)
+
+	// The template files add to the driver templates, only the templates of
+	// the model replace them.
+	templates := registry.Catalog().ForModel("310P3")
+	assert.Len(t, templates, 3)
+	assert.Equal(t, 1, templates["vir01"].Attributes.AICORE)
+	assert.Equal(t, 8, templates["vir02"].Attributes.Memory)
+	assert.Equal(t, 12, templates["vir04"].Attributes.Memory)
+	assert.Len(t, registry.Catalog().ForModel("910B3"), 1)
+	// The driver templates are left as they are.
+	assert.Equal(t, 6, driver["310P3"]["vir02"].Attributes.Memory)
+}
+
+func TestTemplateRegistryDefaultsKeepDriverTemplates(t *testing.T) {
+	tests := map[string]struct {
+		file    string
+		content string
+	}{
+		"default model": {
+			file: "defaults.yaml",
+			content: `
+models:
+  default:
+  - {name: vir01, aicore: 1, memoryGiB: 4}
+`,
+		},
+		"template info of all models": {
+			file:    "template-info.txt",
+			content: strings.ReplaceAll(testTemplateInfo, "vir01               1         3", "vir01               1         4"),
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTemplateFile(t, dir, test.file, test.content)
+			driver := TemplateCatalog{"310P3": {
+				"vir01": {Name: "vir01", Attributes: VnpuTemplateAttribute{AICORE: 1, Memory: 3, AICPU: 1}},
+			}}
+			registry, err := NewTemplateRegistry([]string{dir}, driver)
+			require.NoError(t, err)
+
+			// The default templates collide with the driver template, which
+			// is kept, but still apply to models the driver does not report.
+			assert.Equal(t, 3, registry.Catalog().ForModel("310P3")["vir01"].Attributes.Memory)
+			assert.Equal(t, 4, registry.Catalog().ForModel("910B3")["vir01"].Attributes.Memory)
+		})
+	}
+}
+
+func TestQueryDriverTemplatesMixedChipModels(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{
+		"npu-smi info -t template-info -i 0": testTemplateInfo,
+		"npu-smi info -t template-info -i 1": testTemplateInfo,
+	}}
+	smi := &NpuSmi{path: "npu-smi", runner: runner}
+	catalog, err := QueryDriverTemplates(context.Background(), newTestFakeBackend(t, mixedTestInventory), smi)
+	require.NoError(t, err)
+
+	// The templates of each model are queried from a card holding it.
+	assert.Equal(t, []string{"npu-smi info -t template-info -i 0", "npu-smi info -t template-info -i 1"}, runner.calls)
+	assert.ElementsMatch(t, []string{"310P3", "310P1"}, slices.Collect(maps.Keys(catalog)))
+}
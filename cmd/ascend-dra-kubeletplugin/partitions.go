@@ -0,0 +1,410 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	npuCommon "huawei.com/npu-exporter/v5/devmanager/common"
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/dynamic-resource-allocation/resourceslice"
+	"k8s.io/utils/ptr"
+
+	"Ascend-dra-driver/pkg/common"
+)
+
+// Counters of the counter set published for each chip in partitionable mode.
+// A device consumes the resources of the vNPU template it stands for, the
+// full chip consumes all of them.
+const (
+	CounterAICore = "aicore"
+	CounterMemory = "memory"
+	CounterAICPU  = "aicpu"
+	CounterVPC    = "vpc"
+	CounterVENC   = "venc"
+	CounterVDEC   = "vdec"
+	CounterJPEGD  = "jpegd"
+	CounterJPEGE  = "jpege"
+	CounterPNGD   = "pngd"
+)
+
+// capacityCounters are the counters publishing a ChipCapacity, in the order
+// of ChipCapacity.resources.
+var capacityCounters = []string{
+	CounterAICore, CounterMemory, CounterAICPU,
+	CounterVPC, CounterVENC, CounterVDEC, CounterJPEGD, CounterJPEGE, CounterPNGD,
+}
+
+// PartitionDevice is a device published in partitionable mode: a full chip, a
+// possible instance of a vNPU template on it, or a vNPU adopted at startup.
+type PartitionDevice struct {
+	PhysicalDevice string
+	LogicID        int32
+	TemplateName   string
+	// VDevID is only set for adopted vNPUs, the others are created on prepare.
+	VDevID  uint32
+	Adopted bool
+}
+
+// ChipCapacity holds the resources of a chip that vNPUs are carved from.
+type ChipCapacity struct {
+	AICore    int
+	MemoryGiB int
+	AICPU     int
+	Media     MediaEngines
+}
+
+// getChipCapacity reads the total resources of a chip from dcmi.
+func getChipCapacity(backend NpuBackend, logicID int32) (ChipCapacity, error) {
+	info, err := backend.GetVirtualDeviceInfo(logicID)
+	if err != nil {
+		return ChipCapacity{}, fmt.Errorf("query virtual device info failure: %v", err)
+	}
+	capacity := computingCapacity(info.TotalResource.Computing)
+	capacity.Media = mediaEngines(info.TotalResource.Media)
+	return capacity, nil
+}
+
+// mediaEngines converts the media resources reported by dcmi.
+func mediaEngines(media npuCommon.CgoMediaResource) MediaEngines {
+	return MediaEngines{
+		VPC:   int(media.Vpc),
+		VENC:  int(media.Venc),
+		VDEC:  int(media.Vdec),
+		JPEGD: int(media.Jpegd),
+		JPEGE: int(media.Jpege),
+		PNGD:  int(media.Pngd),
+	}
+}
+
+// computingCapacity converts the computing resources reported by dcmi, with
+// the memory in MiB, into a ChipCapacity.
+func computingCapacity(computing npuCommon.CgoComputingResource) ChipCapacity {
+	return ChipCapacity{
+		AICore:    int(computing.Aic),
+		MemoryGiB: int(computing.MemorySize / 1024),
+		AICPU:     int(computing.DeviceAicpu),
+	}
+}
+
+// templateCapacity returns the resources a vNPU template takes from a chip.
+func templateCapacity(tpl *VnpuTemplate) ChipCapacity {
+	return ChipCapacity{
+		AICore:    tpl.Attributes.AICORE,
+		MemoryGiB: tpl.Attributes.Memory,
+		AICPU:     tpl.Attributes.AICPU,
+		Media:     tpl.Attributes.Media,
+	}
+}
+
+// counters returns the capacity as DRA counters. AICore and memory are always
+// counted, the AICPUs and media engines only if the chip reports them in its
+// total capacity.
+func (c ChipCapacity) counters(total ChipCapacity) map[string]resourceapi.Counter {
+	values, reported := c.resources(), total.resources()
+	counters := make(map[string]resourceapi.Counter, len(capacityCounters))
+	for i, name := range capacityCounters {
+		if i >= 2 && reported[i] == 0 {
+			continue
+		}
+		value := resource.NewQuantity(int64(values[i]), resource.DecimalSI)
+		if name == CounterMemory {
+			value = resource.NewQuantity(int64(values[i])<<30, resource.BinarySI)
+		}
+		counters[name] = resourceapi.Counter{Value: *value}
+	}
+	return counters
+}
+
+// instances returns how many vNPUs of the given size fit on the chip. As for
+// the counters, resources the chip does not report are not checked.
+func (c ChipCapacity) instances(tpl ChipCapacity, total ChipCapacity) int {
+	if tpl.AICore <= 0 {
+		return 0
+	}
+	left, needed, reported := c.resources(), tpl.resources(), total.resources()
+	n := c.AICore / tpl.AICore
+	for i := 1; i < len(needed); i++ {
+		if needed[i] > 0 && reported[i] > 0 {
+			n = min(n, left[i]/needed[i])
+		}
+	}
+	return n
+}
+
+// sub removes the resources of a vNPU from the capacity.
+func (c ChipCapacity) sub(tpl ChipCapacity) ChipCapacity {
+	return ChipCapacity{
+		AICore:    max(c.AICore-tpl.AICore, 0),
+		MemoryGiB: max(c.MemoryGiB-tpl.MemoryGiB, 0),
+		AICPU:     max(c.AICPU-tpl.AICPU, 0),
+		Media: MediaEngines{
+			VPC:   max(c.Media.VPC-tpl.Media.VPC, 0),
+			VENC:  max(c.Media.VENC-tpl.Media.VENC, 0),
+			VDEC:  max(c.Media.VDEC-tpl.Media.VDEC, 0),
+			JPEGD: max(c.Media.JPEGD-tpl.Media.JPEGD, 0),
+			JPEGE: max(c.Media.JPEGE-tpl.Media.JPEGE, 0),
+			PNGD:  max(c.Media.PNGD-tpl.Media.PNGD, 0),
+		},
+	}
+}
+
+// partitionedNpu is a chip published in partitionable mode. Its counter set
+// and all devices consuming from it go into a ResourceSlice of their own, as
+// counters can only be consumed by devices of the same slice.
+type partitionedNpu struct {
+	capacity ChipCapacity
+	// total is the capacity the chip reports, before adopted vNPUs are taken
+	// out. Only the resources it reports are counted.
+	total ChipCapacity
+	// chip is the device of the full chip the vNPU devices are derived from,
+	// templates are the templates of its model.
+	chip      resourceapi.Device
+	model     string
+	templates map[string]*VnpuTemplate
+}
+
+func counterSetName(logicID int32) string {
+	return fmt.Sprintf("npu-%d-counters", logicID)
+}
+
+// partitionDeviceName names a possible vNPU instance. Template names may
+// contain underscores, which are not allowed in device names.
+func partitionDeviceName(logicID int32, templateName string, instance int) string {
+	return fmt.Sprintf("npu-%d-%s-%d", logicID, strings.ReplaceAll(strings.ToLower(templateName), "_", "-"), instance)
+}
+
+// initPartitions publishes every chip as a counter set, and next to the full
+// chip every vNPU the templates of its model allow to be carved from it as a
+// device consuming from that set. The scheduler then picks the vNPUs and keeps
+// track of what is left on each chip.
+func (s *DeviceState) initPartitions(catalog TemplateCatalog) error {
+	s.partitions = make(map[string]*PartitionDevice)
+	s.partitionedNpus = make(map[int32]*partitionedNpu)
+
+	for _, fullCard := range slices.Sorted(maps.Keys(s.allocatable)) {
+		device := s.allocatable[fullCard]
+		logicID, model, err := s.deviceChip(fullCard)
+		if err != nil {
+			return err
+		}
+		capacity, err := getChipCapacity(s.backend, logicID)
+		if err != nil {
+			return fmt.Errorf("failed to get capacity of logic ID %d: %v", logicID, err)
+		}
+		npu := &partitionedNpu{capacity: capacity, total: capacity, model: model, templates: catalog.ForModel(model)}
+		s.partitionedNpus[logicID] = npu
+
+		device.ConsumesCounters = []resourceapi.DeviceCounterConsumption{{
+			CounterSet: counterSetName(logicID),
+			Counters:   capacity.counters(npu.total),
+		}}
+		npu.chip = device
+		s.allocatable[fullCard] = device
+		s.partitions[fullCard] = &PartitionDevice{PhysicalDevice: fullCard, LogicID: logicID}
+
+		devices := s.addTemplatePartitions(logicID)
+		log.Printf("Published %d partitionable devices for logic ID %d (AICORE: %d, Memory: %dGB, AICPU: %d)",
+			devices, logicID, capacity.AICore, capacity.MemoryGiB, capacity.AICPU)
+	}
+	return nil
+}
+
+// addTemplatePartitions publishes the possible instances of every template of
+// a chip, as far as they fit into its ResourceSlice. It returns the number of
+// devices of the chip.
+func (s *DeviceState) addTemplatePartitions(logicID int32) int {
+	npu := s.partitionedNpus[logicID]
+	fullCard := fmt.Sprintf("npu-%d-0", logicID)
+	counters := len(npu.capacity.counters(npu.total))
+
+	devices, consumed := 0, 0
+	for name, partition := range s.partitions {
+		if partition.LogicID != logicID {
+			continue
+		}
+		devices++
+		for _, consumption := range s.allocatable[name].ConsumesCounters {
+			consumed += len(consumption.Counters)
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(npu.templates)) {
+		tpl := npu.templates[name]
+		for i := 1; i <= npu.capacity.instances(templateCapacity(tpl), npu.total); i++ {
+			if devices >= resourceapi.ResourceSliceMaxDevices || consumed+counters > resourceapi.ResourceSliceMaxDeviceCountersPerSlice {
+				log.Printf("Warning: not publishing further vNPUs of logic ID %d, its ResourceSlice is full", logicID)
+				break
+			}
+			partition := s.newPartitionDevice(npu, tpl, partitionDeviceName(logicID, name, i))
+			partition.ConsumesCounters = []resourceapi.DeviceCounterConsumption{{
+				CounterSet: counterSetName(logicID),
+				Counters:   templateCapacity(tpl).counters(npu.total),
+			}}
+			s.allocatable[partition.Name] = partition
+			s.partitions[partition.Name] = &PartitionDevice{PhysicalDevice: fullCard, LogicID: logicID, TemplateName: name}
+			devices++
+			consumed += counters
+		}
+	}
+	return devices
+}
+
+// updatePartitionTemplates replaces the possible vNPU instances of every chip
+// by those of the templates in the catalog. Adopted vNPUs stay as they are.
+func (s *DeviceState) updatePartitionTemplates(catalog TemplateCatalog) {
+	for _, logicID := range slices.Sorted(maps.Keys(s.partitionedNpus)) {
+		npu := s.partitionedNpus[logicID]
+		npu.templates = catalog.ForModel(npu.model)
+		setTemplateAttributes(npu.chip.Attributes, npu.templates)
+
+		for name, partition := range s.partitions {
+			if partition.LogicID != logicID {
+				continue
+			}
+			if partition.TemplateName != "" && !partition.Adopted {
+				delete(s.partitions, name)
+				delete(s.allocatable, name)
+				continue
+			}
+			setTemplateAttributes(s.allocatable[name].Attributes, npu.templates)
+		}
+		devices := s.addTemplatePartitions(logicID)
+		log.Printf("Republished %d partitionable devices for logic ID %d", devices, logicID)
+	}
+}
+
+// newPartitionDevice derives the device of a vNPU from the device of its chip,
+// with the resources of its template instead of those of the chip.
+func (s *DeviceState) newPartitionDevice(npu *partitionedNpu, tpl *VnpuTemplate, name string) resourceapi.Device {
+	device := resourceapi.Device{
+		Name:       name,
+		Attributes: make(map[resourceapi.QualifiedName]resourceapi.DeviceAttribute, len(npu.chip.Attributes)),
+	}
+	for key, value := range npu.chip.Attributes {
+		device.Attributes[key] = value
+	}
+	device.Attributes[DriverDomain+"type"] = resourceapi.DeviceAttribute{StringValue: ptr.To("vNPU")}
+	device.Attributes[DriverDomain+"template"] = resourceapi.DeviceAttribute{StringValue: ptr.To(tpl.Name)}
+	setCapacityAttributes(device.Attributes, templateCapacity(tpl), npu.total)
+	return device
+}
+
+// adoptPartitionVnpu publishes a vNPU found on a chip at startup as a device
+// of its own. Its resources are taken out of the counter set of the chip, so
+// the full chip is withdrawn as it can no longer be allocated.
+func (s *DeviceState) adoptPartitionVnpu(vdev common.NpuDevice) error {
+	npu, ok := s.partitionedNpus[vdev.LogicID]
+	if !ok {
+		return fmt.Errorf("physical NPU not found for logic ID %d", vdev.LogicID)
+	}
+	tpl, ok := npu.templates[vdev.TemplateName]
+	if !ok {
+		return fmt.Errorf("unknown template %s", vdev.TemplateName)
+	}
+
+	fullCard := fmt.Sprintf("npu-%d-0", vdev.LogicID)
+	device := s.newPartitionDevice(npu, tpl, fmt.Sprintf("npu-%d-vdev%d", vdev.LogicID, vdev.VDevID))
+	device.ConsumesCounters = nil
+	s.allocatable[device.Name] = device
+	s.partitions[device.Name] = &PartitionDevice{
+		PhysicalDevice: fullCard,
+		LogicID:        vdev.LogicID,
+		TemplateName:   vdev.TemplateName,
+		VDevID:         vdev.VDevID,
+		Adopted:        true,
+	}
+
+	npu.capacity = npu.capacity.sub(templateCapacity(tpl))
+	delete(s.allocatable, fullCard)
+	delete(s.partitions, fullCard)
+	log.Printf("Adopted vNPU %d (template: %s) on logic ID %d as device %s", vdev.VDevID, vdev.TemplateName, vdev.LogicID, device.Name)
+	return nil
+}
+
+// preparedVirtualDevices returns the vNPUs held by prepared claims, keyed by
+// logic and vdev ID, and whether they were adopted.
+func (s *DeviceState) preparedVirtualDevices() (map[PreparedVirtualDevice]bool, error) {
+	checkpoint := newCheckpoint()
+	if err := s.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
+		return nil, fmt.Errorf("unable to sync from checkpoint: %v", err)
+	}
+	prepared := make(map[PreparedVirtualDevice]bool)
+	for _, devices := range checkpoint.V1.PreparedClaims {
+		for _, device := range devices {
+			if vdev := device.VirtualDevice; vdev != nil {
+				prepared[PreparedVirtualDevice{LogicID: vdev.LogicID, VDevID: vdev.VDevID}] = vdev.Adopted
+			}
+		}
+	}
+	return prepared, nil
+}
+
+// preparePartition creates the vNPU behind an allocated partitionable device,
+// unless it is a full chip or an adopted vNPU. The scheduler already made sure
+// the vNPU fits on the chip.
+func (s *DeviceState) preparePartition(deviceName string) (*PreparedSlice, *PreparedVirtualDevice, error) {
+	partition, ok := s.partitions[deviceName]
+	if !ok {
+		return nil, nil, newPrepareError(PrepareFailureNotAllocatable, fmt.Errorf("requested NPU is not allocatable: %v", deviceName))
+	}
+	record := &PreparedSlice{
+		PhysicalDevice: partition.PhysicalDevice,
+		SliceID:        deviceName,
+		Type:           "NPU",
+		TemplateName:   partition.TemplateName,
+	}
+	if partition.TemplateName == "" {
+		return record, nil, nil
+	}
+	record.Type = "vNPU"
+	if partition.Adopted {
+		return record, &PreparedVirtualDevice{LogicID: partition.LogicID, VDevID: partition.VDevID, Adopted: true}, nil
+	}
+
+	out, err := s.backend.CreateVirtualDevice(partition.LogicID, npuCommon.CgoCreateVDevRes{
+		VDevID:       common.DefaultIDForCreateVNPU,
+		VfgID:        common.DefaultIDForCreateVNPU,
+		TemplateName: partition.TemplateName,
+	})
+	if err != nil {
+		return nil, nil, newPrepareError(PrepareFailureVnpu, fmt.Errorf("dcmi failed to create vNPU with template %s on logic ID %d: %v",
+			partition.TemplateName, partition.LogicID, err))
+	}
+	log.Printf("Created vNPU %d with template %s on logic ID %d for device %s",
+		out.VDevID, partition.TemplateName, partition.LogicID, deviceName)
+	return record, &PreparedVirtualDevice{LogicID: partition.LogicID, VDevID: out.VDevID}, nil
+}
+
+// resourceSlices groups the devices to publish into ResourceSlices. In
+// partitionable mode every chip gets a slice with its counter set, otherwise
+// all devices go into a single slice.
+func (s *DeviceState) resourceSlices(devices []resourceapi.Device) []resourceslice.Slice {
+	if !s.partitionable {
+		return []resourceslice.Slice{{Devices: devices}}
+	}
+
+	perChip := make(map[int32][]resourceapi.Device)
+	for _, device := range devices {
+		partition, ok := s.partitions[device.Name]
+		if !ok {
+			continue
+		}
+		perChip[partition.LogicID] = append(perChip[partition.LogicID], device)
+	}
+	var result []resourceslice.Slice
+	for _, logicID := range slices.Sorted(maps.Keys(s.partitionedNpus)) {
+		npu := s.partitionedNpus[logicID]
+		result = append(result, resourceslice.Slice{
+			SharedCounters: []resourceapi.CounterSet{{
+				Name:     counterSetName(logicID),
+				Counters: npu.capacity.counters(npu.total),
+			}},
+			Devices: perChip[logicID],
+		})
+	}
+	return result
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1"
+)
+
+const partitionTestInventory = `
+templates:
+- name: vir01
+  aicore: 1
+  memoryGiB: 3
+  aicpu: 1
+  vpc: 1
+  vdec: 1
+  jpegd: 2
+  jpege: 1
+- name: vir04_3c
+  aicore: 4
+  memoryGiB: 12
+  aicpu: 3
+  vpc: 6
+  venc: 1
+  vdec: 6
+  jpegd: 8
+  jpege: 4
+devices:
+- logicID: 0
+  phyID: 0
+  cardID: 0
+  chipName: 310P3
+  aicore: 8
+  memoryGiB: 21
+  aicpu: 7
+  vpc: 12
+  venc: 3
+  vdec: 12
+  jpegd: 16
+  jpege: 8
+- logicID: 1
+  phyID: 1
+  cardID: 0
+  deviceID: 1
+  chipName: 310P3
+  aicore: 8
+  memoryGiB: 21
+  aicpu: 7
+  vpc: 12
+  venc: 3
+  vdec: 12
+  jpegd: 16
+  jpege: 8
+  virtualDevices:
+  - vdevID: 100
+    templateName: vir01
+`
+
+func partitionable(state *DeviceState) {
+	state.partitionable = true
+}
+
+func counterValues(counters map[string]resourceapi.Counter) map[string]string {
+	values := make(map[string]string)
+	for name, counter := range counters {
+		values[name] = counter.Value.String()
+	}
+	return values
+}
+
+func TestPartitionableDevices(t *testing.T) {
+	backend := newTestFakeBackend(t, partitionTestInventory)
+	state := newTestDeviceStateFor(t, backend, partitionTestInventory, t.TempDir(), ExistingVnpuPolicyCleanup, partitionable)
+
+	// vir01 fits 7 times into the memory of a chip, vir04_3c once.
+	var names []string
+	for name := range state.allocatable {
+		names = append(names, name)
+	}
+	assert.ElementsMatch(t, []string{
+		"npu-0-0", "npu-0-vir01-1", "npu-0-vir01-2", "npu-0-vir01-3", "npu-0-vir01-4",
+		"npu-0-vir01-5", "npu-0-vir01-6", "npu-0-vir01-7", "npu-0-vir04-3c-1",
+		"npu-1-0", "npu-1-vir01-1", "npu-1-vir01-2", "npu-1-vir01-3", "npu-1-vir01-4",
+		"npu-1-vir01-5", "npu-1-vir01-6", "npu-1-vir01-7", "npu-1-vir04-3c-1",
+	}, names)
+
+	fullCard := state.allocatable["npu-0-0"]
+	require.Len(t, fullCard.ConsumesCounters, 1)
+	assert.Equal(t, "npu-0-counters", fullCard.ConsumesCounters[0].CounterSet)
+	// The chip reports no PNG decoders, so they are not counted.
+	assert.Equal(t, map[string]string{
+		"aicore": "8", "memory": "21Gi", "aicpu": "7", "vpc": "12", "venc": "3", "vdec": "12", "jpegd": "16", "jpege": "8",
+	}, counterValues(fullCard.ConsumesCounters[0].Counters))
+
+	vnpu := state.allocatable["npu-0-vir04-3c-1"]
+	assert.Equal(t, "vNPU", *vnpu.Attributes[DriverDomain+"type"].StringValue)
+	assert.Equal(t, "vir04_3c", *vnpu.Attributes[DriverDomain+"template"].StringValue)
+	assert.Equal(t, "310P3", *vnpu.Attributes[DriverDomain+"model"].StringValue)
+	// The device carries the resources of its template, not those of the chip.
+	resources := make(map[string]int64)
+	for _, name := range capacityAttributes {
+		if value, ok := vnpu.Attributes[name]; ok {
+			resources[string(name)] = *value.IntValue
+		}
+	}
+	assert.Equal(t, map[string]int64{
+		DriverDomain + "aicore": 4, DriverDomain + "memory": 12, DriverDomain + "aicpu": 3,
+		DriverDomain + "vpc": 6, DriverDomain + "venc": 1, DriverDomain + "vdec": 6, DriverDomain + "jpegd": 8, DriverDomain + "jpege": 4,
+	}, resources)
+	require.Len(t, vnpu.ConsumesCounters, 1)
+	assert.Equal(t, map[string]string{
+		"aicore": "4", "memory": "12Gi", "aicpu": "3", "vpc": "6", "venc": "1", "vdec": "6", "jpegd": "8", "jpege": "4",
+	}, counterValues(vnpu.ConsumesCounters[0].Counters))
+
+	// Every chip is published in a slice of its own together with its counters.
+	var devices []resourceapi.Device
+	for _, device := range state.allocatable {
+		devices = append(devices, device)
+	}
+	published := state.resourceSlices(devices)
+	require.Len(t, published, 2)
+	for i, slice := range published {
+		require.Len(t, slice.SharedCounters, 1)
+		assert.Equal(t, counterSetName(int32(i)), slice.SharedCounters[0].Name)
+		assert.Equal(t, map[string]string{
+			"aicore": "8", "memory": "21Gi", "aicpu": "7", "vpc": "12", "venc": "3", "vdec": "12", "jpegd": "16", "jpege": "8",
+		}, counterValues(slice.SharedCounters[0].Counters))
+		assert.Len(t, slice.Devices, 9)
+		for _, device := range slice.Devices {
+			assert.Equal(t, int64(i), *device.Attributes[DriverDomain+"index"].IntValue)
+		}
+	}
+}
+
+func TestChipCapacityInstances(t *testing.T) {
+	total := ChipCapacity{AICore: 8, MemoryGiB: 21, AICPU: 7, Media: MediaEngines{VPC: 12, VENC: 3, JPEGD: 16}}
+	tests := map[string]struct {
+		capacity ChipCapacity
+		template ChipCapacity
+		expected int
+	}{
+		"limited by memory": {
+			capacity: total,
+			template: ChipCapacity{AICore: 1, MemoryGiB: 3, AICPU: 1},
+			expected: 7,
+		},
+		"limited by media engines": {
+			capacity: total,
+			template: ChipCapacity{AICore: 1, MemoryGiB: 3, Media: MediaEngines{VENC: 2}},
+			expected: 1,
+		},
+		"media engines the chip does not report": {
+			capacity: total,
+			template: ChipCapacity{AICore: 2, MemoryGiB: 3, Media: MediaEngines{PNGD: 1}},
+			expected: 4,
+		},
+		"media engines taken by adopted vNPUs": {
+			capacity: total.sub(ChipCapacity{AICore: 1, MemoryGiB: 3, Media: MediaEngines{VENC: 3}}),
+			template: ChipCapacity{AICore: 1, MemoryGiB: 3, Media: MediaEngines{VENC: 1}},
+			expected: 0,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.capacity.instances(test.template, total))
+		})
+	}
+}
+
+func TestPreparePartitionableDevices(t *testing.T) {
+	backend := newTestFakeBackend(t, partitionTestInventory)
+	state := newTestDeviceStateFor(t, backend, partitionTestInventory, t.TempDir(), ExistingVnpuPolicyCleanup, partitionable)
+	published := len(state.allocatable)
+
+	// The plugin prepares exactly the devices the scheduler picked, a template
+	// in the class config does not change them.
+	devices, err := state.Prepare(newTestClaim(t, "uid-1", "vir01", "npu-0-vir04-3c-1", "npu-0-vir01-3", "npu-1-0"))
+	require.NoError(t, err)
+	require.Len(t, devices, 3)
+	assert.Len(t, state.allocatable, published)
+
+	info, err := backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	require.Len(t, info.VDevInfo, 2)
+	var templates []string
+	for _, vdev := range info.VDevInfo {
+		templates = append(templates, vdev.QueryInfo.Name)
+	}
+	assert.ElementsMatch(t, []string{"vir04_3c", "vir01"}, templates)
+
+	checkpoint := newCheckpoint()
+	require.NoError(t, state.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint))
+	for _, device := range checkpoint.V1.PreparedClaims["uid-1"] {
+		switch device.DeviceName {
+		case "npu-1-0":
+			assert.Nil(t, device.VirtualDevice)
+			assert.Equal(t, &PreparedSlice{PhysicalDevice: "npu-1-0", SliceID: "npu-1-0", Type: "NPU"}, device.Slice)
+		case "npu-0-vir04-3c-1":
+			require.NotNil(t, device.VirtualDevice)
+			assert.Equal(t, "vir04_3c", device.Slice.TemplateName)
+			assert.Contains(t, device.ContainerEdits.Env, "ASCEND_RUNTIME_OPTIONS=VIRTUAL")
+		}
+	}
+
+	require.NoError(t, state.Unprepare("uid-1"))
+	info, err = backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	assert.Empty(t, info.VDevInfo)
+	assert.Len(t, state.allocatable, published)
+
+	_, err = state.Prepare(newTestClaim(t, "uid-2", "", "npu-0-vir08-1"))
+	assert.Error(t, err)
+}
+
+func TestAdoptExistingVnpusAsPartitions(t *testing.T) {
+	backend := newTestFakeBackend(t, partitionTestInventory)
+	checkpointDir := t.TempDir()
+	state := newTestDeviceStateFor(t, backend, partitionTestInventory, checkpointDir, ExistingVnpuPolicyAdopt, partitionable)
+
+	// The adopted vNPU is taken out of the counters of its chip, which can no
+	// longer be allocated as a whole.
+	assert.NotContains(t, state.allocatable, "npu-1-0")
+	require.Contains(t, state.allocatable, "npu-1-vdev100")
+	assert.Empty(t, state.allocatable["npu-1-vdev100"].ConsumesCounters)
+	adopted := state.allocatable["npu-1-vdev100"].Attributes
+	assert.Equal(t, int64(1), *adopted[DriverDomain+"aicpu"].IntValue)
+	assert.Equal(t, int64(2), *adopted[DriverDomain+"jpegd"].IntValue)
+	assert.Equal(t, int64(0), *adopted[DriverDomain+"venc"].IntValue)
+	assert.Equal(t, ChipCapacity{
+		AICore: 7, MemoryGiB: 18, AICPU: 6,
+		Media: MediaEngines{VPC: 11, VENC: 3, VDEC: 11, JPEGD: 14, JPEGE: 7},
+	}, state.partitionedNpus[1].capacity)
+
+	_, err := state.Prepare(newTestClaim(t, "uid-1", "", "npu-1-vdev100"))
+	require.NoError(t, err)
+	checkpoint := newCheckpoint()
+	require.NoError(t, state.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint))
+	prepared := checkpoint.V1.PreparedClaims["uid-1"]
+	require.Len(t, prepared, 1)
+	assert.Equal(t, &PreparedVirtualDevice{LogicID: 1, VDevID: 100, Adopted: true}, prepared[0].VirtualDevice)
+
+	// A claim still holds the vNPU after a restart, so it is published again
+	// even if unowned vNPUs are to be cleaned up.
+	restarted := newTestDeviceStateFor(t, backend, partitionTestInventory, checkpointDir, ExistingVnpuPolicyCleanup, partitionable)
+	assert.Contains(t, restarted.allocatable, "npu-1-vdev100")
+	require.NoError(t, restarted.Unprepare("uid-1"))
+	info, err := backend.GetVirtualDeviceInfo(1)
+	require.NoError(t, err)
+	assert.Len(t, info.VDevInfo, 1)
+}
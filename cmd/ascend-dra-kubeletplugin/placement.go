@@ -0,0 +1,142 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+
+	configapi "Ascend-dra-driver/api/example.com/resource/gpu/v1alpha2"
+)
+
+// ParseVnpuPlacement validates the default vNPU placement policy given on the
+// command line.
+func ParseVnpuPlacement(value string) (configapi.VnpuPlacement, error) {
+	policy := configapi.VnpuPlacement(value)
+	if err := policy.Validate(); err != nil {
+		return "", fmt.Errorf("invalid vNPU placement: %v", err)
+	}
+	return policy, nil
+}
+
+// SetPlacement sets the policy used for vNPUs whose config does not choose
+// one, and the filter of the chips vNPUs may be moved to. A nil filter allows
+// all chips.
+func (m *VnpuManager) SetPlacement(policy configapi.VnpuPlacement, usable func(logicID int32) bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.placement = policy
+	m.usable = usable
+}
+
+// placementCandidate is a chip a vNPU can be carved from, together with the
+// available slice holding the rest of it and the template that would be used.
+type placementCandidate struct {
+	npu     *PhysicalNpuState
+	sliceID string
+	tpl     *VnpuTemplate
+}
+
+// placeVnpu picks the chip a vNPU of the requested size is carved from and
+// returns it with the slice to carve it from. Besides the chip named by the
+// scheduler, only chips of the same model whose carvable slice is among the
+// claim devices are considered: the scheduler reserved these for the claim,
+// any other slice may be handed to another claim. The named chip is kept
+// unless the policy ranks another chip strictly better.
+func (m *VnpuManager) placeVnpu(
+	named *PhysicalNpuState,
+	deviceName string,
+	requirements VnpuRequirements,
+	policy configapi.VnpuPlacement,
+	claimDevices []string,
+) (*PhysicalNpuState, string) {
+	if policy == "" {
+		policy = m.placement
+	}
+	if policy == "" || policy == configapi.SchedulerPlacement {
+		return named, deviceName
+	}
+
+	var candidates []placementCandidate
+	if tpl := bestTemplate(named, requirements); tpl != nil {
+		candidates = append(candidates, placementCandidate{npu: named, sliceID: deviceName, tpl: tpl})
+	}
+	var others []*PhysicalNpuState
+	for _, npu := range m.PhysicalNpus {
+		if npu != named && npu.ModelName == named.ModelName && (m.usable == nil || m.usable(npu.LogicID)) {
+			others = append(others, npu)
+		}
+	}
+	slices.SortFunc(others, func(a, b *PhysicalNpuState) int { return cmp.Compare(a.LogicID, b.LogicID) })
+	for _, npu := range others {
+		slice := carvableSlice(npu)
+		if slice == nil || !slices.Contains(claimDevices, slice.SliceID) {
+			continue
+		}
+		if tpl := bestTemplate(npu, requirements); tpl != nil {
+			candidates = append(candidates, placementCandidate{npu: npu, sliceID: slice.SliceID, tpl: tpl})
+		}
+	}
+	if len(candidates) == 0 {
+		return named, deviceName
+	}
+
+	compare := placementOrder(policy)
+	slices.SortStableFunc(candidates, compare)
+	chosen := candidates[0]
+	if chosen.npu != named {
+		log.Printf("Placement policy %s moves the vNPU requested on %s to %s", policy, deviceName, chosen.sliceID)
+	}
+	return chosen.npu, chosen.sliceID
+}
+
+// placementOrder returns the order of the candidate chips under a policy, the
+// preferred chip first.
+func placementOrder(policy configapi.VnpuPlacement) func(a, b placementCandidate) int {
+	binpack := func(a, b placementCandidate) int {
+		return cmp.Compare(usedAicore(b.npu), usedAicore(a.npu))
+	}
+	switch policy {
+	case configapi.BinpackPlacement:
+		return binpack
+	case configapi.SpreadPlacement:
+		return func(a, b placementCandidate) int {
+			return cmp.Compare(usedAicore(a.npu), usedAicore(b.npu))
+		}
+	case configapi.SameTemplatePlacement:
+		return func(a, b placementCandidate) int {
+			return cmp.Or(
+				cmp.Compare(countTemplate(b.npu, b.tpl.Name), countTemplate(a.npu, a.tpl.Name)),
+				binpack(a, b),
+			)
+		}
+	}
+	return func(a, b placementCandidate) int { return 0 }
+}
+
+// carvableSlice returns the available slice of a chip new vNPUs are carved
+// from, i.e. the full card or the slice offering the rest of it.
+func carvableSlice(npu *PhysicalNpuState) *VnpuSlice {
+	for _, s := range npu.AvailableSlices {
+		if s.TemplateName == "" && !s.Allocated {
+			return s
+		}
+	}
+	return nil
+}
+
+// usedAicore returns the AICores of a chip taken by its vNPUs.
+func usedAicore(npu *PhysicalNpuState) int {
+	return npu.Capacity.AICore - remainingCapacity(npu).AICore
+}
+
+// countTemplate counts the vNPUs of a template on a chip, allocated or adopted.
+func countTemplate(npu *PhysicalNpuState, templateName string) int {
+	count := 0
+	for _, s := range slices.Concat(npu.AllocatedSlices, npu.AvailableSlices) {
+		if s.TemplateName == templateName {
+			count++
+		}
+	}
+	return count
+}
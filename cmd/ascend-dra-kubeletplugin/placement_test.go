@@ -0,0 +1,258 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1"
+
+	configapi "Ascend-dra-driver/api/example.com/resource/gpu/v1alpha2"
+)
+
+const placementTestInventory = `
+templates:
+- {name: vir01, aicore: 1, memoryGiB: 3}
+- {name: vir02, aicore: 2, memoryGiB: 6}
+- {name: vir04, aicore: 4, memoryGiB: 12}
+devices:
+- {logicID: 0, phyID: 0, chipName: 310P3, aicore: 8, memoryGiB: 24}
+- {logicID: 1, phyID: 1, chipName: 310P3, aicore: 8, memoryGiB: 24}
+- {logicID: 2, phyID: 2, chipName: 310P3, aicore: 8, memoryGiB: 24}
+`
+
+type testAllocation struct {
+	device          string
+	aicore, memory  int
+	expectedSliceID string
+}
+
+func (a testAllocation) requirements() VnpuRequirements {
+	return VnpuRequirements{Min: ChipCapacity{AICore: a.aicore, MemoryGiB: a.memory}}
+}
+
+func TestVnpuPlacement(t *testing.T) {
+	testCases := map[string]struct {
+		nodePlacement configapi.VnpuPlacement
+		unusable      []int32
+		// existing are carved on the named chips before the request.
+		existing  []testAllocation
+		placement configapi.VnpuPlacement
+		request   testAllocation
+		// claimDevices are the devices allocated to the claim of the request,
+		// by default the carvable slices of all chips.
+		claimDevices []string
+	}{
+		"scheduler keeps the named chip": {
+			nodePlacement: configapi.SchedulerPlacement,
+			existing:      []testAllocation{{device: "npu-1-0", aicore: 2, memory: 6}},
+			request:       testAllocation{device: "npu-0-0", aicore: 1, memory: 3, expectedSliceID: "npu-0-0"},
+		},
+		"binpack moves to the most used chip": {
+			nodePlacement: configapi.BinpackPlacement,
+			existing: []testAllocation{
+				{device: "npu-1-0", aicore: 2, memory: 6},
+				{device: "npu-2-0", aicore: 4, memory: 12},
+			},
+			request: testAllocation{device: "npu-0-0", aicore: 1, memory: 3, expectedSliceID: "npu-2-1"},
+		},
+		"binpack skips chips the vNPU does not fit on": {
+			nodePlacement: configapi.BinpackPlacement,
+			existing: []testAllocation{
+				{device: "npu-1-0", aicore: 2, memory: 6},
+				{device: "npu-2-0", aicore: 4, memory: 12},
+				{device: "npu-2-1", aicore: 2, memory: 6},
+			},
+			request: testAllocation{device: "npu-0-0", aicore: 4, memory: 12, expectedSliceID: "npu-1-1"},
+		},
+		"binpack skips unusable chips": {
+			nodePlacement: configapi.BinpackPlacement,
+			unusable:      []int32{1},
+			existing:      []testAllocation{{device: "npu-1-0", aicore: 2, memory: 6}},
+			request:       testAllocation{device: "npu-0-0", aicore: 1, memory: 3, expectedSliceID: "npu-0-0"},
+		},
+		"binpack keeps the named chip on a tie": {
+			nodePlacement: configapi.BinpackPlacement,
+			request:       testAllocation{device: "npu-2-0", aicore: 1, memory: 3, expectedSliceID: "npu-2-0"},
+		},
+		"binpack skips chips not allocated to the claim": {
+			nodePlacement: configapi.BinpackPlacement,
+			existing: []testAllocation{
+				{device: "npu-1-0", aicore: 2, memory: 6},
+				{device: "npu-2-0", aicore: 4, memory: 12},
+			},
+			claimDevices: []string{"npu-0-0", "npu-1-1"},
+			request:      testAllocation{device: "npu-0-0", aicore: 1, memory: 3, expectedSliceID: "npu-1-1"},
+		},
+		"spread moves to the least used chip": {
+			nodePlacement: configapi.SpreadPlacement,
+			existing:      []testAllocation{{device: "npu-1-0", aicore: 2, memory: 6}},
+			request:       testAllocation{device: "npu-1-1", aicore: 2, memory: 6, expectedSliceID: "npu-0-0"},
+		},
+		"same template prefers the chips running it": {
+			nodePlacement: configapi.SameTemplatePlacement,
+			existing: []testAllocation{
+				{device: "npu-0-0", aicore: 1, memory: 3},
+				{device: "npu-1-0", aicore: 4, memory: 12},
+			},
+			request: testAllocation{device: "npu-2-0", aicore: 1, memory: 3, expectedSliceID: "npu-0-1"},
+		},
+		"same template packs otherwise": {
+			nodePlacement: configapi.SameTemplatePlacement,
+			existing: []testAllocation{
+				{device: "npu-0-0", aicore: 1, memory: 3},
+				{device: "npu-1-0", aicore: 4, memory: 12},
+			},
+			request: testAllocation{device: "npu-2-0", aicore: 2, memory: 6, expectedSliceID: "npu-1-1"},
+		},
+		"the config overrides the node policy": {
+			nodePlacement: configapi.SchedulerPlacement,
+			existing:      []testAllocation{{device: "npu-1-0", aicore: 2, memory: 6}},
+			placement:     configapi.BinpackPlacement,
+			request:       testAllocation{device: "npu-0-0", aicore: 1, memory: 3, expectedSliceID: "npu-1-1"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			state, _ := newTestDeviceState(t, placementTestInventory)
+			manager := state.vnpuManager
+			for _, a := range tc.existing {
+				_, err := manager.AllocateSlice(a.device, a.requirements(), configapi.SchedulerPlacement, nil)
+				require.NoError(t, err)
+			}
+			claimDevices := tc.claimDevices
+			if claimDevices == nil {
+				for _, npu := range manager.PhysicalNpus {
+					claimDevices = append(claimDevices, carvableSlice(npu).SliceID)
+				}
+			}
+			manager.SetPlacement(tc.nodePlacement, func(logicID int32) bool {
+				for _, id := range tc.unusable {
+					if id == logicID {
+						return false
+					}
+				}
+				return true
+			})
+
+			slice, err := manager.AllocateSlice(tc.request.device, tc.request.requirements(), tc.placement, claimDevices)
+			require.NoError(t, err)
+			assert.Equal(t, tc.request.expectedSliceID, slice.SliceID)
+		})
+	}
+}
+
+func TestPrepareWithVnpuPlacement(t *testing.T) {
+	state, backend := newTestDeviceState(t, placementTestInventory)
+	_, err := state.Prepare(newTestClaim(t, "uid-1", "vir02", "npu-1-0"))
+	require.NoError(t, err)
+
+	large := configapi.DefaultNpuConfig()
+	large.Vnpu = &configapi.VnpuSpec{TemplateName: "vir04", Placement: configapi.SpreadPlacement}
+	claim := newTestClaimWithConfig(t, "uid-2", nil)
+	claim.Status.Allocation.Devices.Results = []resourceapi.DeviceRequestAllocationResult{
+		{Request: "large", Driver: DriverName, Pool: "node", Device: "npu-1-1"},
+		{Request: "small", Driver: DriverName, Pool: "node", Device: "npu-0-0"},
+	}
+	claim.Status.Allocation.Devices.Config = []resourceapi.DeviceAllocationConfiguration{
+		newTestOpaqueConfig(t, resourceapi.AllocationConfigSourceClaim, large, "large"),
+		newTestOpaqueConfig(t, resourceapi.AllocationConfigSourceClaim, newTestVnpuConfig("vir01", nil), "small"),
+	}
+	devices, err := state.Prepare(claim)
+	require.NoError(t, err)
+
+	// The large vNPU is moved to the unused chip of the claim, the small one
+	// takes over the device left on the used chip.
+	deviceNames := make(map[string]string)
+	for _, device := range devices {
+		deviceNames[device.RequestNames[0]] = device.DeviceName
+	}
+	assert.Equal(t, map[string]string{"large": "npu-0-0", "small": "npu-1-1"}, deviceNames)
+	info, err := backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	require.Len(t, info.VDevInfo, 1)
+	assert.Equal(t, "vir04", info.VDevInfo[0].QueryInfo.Name)
+	info, err = backend.GetVirtualDeviceInfo(1)
+	require.NoError(t, err)
+	assert.Len(t, info.VDevInfo, 2)
+	// The chip not allocated to the claim is left alone.
+	info, err = backend.GetVirtualDeviceInfo(2)
+	require.NoError(t, err)
+	assert.Empty(t, info.VDevInfo)
+}
+
+func TestNodeVnpuPlacementOfMultiRequestClaim(t *testing.T) {
+	testCases := map[string]struct {
+		placement configapi.VnpuPlacement
+		// allocated are the devices the scheduler picked for each request.
+		allocated map[string]string
+		expected  map[string]string
+	}{
+		"scheduler keeps the allocation": {
+			placement: configapi.SchedulerPlacement,
+			allocated: map[string]string{"model": "npu-0-0", "preprocess": "npu-1-1"},
+			expected:  map[string]string{"model": "npu-0-0", "preprocess": "npu-1-1"},
+		},
+		"binpack carves the model from the used chip": {
+			placement: configapi.BinpackPlacement,
+			allocated: map[string]string{"model": "npu-0-0", "preprocess": "npu-1-1"},
+			expected:  map[string]string{"model": "npu-1-1", "preprocess": "npu-0-0"},
+		},
+		"spread carves the model from the unused chip": {
+			placement: configapi.SpreadPlacement,
+			allocated: map[string]string{"model": "npu-1-1", "preprocess": "npu-0-0"},
+			expected:  map[string]string{"model": "npu-0-0", "preprocess": "npu-1-1"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			backend := newTestFakeBackend(t, placementTestInventory)
+			state := newTestDeviceStateFor(t, backend, placementTestInventory, t.TempDir(), ExistingVnpuPolicyCleanup,
+				func(s *DeviceState) { s.vnpuManager.SetPlacement(tc.placement, nil) })
+			_, err := state.Prepare(newTestClaim(t, "uid-1", "vir02", "npu-1-0"))
+			require.NoError(t, err)
+
+			// A model server and its preprocessing, each with a template of
+			// its own and the placement policy of the node.
+			claim := newTestClaimWithConfig(t, "uid-2", nil)
+			claim.Status.Allocation.Devices.Results = []resourceapi.DeviceRequestAllocationResult{
+				{Request: "model", Driver: DriverName, Pool: "node", Device: tc.allocated["model"]},
+				{Request: "preprocess", Driver: DriverName, Pool: "node", Device: tc.allocated["preprocess"]},
+			}
+			claim.Status.Allocation.Devices.Config = []resourceapi.DeviceAllocationConfiguration{
+				newTestOpaqueConfig(t, resourceapi.AllocationConfigSourceClaim, newTestVnpuConfig("vir04", nil), "model"),
+				newTestOpaqueConfig(t, resourceapi.AllocationConfigSourceClaim, newTestVnpuConfig("vir01", nil), "preprocess"),
+			}
+			devices, err := state.Prepare(claim)
+			require.NoError(t, err)
+
+			deviceNames := make(map[string]string)
+			for _, device := range devices {
+				deviceNames[device.RequestNames[0]] = device.DeviceName
+			}
+			assert.Equal(t, tc.expected, deviceNames)
+			checkpoint := newCheckpoint()
+			require.NoError(t, state.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint))
+			for _, device := range checkpoint.V1.PreparedClaims["uid-2"] {
+				template := map[string]string{"model": "vir04", "preprocess": "vir01"}[device.RequestNames[0]]
+				assert.Equal(t, template, device.Slice.TemplateName)
+			}
+		})
+	}
+}
+
+func TestVnpuPlacementMixedChipModels(t *testing.T) {
+	state, _ := newTestDeviceState(t, mixedTestInventory)
+	manager := state.vnpuManager
+	_, err := manager.AllocateSlice("npu-1-0", VnpuRequirements{Min: ChipCapacity{AICore: 1}}, configapi.SchedulerPlacement, nil)
+	require.NoError(t, err)
+
+	// The used 310P1 chip is not considered for a vNPU requested on a 310P3.
+	slice, err := manager.AllocateSlice("npu-0-0", VnpuRequirements{Min: ChipCapacity{AICore: 1}}, configapi.BinpackPlacement,
+		[]string{"npu-0-0", "npu-1-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "npu-0-0", slice.SliceID)
+	assert.Equal(t, "vir02", slice.TemplateName)
+}
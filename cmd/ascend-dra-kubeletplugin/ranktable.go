@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	resourceapi "k8s.io/api/resource/v1"
+	cdispec "tags.cncf.io/container-device-interface/specs-go"
+
+	"Ascend-dra-driver/pkg/common"
+)
+
+const rankTableFileName = "hccl.json"
+
+// isFullCard reports whether a prepared device is a whole physical NPU rather
+// than a vNPU carved from one.
+func isFullCard(device *PreparedDevice) bool {
+	if device.VirtualDevice != nil {
+		return false
+	}
+	return device.Slice == nil || (device.Slice.Type == "NPU" && device.Slice.TemplateName == "")
+}
+
+// addRankTable writes the HCCL rank table of a claim that received several
+// full NPUs and mounts it into the containers with RANK_TABLE_FILE set. Claims
+// with a single NPU or with vNPUs, and chips without a device IP (e.g. 310P),
+// get no rank table.
+func (s *DeviceState) addRankTable(claim *resourceapi.ResourceClaim, devices PreparedDevices) error {
+	var fullCards PreparedDevices
+	for _, device := range devices {
+		if isFullCard(device) {
+			fullCards = append(fullCards, device)
+		}
+	}
+	if len(fullCards) < 2 {
+		return nil
+	}
+
+	table, err := s.buildRankTable(claim, fullCards)
+	if err != nil {
+		log.Printf("Not generating a rank table for claim %s: %v", claim.UID, err)
+		return nil
+	}
+	content, err := json.MarshalIndent(table, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode rank table: %v", err)
+	}
+	dir := filepath.Join(s.rankTableDir, string(claim.UID))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create rank table directory: %v", err)
+	}
+	path := filepath.Join(dir, rankTableFileName)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("failed to write rank table: %v", err)
+	}
+
+	edits := fullCards[0].ContainerEdits
+	edits.Env = append(edits.Env, fmt.Sprintf("%s=%s", common.RankTableEnv, common.RankTableContainerPath))
+	edits.Mounts = append(edits.Mounts, &cdispec.Mount{
+		HostPath:      path,
+		ContainerPath: common.RankTableContainerPath,
+		Options:       defaultMountOptions,
+	})
+	log.Printf("Generated rank table for claim %s with %d NPUs", claim.UID, len(fullCards))
+	return nil
+}
+
+// buildRankTable lists the full NPUs of a claim, ordered by physical ID, as a
+// single instance of the consuming pod.
+func (s *DeviceState) buildRankTable(claim *resourceapi.ResourceClaim, devices PreparedDevices) (*common.RankTable, error) {
+	type rankDevice struct {
+		phyID int32
+		ip    string
+	}
+	var rankDevices []rankDevice
+	for _, device := range devices {
+		logicID, _, err := s.deviceChip(device.DeviceName)
+		if err != nil {
+			return nil, err
+		}
+		phyID, err := s.backend.GetPhysicIDFromLogicID(logicID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get physical ID of logic ID %d: %v", logicID, err)
+		}
+		ip, err := s.backend.GetDeviceIPAddress(logicID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get device IP of logic ID %d: %v", logicID, err)
+		}
+		rankDevices = append(rankDevices, rankDevice{phyID: phyID, ip: ip})
+	}
+	slices.SortFunc(rankDevices, func(a, b rankDevice) int {
+		return int(a.phyID - b.phyID)
+	})
+
+	instance := common.Instance{
+		PodName:  claimConsumer(claim),
+		ServerID: serverID(),
+	}
+	for _, device := range rankDevices {
+		instance.Devices = append(instance.Devices, common.Device{
+			DeviceID: strconv.Itoa(int(device.phyID)),
+			DeviceIP: device.ip,
+		})
+	}
+	return &common.RankTable{
+		Status:     common.RankTableStatusCompleted,
+		GroupCount: "1",
+		GroupList: []common.RankGroup{
+			{
+				GroupName:     claim.Name,
+				DeviceCount:   strconv.Itoa(len(instance.Devices)),
+				InstanceCount: "1",
+				InstanceList:  []common.Instance{instance},
+			},
+		},
+	}, nil
+}
+
+// removeRankTable deletes the rank table of a claim, if it has one.
+func (s *DeviceState) removeRankTable(claimUID string) error {
+	if err := os.RemoveAll(filepath.Join(s.rankTableDir, claimUID)); err != nil {
+		return fmt.Errorf("failed to remove rank table: %v", err)
+	}
+	return nil
+}
+
+// claimConsumer returns the name of the pod the claim is reserved for, or the
+// name of the claim if it is not reserved for a pod.
+func claimConsumer(claim *resourceapi.ResourceClaim) string {
+	for _, consumer := range claim.Status.ReservedFor {
+		if consumer.Resource == "pods" {
+			return consumer.Name
+		}
+	}
+	return claim.Name
+}
+
+// serverID identifies the node in the rank table by its IP, falling back to
+// its name.
+func serverID() string {
+	if hostIP := os.Getenv("HOST_IP"); hostIP != "" {
+		return hostIP
+	}
+	return os.Getenv("NODE_NAME")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1"
+
+	"Ascend-dra-driver/pkg/common"
+)
+
+const rankTableTestInventory = `
+devices:
+- logicID: 0
+  phyID: 5
+  cardID: 0
+  chipName: 910B3
+  aicore: 20
+  memoryGiB: 64
+  deviceIP: 192.168.100.105
+- logicID: 1
+  phyID: 4
+  cardID: 1
+  chipName: 910B3
+  aicore: 20
+  memoryGiB: 64
+  deviceIP: 192.168.100.104
+- logicID: 2
+  phyID: 6
+  cardID: 2
+  chipName: 910B3
+  aicore: 20
+  memoryGiB: 64
+`
+
+func TestPrepareGeneratesRankTable(t *testing.T) {
+	t.Setenv("HOST_IP", "10.0.0.1")
+	state, _ := newTestDeviceState(t, rankTableTestInventory)
+	claim := newTestClaim(t, "uid-1", "", "npu-0-0", "npu-1-0")
+	claim.Status.ReservedFor = []resourceapi.ResourceClaimConsumerReference{
+		{Resource: "pods", Name: "train-0"},
+	}
+
+	_, err := state.Prepare(claim)
+	require.NoError(t, err)
+
+	path := filepath.Join(state.rankTableDir, "uid-1", rankTableFileName)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	table := &common.RankTable{}
+	require.NoError(t, json.Unmarshal(content, table))
+	assert.Equal(t, common.RankTableStatusCompleted, table.Status)
+	require.Len(t, table.GroupList, 1)
+	assert.Equal(t, "2", table.GroupList[0].DeviceCount)
+	require.Len(t, table.GroupList[0].InstanceList, 1)
+	instance := table.GroupList[0].InstanceList[0]
+	assert.Equal(t, "train-0", instance.PodName)
+	assert.Equal(t, "10.0.0.1", instance.ServerID)
+	// The devices are ordered by physical ID, not by logic ID.
+	assert.Equal(t, []common.Device{
+		{DeviceID: "4", DeviceIP: "192.168.100.104"},
+		{DeviceID: "5", DeviceIP: "192.168.100.105"},
+	}, instance.Devices)
+
+	checkpoint := newCheckpoint()
+	require.NoError(t, state.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint))
+	prepared := checkpoint.V1.PreparedClaims["uid-1"]
+	require.Len(t, prepared, 2)
+	edits := prepared[0].ContainerEdits
+	assert.Contains(t, edits.Env, common.RankTableEnv+"="+common.RankTableContainerPath)
+	require.NotEmpty(t, edits.Mounts)
+	mount := edits.Mounts[len(edits.Mounts)-1]
+	assert.Equal(t, path, mount.HostPath)
+	assert.Equal(t, common.RankTableContainerPath, mount.ContainerPath)
+
+	require.NoError(t, state.Unprepare("uid-1"))
+	_, err = os.Stat(filepath.Join(state.rankTableDir, "uid-1"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPrepareWithoutRankTable(t *testing.T) {
+	tests := map[string][]string{
+		"single NPU":         {"npu-0-0"},
+		"missing device IPs": {"npu-0-0", "npu-2-0"},
+	}
+	for name, devices := range tests {
+		t.Run(name, func(t *testing.T) {
+			state, _ := newTestDeviceState(t, rankTableTestInventory)
+
+			_, err := state.Prepare(newTestClaim(t, "uid-1", "", devices...))
+			require.NoError(t, err)
+
+			_, err = os.Stat(filepath.Join(state.rankTableDir, "uid-1"))
+			assert.True(t, os.IsNotExist(err))
+			checkpoint := newCheckpoint()
+			require.NoError(t, state.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint))
+			for _, device := range checkpoint.V1.PreparedClaims["uid-1"] {
+				assert.NotContains(t, device.ContainerEdits.Env, common.RankTableEnv+"="+common.RankTableContainerPath)
+			}
+		})
+	}
+}
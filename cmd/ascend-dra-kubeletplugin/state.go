@@ -1,29 +1,26 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
 	"log"
-	"math"
+	"maps"
 	"os"
+	"path/filepath"
 	"regexp"
 	"slices"
 	"strings"
 	"sync"
 
-	resourceapi "k8s.io/api/resource/v1beta1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	npuCommon "huawei.com/npu-exporter/v5/devmanager/common"
+	resourceapi "k8s.io/api/resource/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1"
 	"k8s.io/kubernetes/pkg/kubelet/checkpointmanager"
 	"k8s.io/utils/ptr"
 
-	configapi "Ascend-dra-driver/api/example.com/resource/gpu/v1alpha1"
+	configapi "Ascend-dra-driver/api/example.com/resource/gpu/v1alpha2"
+	"Ascend-dra-driver/pkg/common"
 
-	"k8s.io/apimachinery/pkg/api/errors"
 	cdiapi "tags.cncf.io/container-device-interface/pkg/cdi"
 	cdispec "tags.cncf.io/container-device-interface/specs-go"
 )
@@ -40,9 +37,33 @@ type OpaqueDeviceConfig struct {
 	Config   runtime.Object
 }
 
+// appliesTo reports whether the config targets a request. A config without
+// requests applies to all of them, and a config naming a request applies to
+// its subrequests as well.
+func (c *OpaqueDeviceConfig) appliesTo(request string) bool {
+	if len(c.Requests) == 0 {
+		return true
+	}
+	parent, _, _ := strings.Cut(request, "/")
+	return slices.Contains(c.Requests, request) || slices.Contains(c.Requests, parent)
+}
+
 type VnpuTemplateAttribute struct {
 	AICORE int
 	Memory int
+	AICPU  int
+	Media  MediaEngines
+}
+
+// MediaEngines counts the media processing engines of a chip, or those taken
+// by a vNPU.
+type MediaEngines struct {
+	VPC   int `json:"vpc,omitempty"`
+	VENC  int `json:"venc,omitempty"`
+	VDEC  int `json:"vdec,omitempty"`
+	JPEGD int `json:"jpegd,omitempty"`
+	JPEGE int `json:"jpege,omitempty"`
+	PNGD  int `json:"pngd,omitempty"`
 }
 
 type VnpuTemplate struct {
@@ -55,6 +76,10 @@ type VnpuSlice struct {
 	TemplateName string
 	Allocated    bool
 	Type         string
+	VDevID       uint32
+	// Adopted is set for vNPUs that existed on the chip at startup. They are
+	// handed out as-is and stay on the chip when released.
+	Adopted bool
 }
 
 type PhysicalNpuState struct {
@@ -62,8 +87,15 @@ type PhysicalNpuState struct {
 	PhysicalDeviceID string
 	LogicID          int32
 	ModelName        string
-	AvailableSlices  []*VnpuSlice
-	AllocatedSlices  []*VnpuSlice
+	Topology         NpuTopology
+	// Capacity holds the total resources of the chip. Resources the chip
+	// does not report are zero and not accounted for.
+	Capacity        ChipCapacity
+	AvailableSlices []*VnpuSlice
+	AllocatedSlices []*VnpuSlice
+	// Templates are all templates of the chip model, SupportTemplates those
+	// that can still be carved from what is left on the chip.
+	Templates        map[string]*VnpuTemplate
 	SupportTemplates map[string]*VnpuTemplate
 	NextSliceIndex   int
 }
@@ -73,8 +105,12 @@ type DeviceUpdateCallback func(deviceName string, physicalNpu *PhysicalNpuState)
 type VnpuManager struct {
 	sync.Mutex
 	PhysicalNpus         map[string]*PhysicalNpuState
-	Templates            map[string]*VnpuTemplate
+	Catalog              TemplateCatalog
 	deviceUpdateCallback DeviceUpdateCallback
+	// placement is the policy for vNPUs whose config chooses none, usable
+	// filters the chips vNPUs may be moved to by it.
+	placement configapi.VnpuPlacement
+	usable    func(logicID int32) bool
 }
 
 func (m *VnpuManager) SetDeviceUpdateCallback(callback DeviceUpdateCallback) {
@@ -86,6 +122,25 @@ func (m *VnpuManager) SetDeviceUpdateCallback(callback DeviceUpdateCallback) {
 type PreparedDevice struct {
 	drapbv1.Device
 	ContainerEdits *cdiapi.ContainerEdits
+	Slice          *PreparedSlice         `json:"slice,omitempty"`
+	VirtualDevice  *PreparedVirtualDevice `json:"virtualDevice,omitempty"`
+}
+
+// PreparedSlice records the VnpuManager slice backing a prepared device, so
+// that the allocation state can be rebuilt from the checkpoint on restart.
+type PreparedSlice struct {
+	PhysicalDevice string `json:"physicalDevice"`
+	SliceID        string `json:"sliceID"`
+	Type           string `json:"type"`
+	TemplateName   string `json:"templateName,omitempty"`
+}
+
+// PreparedVirtualDevice records the vNPU that was created on a chip for a
+// prepared device, so that it can be destroyed again on unprepare.
+type PreparedVirtualDevice struct {
+	LogicID int32  `json:"logicID"`
+	VDevID  uint32 `json:"vdevID"`
+	Adopted bool   `json:"adopted,omitempty"`
 }
 
 func (pds PreparedDevices) GetDevices() []*drapbv1.Device {
@@ -98,14 +153,23 @@ func (pds PreparedDevices) GetDevices() []*drapbv1.Device {
 
 type DeviceState struct {
 	sync.Mutex
+	backend           NpuBackend
+	simulated         bool
 	cdi               *CDIHandler
+	rankTableDir      string
 	allocatable       AllocatableDevices
 	checkpointManager checkpointmanager.CheckpointManager
 	vnpuManager       *VnpuManager
+
+	// In partitionable mode the scheduler allocates vNPUs from the counters
+	// of each chip and the VnpuManager slices are not used.
+	partitionable   bool
+	partitions      map[string]*PartitionDevice
+	partitionedNpus map[int32]*partitionedNpu
 }
 
 func NewDeviceState(config *Config) (*DeviceState, error) {
-	allocatable, vnpuManager, err := enumerateAllPossibleDevices()
+	allocatable, vnpuManager, existingVnpus, err := enumerateAllPossibleDevices(config.backend, config.templates.Catalog())
 	if err != nil {
 		return nil, fmt.Errorf("error enumerating all possible devices: %v", err)
 	}
@@ -126,13 +190,21 @@ func NewDeviceState(config *Config) (*DeviceState, error) {
 	}
 
 	state := &DeviceState{
+		backend:           config.backend,
+		simulated:         config.flags.simulateInventory != "",
 		cdi:               cdi,
+		rankTableDir:      filepath.Join(DriverPluginPath, RankTableDirName),
 		allocatable:       allocatable,
 		checkpointManager: checkpointManager,
 		vnpuManager:       vnpuManager,
+		partitionable:     config.flags.partitionableDevices,
 	}
 
-	if vnpuManager != nil {
+	if state.partitionable {
+		if err := state.initPartitions(vnpuManager.Catalog); err != nil {
+			return nil, fmt.Errorf("unable to publish partitionable devices: %v", err)
+		}
+	} else if vnpuManager != nil {
 		vnpuManager.SetDeviceUpdateCallback(func(deviceName string, physicalNpu *PhysicalNpuState) {
 			if added := state.UpdateAllocatableDevice(deviceName, physicalNpu); added {
 				log.Printf("Added new device %s to allocatable devices", deviceName)
@@ -147,11 +219,10 @@ func NewDeviceState(config *Config) (*DeviceState, error) {
 
 	for _, c := range checkpoints {
 		if c == DriverPluginCheckpointFile {
-			if vnpuManager != nil {
-				if err := CreatePredefinedDeviceClasses(vnpuManager); err != nil {
-					log.Printf("Failed to create predefined DeviceClasses: %v", err)
-				}
+			if err := state.restoreFromCheckpoint(); err != nil {
+				return nil, fmt.Errorf("unable to restore state from checkpoint: %v", err)
 			}
+			state.reconcileExistingVnpus(existingVnpus, config.existingVnpuPolicy)
 			return state, nil
 		}
 	}
@@ -160,13 +231,7 @@ func NewDeviceState(config *Config) (*DeviceState, error) {
 	if err := state.checkpointManager.CreateCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
 		return nil, fmt.Errorf("unable to sync to checkpoint: %v", err)
 	}
-	if vnpuManager != nil {
-		go func() {
-			if err := CreatePredefinedDeviceClasses(vnpuManager); err != nil {
-				log.Printf("Failed to create predefined DeviceClasses: %v", err)
-			}
-		}()
-	}
+	state.reconcileExistingVnpus(existingVnpus, config.existingVnpuPolicy)
 	return state, nil
 }
 
@@ -178,7 +243,7 @@ func (s *DeviceState) Prepare(claim *resourceapi.ResourceClaim) ([]*drapbv1.Devi
 
 	checkpoint := newCheckpoint()
 	if err := s.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
-		return nil, fmt.Errorf("unable to sync from checkpoint: %v", err)
+		return nil, newPrepareError(PrepareFailureCheckpoint, fmt.Errorf("unable to sync from checkpoint: %v", err))
 	}
 	preparedClaims := checkpoint.V1.PreparedClaims
 
@@ -188,21 +253,44 @@ func (s *DeviceState) Prepare(claim *resourceapi.ResourceClaim) ([]*drapbv1.Devi
 
 	preparedDevices, err := s.prepareDevices(claim)
 	if err != nil {
-		return nil, fmt.Errorf("prepare failed: %v", err)
+		return nil, fmt.Errorf("prepare failed: %w", err)
+	}
+
+	if err := s.addRankTable(claim, preparedDevices); err != nil {
+		if err := s.unprepareDevices(claimUID, preparedDevices); err != nil {
+			log.Printf("Warning: failed to release devices of claim %s: %v", claimUID, err)
+		}
+		return nil, newPrepareError(PrepareFailureRankTable, err)
 	}
 
 	if err = s.cdi.CreateClaimSpecFile(claimUID, preparedDevices); err != nil {
-		return nil, fmt.Errorf("unable to create CDI spec file for claim: %v", err)
+		s.rollbackClaim(claimUID, preparedDevices)
+		return nil, newPrepareError(PrepareFailureCDI, fmt.Errorf("unable to create CDI spec file for claim: %v", err))
 	}
 
 	preparedClaims[claimUID] = preparedDevices
 	if err := s.checkpointManager.CreateCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
-		return nil, fmt.Errorf("unable to sync to checkpoint: %v", err)
+		if err := s.cdi.DeleteClaimSpecFile(claimUID); err != nil {
+			log.Printf("Warning: failed to delete CDI spec file of claim %s: %v", claimUID, err)
+		}
+		s.rollbackClaim(claimUID, preparedDevices)
+		return nil, newPrepareError(PrepareFailureCheckpoint, fmt.Errorf("unable to sync to checkpoint: %v", err))
 	}
 
 	return preparedClaims[claimUID].GetDevices(), nil
 }
 
+// rollbackClaim releases the devices and the rank table of a claim whose
+// prepare failed after its devices were prepared.
+func (s *DeviceState) rollbackClaim(claimUID string, preparedDevices PreparedDevices) {
+	if err := s.removeRankTable(claimUID); err != nil {
+		log.Printf("Warning: failed to remove rank table of claim %s: %v", claimUID, err)
+	}
+	if err := s.unprepareDevices(claimUID, preparedDevices); err != nil {
+		log.Printf("Warning: failed to release devices of claim %s: %v", claimUID, err)
+	}
+}
+
 func (s *DeviceState) Unprepare(claimUID string) error {
 	s.Lock()
 	defer s.Unlock()
@@ -225,6 +313,10 @@ func (s *DeviceState) Unprepare(claimUID string) error {
 		return fmt.Errorf("unable to delete CDI spec file for claim: %v", err)
 	}
 
+	if err := s.removeRankTable(claimUID); err != nil {
+		return err
+	}
+
 	delete(preparedClaims, claimUID)
 	if err := s.checkpointManager.CreateCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
 		return fmt.Errorf("unable to sync to checkpoint: %v", err)
@@ -233,9 +325,29 @@ func (s *DeviceState) Unprepare(claimUID string) error {
 	return nil
 }
 
+// preparedDevicesPerNpu counts the devices prepared for claims on each
+// physical NPU. The caller must hold the state lock.
+func (s *DeviceState) preparedDevicesPerNpu() (map[string]int, error) {
+	checkpoint := newCheckpoint()
+	if err := s.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
+		return nil, fmt.Errorf("unable to sync from checkpoint: %v", err)
+	}
+	counts := make(map[string]int)
+	for _, devices := range checkpoint.V1.PreparedClaims {
+		for _, device := range devices {
+			npu := device.DeviceName
+			if device.Slice != nil {
+				npu = device.Slice.PhysicalDevice
+			}
+			counts[npu]++
+		}
+	}
+	return counts, nil
+}
+
 func (s *DeviceState) prepareDevices(claim *resourceapi.ResourceClaim) (PreparedDevices, error) {
 	if claim.Status.Allocation == nil {
-		return nil, fmt.Errorf("claim not yet allocated")
+		return nil, newPrepareError(PrepareFailureNotAllocated, fmt.Errorf("claim not yet allocated"))
 	}
 
 	// Retrieve the full set of device configs for the driver.
@@ -245,36 +357,90 @@ func (s *DeviceState) prepareDevices(claim *resourceapi.ResourceClaim) (Prepared
 		claim.Status.Allocation.Devices.Config,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("error getting opaque device configs: %v", err)
+		return nil, newPrepareError(PrepareFailureInvalidConfig, fmt.Errorf("error getting opaque device configs: %v", err))
 	}
 
-	// Add the default GPU Config to the front of the config list with the
+	// Convert configs of older kinds, such as the v1alpha1 GpuConfig, so that
+	// only NpuConfigs need to be handled below.
+	for _, c := range configs {
+		if c.Config, err = configapi.ToNpuConfig(c.Config); err != nil {
+			return nil, newPrepareError(PrepareFailureInvalidConfig, err)
+		}
+	}
+
+	// Add the default NPU Config to the front of the config list with the
 	// lowest precedence. This guarantees there will be at least one config in
 	// the list with len(Requests) == 0 for the lookup below.
 	configs = slices.Insert(configs, 0, &OpaqueDeviceConfig{
 		Requests: []string{},
-		Config:   configapi.DefaultGpuConfig(),
+		Config:   configapi.DefaultNpuConfig(),
 	})
 
 	// Look through the configs and figure out which one will be applied to
 	// each device allocation result based on their order of precedence.
 	configResultsMap := make(map[runtime.Object][]*resourceapi.DeviceRequestAllocationResult)
+	sliceRecords := make(map[string]*PreparedSlice)
+	virtualDevices := make(map[string]*PreparedVirtualDevice)
+	// Placement policies may only move vNPUs between the devices allocated to
+	// the claim for vNPUs. When a vNPU is moved onto the device of another
+	// request, that request takes over the device left unused.
+	var vnpuDevices []string
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		if vnpuSpecForRequest(configs, result.Request) != nil {
+			vnpuDevices = append(vnpuDevices, result.Device)
+		}
+	}
+	substitutes := make(map[string]string)
 	for _, result := range claim.Status.Allocation.Devices.Results {
 		origDevice := result.Device
 
-		// If vnpuManager is available, try to allocate vNPU slices first
-		if s.vnpuManager != nil {
-			if err := s.allocateVnpuSlice(&result, configs, origDevice); err != nil {
-				log.Printf("Warning: failed to allocate vNPU slice: %v, attempting to use full card allocation", err)
+		if s.partitionable {
+			record, vdev, err := s.preparePartition(result.Device)
+			if err != nil {
+				s.rollbackSlices(sliceRecords, virtualDevices)
+				return nil, err
+			}
+			sliceRecords[result.Device] = record
+			if vdev != nil {
+				virtualDevices[result.Device] = vdev
+			}
+		} else if s.vnpuManager != nil {
+			// If vnpuManager is available, try to allocate vNPU slices first
+			device := origDevice
+			for substitutes[device] != "" {
+				device = substitutes[device]
+			}
+			if err := s.allocateVnpuSlice(&result, configs, device, vnpuDevices); err != nil {
+				s.rollbackSlices(sliceRecords, virtualDevices)
+				return nil, newPrepareError(PrepareFailureVnpu, fmt.Errorf("failed to allocate vNPU slice for %s: %v", device, err))
+			}
+			if result.Device != device {
+				substitutes[result.Device] = device
+			}
+			vnpuDevices = slices.DeleteFunc(vnpuDevices, func(d string) bool { return d == result.Device })
+			vdev, err := s.createVnpu(result.Device)
+			if err != nil {
+				s.rollbackSlices(sliceRecords, virtualDevices)
+				return nil, newPrepareError(PrepareFailureVnpu, fmt.Errorf("failed to create vNPU for %s: %v", result.Device, err))
 			}
+			if vdev != nil {
+				virtualDevices[result.Device] = vdev
+			}
+			record, err := s.vnpuManager.GetPreparedSlice(result.Device)
+			if err != nil {
+				s.rollbackSlices(sliceRecords, virtualDevices)
+				return nil, err
+			}
+			sliceRecords[result.Device] = record
 		}
 
 		if _, ok := s.allocatable[origDevice]; !ok {
-			return nil, fmt.Errorf("requested NPU is not allocatable: %v", origDevice)
+			s.rollbackSlices(sliceRecords, virtualDevices)
+			return nil, newPrepareError(PrepareFailureNotAllocatable, fmt.Errorf("requested NPU is not allocatable: %v", origDevice))
 		}
 		// Find matching config
 		for _, c := range slices.Backward(configs) {
-			if len(c.Requests) == 0 || slices.Contains(c.Requests, result.Request) {
+			if c.appliesTo(result.Request) {
 				configResultsMap[c.Config] = append(configResultsMap[c.Config], &result)
 				break
 			}
@@ -286,29 +452,30 @@ func (s *DeviceState) prepareDevices(claim *resourceapi.ResourceClaim) (Prepared
 	// config to the set of device allocation results.
 	perDeviceCDIContainerEdits := make(PerDeviceCDIContainerEdits)
 	for c, results := range configResultsMap {
-		// Cast the opaque config to a GpuConfig
-		var config *configapi.GpuConfig
-		switch castConfig := c.(type) {
-		case *configapi.GpuConfig:
-			config = castConfig
-		default:
-			return nil, fmt.Errorf("runtime object is not a regognized configuration")
+		// Cast the opaque config to an NpuConfig
+		config, ok := c.(*configapi.NpuConfig)
+		if !ok {
+			s.rollbackSlices(sliceRecords, virtualDevices)
+			return nil, newPrepareError(PrepareFailureInvalidConfig, fmt.Errorf("runtime object is not a regognized configuration"))
 		}
 
 		// Normalize the config to set any implied defaults.
 		if err := config.Normalize(); err != nil {
-			return nil, fmt.Errorf("error normalizing GPU config: %w", err)
+			s.rollbackSlices(sliceRecords, virtualDevices)
+			return nil, newPrepareError(PrepareFailureInvalidConfig, fmt.Errorf("error normalizing NPU config: %w", err))
 		}
 
 		// Validate the config to ensure its integrity.
 		if err := config.Validate(); err != nil {
-			return nil, fmt.Errorf("error validating GPU config: %w", err)
+			s.rollbackSlices(sliceRecords, virtualDevices)
+			return nil, newPrepareError(PrepareFailureInvalidConfig, fmt.Errorf("error validating NPU config: %w", err))
 		}
 
 		// Apply the config to the list of results associated with it.
-		containerEdits, err := s.applyConfig(config, results)
+		containerEdits, err := s.applyConfig(config, results, sliceRecords, virtualDevices)
 		if err != nil {
-			return nil, fmt.Errorf("error applying GPU config: %w", err)
+			s.rollbackSlices(sliceRecords, virtualDevices)
+			return nil, newPrepareError(PrepareFailureInvalidConfig, fmt.Errorf("error applying NPU config: %w", err))
 		}
 
 		// Merge any new container edits with the overall per device map.
@@ -330,6 +497,8 @@ func (s *DeviceState) prepareDevices(claim *resourceapi.ResourceClaim) (Prepared
 					CDIDeviceIDs: s.cdi.GetClaimDevices(string(claim.UID), []string{result.Device}),
 				},
 				ContainerEdits: perDeviceCDIContainerEdits[result.Device],
+				Slice:          sliceRecords[result.Device],
+				VirtualDevice:  virtualDevices[result.Device],
 			}
 			preparedDevices = append(preparedDevices, device)
 		}
@@ -338,42 +507,199 @@ func (s *DeviceState) prepareDevices(claim *resourceapi.ResourceClaim) (Prepared
 	return preparedDevices, nil
 }
 
-// allocateVnpuSlice tries to allocate a vNPU slice based on user requirements
+// vnpuSpecForRequest returns the vNPU spec of the config with the highest
+// precedence among those targeting the request that set one, or nil if none
+// does. A config that only sets runtime options thus keeps the vNPU of a
+// config with lower precedence.
+func vnpuSpecForRequest(configs []*OpaqueDeviceConfig, request string) *configapi.VnpuSpec {
+	for _, c := range slices.Backward(configs) {
+		if !c.appliesTo(request) {
+			continue
+		}
+		if npuConfig, ok := c.Config.(*configapi.NpuConfig); ok && npuConfig.Vnpu != nil {
+			return npuConfig.Vnpu
+		}
+	}
+	return nil
+}
+
+// allocateVnpuSlice tries to allocate a vNPU slice based on the vNPU spec
+// targeting the request of the result.
 func (s *DeviceState) allocateVnpuSlice(
 	result *resourceapi.DeviceRequestAllocationResult,
 	configs []*OpaqueDeviceConfig,
 	origDevice string,
+	claimDevices []string,
 ) error {
-	var requestedAicore, requestedMemory int
-	var templateName string
-	for _, oc := range configs {
-		if gpuConfig, ok := oc.Config.(*configapi.GpuConfig); ok {
-			if gpuConfig.VnpuSpec != nil && gpuConfig.VnpuSpec.TemplateName != "" {
-				templateName = gpuConfig.VnpuSpec.TemplateName
-				if tpl, found := s.vnpuManager.Templates[templateName]; found {
-					requestedAicore = tpl.Attributes.AICORE
-					requestedMemory = tpl.Attributes.Memory
-					log.Printf("Obtained resource requirements from template %s: AICORE=%d, Memory=%dGB",
-						templateName, requestedAicore, requestedMemory)
-					break
-				}
+	var requirements VnpuRequirements
+	var placement configapi.VnpuPlacement
+	if spec := vnpuSpecForRequest(configs, result.Request); spec != nil {
+		placement = spec.Placement
+		if spec.TemplateName == "" {
+			requirements = specRequirements(spec)
+			log.Printf("Obtained explicit resource requirements for request %s: %v", result.Request, requirements)
+		} else {
+			templateName := spec.TemplateName
+			tpl, found := s.vnpuManager.Template(origDevice, templateName)
+			if !found {
+				return fmt.Errorf("template %s of request %s is not supported by %s", templateName, result.Request, origDevice)
 			}
+			requirements = templateRequirements(tpl)
+			log.Printf("Obtained resource requirements for request %s from template %s: %v",
+				result.Request, templateName, requirements)
 		}
 	}
-	slice, err := s.vnpuManager.AllocateSlice(origDevice, requestedAicore, requestedMemory)
+	slice, err := s.vnpuManager.AllocateSlice(origDevice, requirements, placement, claimDevices)
 	if err != nil {
 		return err
 	}
 	result.Device = slice.SliceID
-	log.Printf("Successfully allocated vNPU slice for device %s: %s (template: %s, AICORE: %d, Memory: %dGB)",
-		origDevice, slice.SliceID, templateName, requestedAicore, requestedMemory)
+	log.Printf("Successfully allocated vNPU slice for device %s: %s (template: %s, requirements: %v)",
+		origDevice, slice.SliceID, slice.TemplateName, requirements)
+	return nil
+}
+
+// createVnpu carves the vNPU backing an allocated slice out of its chip and
+// records the vdev ID assigned by dcmi. Full-card slices need no vNPU, in
+// which case nil is returned.
+func (s *DeviceState) createVnpu(sliceID string) (*PreparedVirtualDevice, error) {
+	slice, logicID, err := s.vnpuManager.GetAllocatedSlice(sliceID)
+	if err != nil {
+		return nil, err
+	}
+	if slice.TemplateName == "" {
+		return nil, nil
+	}
+	if slice.Adopted {
+		log.Printf("Using adopted vNPU %d on logic ID %d for slice %s", slice.VDevID, logicID, sliceID)
+		return &PreparedVirtualDevice{
+			LogicID: logicID,
+			VDevID:  slice.VDevID,
+			Adopted: true,
+		}, nil
+	}
+
+	out, err := s.backend.CreateVirtualDevice(logicID, npuCommon.CgoCreateVDevRes{
+		VDevID:       common.DefaultIDForCreateVNPU,
+		VfgID:        common.DefaultIDForCreateVNPU,
+		TemplateName: slice.TemplateName,
+	})
+	if err != nil {
+		if releaseErr := s.vnpuManager.ReleaseSlice(sliceID); releaseErr != nil {
+			log.Printf("Warning: failed to release vNPU slice %s: %v", sliceID, releaseErr)
+		}
+		return nil, fmt.Errorf("dcmi failed to create vNPU with template %s on logic ID %d: %v",
+			slice.TemplateName, logicID, err)
+	}
+	if err := s.vnpuManager.SetSliceVDevID(sliceID, out.VDevID); err != nil {
+		return nil, err
+	}
+	log.Printf("Created vNPU %d with template %s on logic ID %d for slice %s",
+		out.VDevID, slice.TemplateName, logicID, sliceID)
+
+	return &PreparedVirtualDevice{
+		LogicID: logicID,
+		VDevID:  out.VDevID,
+	}, nil
+}
+
+// destroyVnpu destroys a vNPU created by createVnpu. It is a no-op if the vNPU
+// no longer exists on the chip, so that unprepare can safely be retried, and
+// for adopted vNPUs, which outlive the claims they are handed to.
+func (s *DeviceState) destroyVnpu(vdev *PreparedVirtualDevice) error {
+	if vdev.Adopted {
+		return nil
+	}
+	info, err := s.backend.GetVirtualDeviceInfo(vdev.LogicID)
+	if err != nil {
+		return fmt.Errorf("failed to query virtual devices on logic ID %d: %v", vdev.LogicID, err)
+	}
+	if !slices.ContainsFunc(info.VDevInfo, func(v npuCommon.CgoVDevQueryStru) bool {
+		return v.VDevID == vdev.VDevID
+	}) {
+		log.Printf("vNPU %d is already gone from logic ID %d", vdev.VDevID, vdev.LogicID)
+		return nil
+	}
+	if err := s.backend.DestroyVirtualDevice(vdev.LogicID, vdev.VDevID); err != nil {
+		return fmt.Errorf("dcmi failed to destroy vNPU %d on logic ID %d: %v", vdev.VDevID, vdev.LogicID, err)
+	}
+	log.Printf("Destroyed vNPU %d on logic ID %d", vdev.VDevID, vdev.LogicID)
+	return nil
+}
+
+// rollbackSlices destroys the vNPUs and releases the slices allocated for a
+// claim that failed to prepare.
+func (s *DeviceState) rollbackSlices(sliceRecords map[string]*PreparedSlice, virtualDevices map[string]*PreparedVirtualDevice) {
+	for sliceID, vdev := range virtualDevices {
+		if err := s.destroyVnpu(vdev); err != nil {
+			log.Printf("Warning: failed to roll back vNPU of slice %s: %v", sliceID, err)
+		}
+		if _, ok := sliceRecords[sliceID]; !ok && !s.partitionable {
+			if err := s.vnpuManager.ReleaseSlice(sliceID); err != nil {
+				log.Printf("Warning: failed to release vNPU slice %s: %v", sliceID, err)
+			}
+		}
+	}
+	if s.partitionable {
+		return
+	}
+	for sliceID := range sliceRecords {
+		if err := s.vnpuManager.ReleaseSlice(sliceID); err != nil {
+			log.Printf("Warning: failed to release vNPU slice %s: %v", sliceID, err)
+		}
+	}
+}
+
+// restoreFromCheckpoint replays the slices of the claims prepared before a
+// restart into the VnpuManager, so that slices still held by pods are neither
+// handed out again nor missing from the published devices.
+func (s *DeviceState) restoreFromCheckpoint() error {
+	if s.vnpuManager == nil || s.partitionable {
+		return nil
+	}
+
+	checkpoint := newCheckpoint()
+	if err := s.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
+		return fmt.Errorf("unable to sync from checkpoint: %v", err)
+	}
+
+	for claimUID, devices := range checkpoint.V1.PreparedClaims {
+		for _, dev := range devices {
+			if dev.Slice == nil {
+				log.Printf("Warning: no slice recorded for device %s of claim %s, skipping restore",
+					dev.Device.DeviceName, claimUID)
+				continue
+			}
+			if err := s.vnpuManager.RestoreSlice(dev.Slice, dev.VirtualDevice); err != nil {
+				log.Printf("Warning: failed to restore slice %s of claim %s: %v", dev.Slice.SliceID, claimUID, err)
+				continue
+			}
+			log.Printf("Restored slice %s (template: %q) on %s for claim %s",
+				dev.Slice.SliceID, dev.Slice.TemplateName, dev.Slice.PhysicalDevice, claimUID)
+		}
+	}
+	s.vnpuManager.RebuildAvailableSlices()
+
+	for _, npu := range s.vnpuManager.PhysicalNpus {
+		for _, slice := range npu.AllocatedSlices {
+			s.UpdateAllocatableDevice(slice.SliceID, npu)
+		}
+	}
 	return nil
 }
 
 // unprepareDevices reclaims devices under the specified ClaimUID
 func (s *DeviceState) unprepareDevices(claimUID string, devices PreparedDevices) error {
 	log.Printf("Starting to release devices, claimUID: %s", claimUID)
-	if s.vnpuManager == nil {
+	for _, dev := range devices {
+		if dev.VirtualDevice == nil {
+			continue
+		}
+		if err := s.destroyVnpu(dev.VirtualDevice); err != nil {
+			return err
+		}
+	}
+	if s.vnpuManager == nil || s.partitionable {
 		return nil
 	}
 	for _, dev := range devices {
@@ -386,69 +712,151 @@ func (s *DeviceState) unprepareDevices(claimUID string, devices PreparedDevices)
 	return nil
 }
 
-// applyConfig applies a configuration to a set of device allocation results.
-//
-// In this example driver there is no actual configuration applied. We simply
-// define a set of environment variables to be injected into the containers
-// that include a given device. A real driver would likely need to do some sort
-// of hardware configuration as well, based on the config passed in.
-func (s *DeviceState) applyConfig(config *configapi.GpuConfig, results []*resourceapi.DeviceRequestAllocationResult) (PerDeviceCDIContainerEdits, error) {
+// applyConfig applies a configuration to a set of device allocation results
+// and returns the CDI container edits of each device: ASCEND_VISIBLE_DEVICES
+// and, for vNPUs, ASCEND_RUNTIME_OPTIONS with the /dev/vdavinciN node, or the
+// /dev/davinciN node of a full card, together with the device nodes and driver
+// mounts of the CDI profile of the chip model, the sharing mode and the extra
+// environment variables of the runtime options.
+func (s *DeviceState) applyConfig(
+	config *configapi.NpuConfig,
+	results []*resourceapi.DeviceRequestAllocationResult,
+	sliceRecords map[string]*PreparedSlice,
+	virtualDevices map[string]*PreparedVirtualDevice,
+) (PerDeviceCDIContainerEdits, error) {
 	perDeviceEdits := make(PerDeviceCDIContainerEdits)
 
 	for _, result := range results {
-		envs := buildBaseEnv(result.Device)
-		if s.vnpuManager != nil {
-			envs = s.addVnpuEnvIfSlice(envs, result.Device)
+		logicID, model, err := s.deviceChip(result.Device)
+		if err != nil {
+			return nil, err
+		}
+		phyID, err := s.backend.GetPhysicIDFromLogicID(logicID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get physical ID of logic ID %d: %v", logicID, err)
+		}
+		edits := &cdispec.ContainerEdits{Env: buildBaseEnv(phyID)}
+		fullCard := isFullCard(&PreparedDevice{Slice: sliceRecords[result.Device], VirtualDevice: virtualDevices[result.Device]})
+		if s.partitionable {
+			if vdev := virtualDevices[result.Device]; vdev != nil {
+				s.addVirtualDeviceEdits(edits, vdev.VDevID)
+			}
+		} else if s.vnpuManager != nil {
+			s.addVnpuEditsIfSlice(edits, result.Device)
 		}
-		envs = addSharingStrategyEnv(envs, config, result.Device)
-		edits := &cdispec.ContainerEdits{Env: envs}
+		if !s.simulated {
+			withDriverMounts := config.Runtime == nil || !config.Runtime.NoDriverMounts
+			if err := s.addHostEdits(edits, result.Device, phyID, model, fullCard, withDriverMounts); err != nil {
+				return nil, err
+			}
+		}
+		edits.Env = addSharingModeEnv(edits.Env, config, result.Device)
+		edits.Env = addRuntimeEnv(edits.Env, config)
 		perDeviceEdits[result.Device] = &cdiapi.ContainerEdits{ContainerEdits: edits}
 	}
 	return perDeviceEdits, nil
 }
 
-// buildBaseEnv constructs basic environment variables such as ASCEND_VISIBLE_DEVICES
-func buildBaseEnv(deviceName string) []string {
+// buildBaseEnv constructs basic environment variables such as
+// ASCEND_VISIBLE_DEVICES, which names the chip by its physical ID like its
+// /dev/davinciN node.
+func buildBaseEnv(phyID int32) []string {
 	return []string{
-		fmt.Sprintf("ASCEND_VISIBLE_DEVICES=%s", deviceName[4:5]),
+		fmt.Sprintf("ASCEND_VISIBLE_DEVICES=%d", phyID),
 	}
 }
 
-// addVnpuEnvIfSlice adds ASCEND_VNPU_SPECS if it is a slice format npu-x-y
-func (s *DeviceState) addVnpuEnvIfSlice(envs []string, deviceID string) []string {
+// addVnpuEditsIfSlice points the container at the vNPU backing a slice of
+// format npu-x-y. If a vNPU was created on the chip, its /dev/vdavinciN node is
+// injected, otherwise only ASCEND_VNPU_SPECS is set.
+func (s *DeviceState) addVnpuEditsIfSlice(edits *cdispec.ContainerEdits, deviceID string) {
 	r := regexp.MustCompile(`^npu-(\d+)-(\d+)$`)
 	if !r.MatchString(deviceID) {
-		return envs
+		return
 	}
-	vnpuSpec, err := s.vnpuManager.GetVnpuSpecsEnv(deviceID)
+	slice, _, err := s.vnpuManager.GetAllocatedSlice(deviceID)
 	if err != nil {
-		log.Printf("Warning: failed to get vNPU specs: %v", err)
-		return envs
+		log.Printf("Warning: failed to get vNPU slice: %v", err)
+		return
 	}
-	if vnpuSpec != "" {
-		envs = append(envs, fmt.Sprintf("ASCEND_VNPU_SPECS=%s", vnpuSpec))
-		log.Printf("Set vNPU specs for device %s: %s", deviceID, vnpuSpec)
+	if slice.VDevID != 0 {
+		s.addVirtualDeviceEdits(edits, slice.VDevID)
+		log.Printf("Set vNPU %d for device %s", slice.VDevID, deviceID)
+		return
 	}
-	return envs
+	if slice.TemplateName != "" {
+		edits.Env = append(edits.Env, fmt.Sprintf("ASCEND_VNPU_SPECS=%s", slice.TemplateName))
+		log.Printf("Set vNPU specs for device %s: %s", deviceID, slice.TemplateName)
+	}
+}
+
+// addVirtualDeviceEdits points the container at a vNPU and injects its
+// /dev/vdavinciN node.
+func (s *DeviceState) addVirtualDeviceEdits(edits *cdispec.ContainerEdits, vdevID uint32) {
+	edits.Env = []string{
+		fmt.Sprintf("ASCEND_VISIBLE_DEVICES=%d", vdevID),
+		"ASCEND_RUNTIME_OPTIONS=VIRTUAL",
+	}
+	if !s.simulated {
+		edits.DeviceNodes = append(edits.DeviceNodes, &cdispec.DeviceNode{
+			Path: fmt.Sprintf("%s%d", common.VirtualDevicePathPrefix, vdevID),
+		})
+	}
+}
+
+// deviceChip returns the logic ID and model of the chip backing an
+// allocatable device.
+func (s *DeviceState) deviceChip(deviceName string) (int32, string, error) {
+	device, ok := s.allocatable[deviceName]
+	if !ok {
+		return 0, "", fmt.Errorf("requested NPU is not allocatable: %v", deviceName)
+	}
+	index := device.Attributes[DriverDomain+"index"].IntValue
+	model := device.Attributes[DriverDomain+"model"].StringValue
+	if index == nil || model == nil {
+		return 0, "", fmt.Errorf("device %s has no index or model attribute", deviceName)
+	}
+	return int32(*index), *model, nil
+}
+
+// addHostEdits injects the /dev/davinciN node of the chip backing a device,
+// unless the vNPU node of a slice was injected already, together with the
+// device nodes and, unless disabled, the driver mounts of the CDI profile of
+// the chip model. The chip node is only ever injected for a full card.
+func (s *DeviceState) addHostEdits(edits *cdispec.ContainerEdits, deviceName string, phyID int32, model string,
+	fullCard bool, withDriverMounts bool) error {
+	if len(edits.DeviceNodes) == 0 {
+		if !fullCard {
+			return fmt.Errorf("device %s is carved from a chip but has no vNPU device node", deviceName)
+		}
+		edits.DeviceNodes = append(edits.DeviceNodes, &cdispec.DeviceNode{
+			Path: fmt.Sprintf("%s%d", common.DevicePathPrefix, phyID),
+		})
+	}
+	profile := s.cdi.profiles.ForModel(model)
+	if !withDriverMounts {
+		profile.Mounts = nil
+	}
+	profile.addTo(edits)
+	return nil
 }
 
-// addSharingStrategyEnv adds environment variables for the sharing strategy
-func addSharingStrategyEnv(envs []string, config *configapi.GpuConfig, deviceName string) []string {
+// addSharingModeEnv adds environment variables for the sharing mode
+func addSharingModeEnv(envs []string, config *configapi.NpuConfig, deviceName string) []string {
 	if config.Sharing == nil {
 		return envs
 	}
-	envs = append(envs, fmt.Sprintf("NPU_DEVICE_%s_SHARING_STRATEGY=%s", deviceName[4:], config.Sharing.Strategy))
-	switch {
-	case config.Sharing.IsTimeSlicing():
-		tsconfig, _ := config.Sharing.GetTimeSlicingConfig()
-		if tsconfig != nil {
-			envs = append(envs, fmt.Sprintf("NPU_DEVICE_%s_TIMESLICE_INTERVAL=%v", deviceName[4:], tsconfig.Interval))
-		}
-	case config.Sharing.IsSpacePartitioning():
-		spconfig, _ := config.Sharing.GetSpacePartitioningConfig()
-		if spconfig != nil {
-			envs = append(envs, fmt.Sprintf("NPU_DEVICE_%s_PARTITION_COUNT=%v", deviceName[4:], spconfig.PartitionCount))
-		}
+	return append(envs, fmt.Sprintf("NPU_DEVICE_%s_SHARING_MODE=%s", deviceName[4:], config.Sharing.Mode))
+}
+
+// addRuntimeEnv adds the extra environment variables of the runtime options,
+// sorted by name to keep the CDI specs stable.
+func addRuntimeEnv(envs []string, config *configapi.NpuConfig) []string {
+	if config.Runtime == nil {
+		return envs
+	}
+	for _, name := range slices.Sorted(maps.Keys(config.Runtime.Env)) {
+		envs = append(envs, fmt.Sprintf("%s=%s", name, config.Runtime.Env[name]))
 	}
 	return envs
 }
@@ -484,6 +892,7 @@ func GetOpaqueDeviceConfigs(
 		}
 	}
 	candidateConfigs = append(candidateConfigs, classConfigs...)
+	candidateConfigs = append(candidateConfigs, claimConfigs...)
 
 	// Decode all configs that are relevant for the driver.
 	var resultConfigs []*OpaqueDeviceConfig
@@ -518,19 +927,34 @@ func GetOpaqueDeviceConfigs(
 	return resultConfigs, nil
 }
 
-// AllocateSlice allocates a vNPU slice based on the requested computational resources
-func (m *VnpuManager) AllocateSlice(deviceName string, requestedAicore, requestedMemory int) (*VnpuSlice, error) {
+// AllocateSlice allocates a vNPU slice meeting the requirements, or the full
+// card if there are none. The placement policy may carve the vNPU from another
+// of the claim devices than the named one, an empty policy applies the default
+// of the node.
+func (m *VnpuManager) AllocateSlice(
+	deviceName string,
+	requirements VnpuRequirements,
+	placement configapi.VnpuPlacement,
+	claimDevices []string,
+) (*VnpuSlice, error) {
 	m.Lock()
 	defer m.Unlock()
-	log.Printf("Attempting to allocate vNPU slice, device: %s, requirements: AICORE=%d, Memory=%dGB", deviceName, requestedAicore, requestedMemory)
-	physicalNpu, ok := m.PhysicalNpus[deviceName]
+	log.Printf("Attempting to allocate vNPU slice, device: %s, requirements: %v", deviceName, requirements)
+	physicalNpu, ok := m.findPhysicalNpu(deviceName)
 	if !ok {
 		return nil, fmt.Errorf("physical NPU not found: %s", deviceName)
 	}
-	if requestedAicore == 0 && requestedMemory == 0 {
+	if slice := findAvailableSlice(physicalNpu, deviceName); slice != nil && slice.Adopted {
+		return m.allocateAdoptedSlice(physicalNpu, slice, requirements)
+	}
+	if requirements.isZero() {
+		if deviceName != physicalNpu.DeviceName {
+			return nil, fmt.Errorf("slice %s is what is left of a carved chip, a vNPU template or resources are required", deviceName)
+		}
 		return m.allocateFullCard(physicalNpu, deviceName)
 	}
-	return m.allocateSliceByTemplate(physicalNpu, deviceName, requestedAicore, requestedMemory)
+	physicalNpu, deviceName = m.placeVnpu(physicalNpu, deviceName, requirements, placement, claimDevices)
+	return m.allocateSliceByTemplate(physicalNpu, deviceName, requirements)
 }
 
 // allocateFullCard allocates the entire card
@@ -547,26 +971,32 @@ func (m *VnpuManager) allocateFullCard(npu *PhysicalNpuState, deviceName string)
 	return nil, fmt.Errorf("the slice %s has already been allocated", deviceName)
 }
 
+// allocateAdoptedSlice hands out an adopted vNPU as-is, provided its template
+// meets the requested resources.
+func (m *VnpuManager) allocateAdoptedSlice(
+	npu *PhysicalNpuState,
+	slice *VnpuSlice,
+	requirements VnpuRequirements,
+) (*VnpuSlice, error) {
+	if !requirements.isZero() {
+		tpl, ok := npu.Templates[slice.TemplateName]
+		if !ok || !requirements.metBy(tpl) {
+			return nil, fmt.Errorf("adopted vNPU slice %s (template: %s) does not meet the requirements: %v",
+				slice.SliceID, slice.TemplateName, requirements)
+		}
+	}
+	return m.allocateFullCard(npu, slice.SliceID)
+}
+
 // allocateSliceByTemplate allocates a vNPU slice based on template attributes
 func (m *VnpuManager) allocateSliceByTemplate(
 	npu *PhysicalNpuState,
 	deviceName string,
-	requestedAicore, requestedMemory int,
+	requirements VnpuRequirements,
 ) (*VnpuSlice, error) {
-	var bestTemplate *VnpuTemplate
-	bestDiff := math.MaxInt32
-	for _, template := range npu.SupportTemplates {
-		if template.Attributes.AICORE >= requestedAicore &&
-			template.Attributes.Memory >= requestedMemory {
-			diff := (template.Attributes.AICORE - requestedAicore) + (template.Attributes.Memory - requestedMemory)
-			if diff < bestDiff {
-				bestDiff = diff
-				bestTemplate = template
-			}
-		}
-	}
+	bestTemplate := bestTemplate(npu, requirements)
 	if bestTemplate == nil {
-		return nil, fmt.Errorf("no partition scheme found that meets the requirements: AICORE>=%d, Memory>=%dGB", requestedAicore, requestedMemory)
+		return nil, fmt.Errorf("no partition scheme found that meets the requirements: %v", requirements)
 	}
 
 	var currentSlice *VnpuSlice
@@ -585,201 +1015,20 @@ func (m *VnpuManager) allocateSliceByTemplate(
 
 	npu.AvailableSlices = append(npu.AvailableSlices[:sliceIndex], npu.AvailableSlices[sliceIndex+1:]...)
 
+	// A vNPU carved from the full card leaves the rest of the card to others.
 	currentSlice.TemplateName = bestTemplate.Name
+	currentSlice.Type = "vNPU"
 	currentSlice.Allocated = true
 
 	npu.AllocatedSlices = append(npu.AllocatedSlices, currentSlice)
 
-	newSliceID := fmt.Sprintf("npu-%d-%d", npu.LogicID, npu.NextSliceIndex)
-	newSlice := &VnpuSlice{
-		SliceID:      newSliceID,
-		TemplateName: "",
-		Allocated:    false,
-		Type:         "vNPU",
-	}
-	npu.AvailableSlices = append(npu.AvailableSlices, newSlice)
-
-	if m.deviceUpdateCallback != nil {
-		m.deviceUpdateCallback(newSliceID, npu)
-	}
-
-	npu.NextSliceIndex++
-
 	log.Printf("Successfully allocated vNPU slice: %s with template %s (AICORE: %d, Memory: %dGB)",
 		currentSlice.SliceID, bestTemplate.Name, bestTemplate.Attributes.AICORE, bestTemplate.Attributes.Memory)
-	log.Printf("Created new available slice: %s representing remaining resources", newSliceID)
+	m.resetRemainder(npu)
 
 	return currentSlice, nil
 }
 
-// CreatePredefinedDeviceClasses idempotently creates/updates DeviceClasses
-func CreatePredefinedDeviceClasses(vnpuManager *VnpuManager) error {
-	log.Printf("Starting to create predefined DeviceClasses...")
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return fmt.Errorf("failed to get in-cluster config: %v", err)
-	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return fmt.Errorf("failed to create Kubernetes client: %v", err)
-	}
-
-	uniqueModels := make(map[string]bool)
-	uniqueTemplates := make(map[string]*VnpuTemplate)
-
-	for _, pNpu := range vnpuManager.PhysicalNpus {
-		modelName := pNpu.ModelName
-		if modelName == "" {
-			modelName = "unknown"
-		}
-		uniqueModels[modelName] = true
-		for _, tpl := range pNpu.SupportTemplates {
-			key := fmt.Sprintf("aicore-%d-mem-%d", tpl.Attributes.AICORE, tpl.Attributes.Memory)
-			if _, ok := uniqueTemplates[key]; !ok {
-				uniqueTemplates[key] = tpl
-			}
-		}
-	}
-
-	// Create a full-card DeviceClass for each unique model
-	for modelName := range uniqueModels {
-		if err := createFullCardDeviceClass(clientset, modelName); err != nil {
-			log.Printf("Failed to create/update full-card DeviceClass: %v", err)
-		}
-	}
-
-	// Create the corresponding DeviceClass for each unique template and each unique model
-	for _, tpl := range uniqueTemplates {
-		for modelName := range uniqueModels {
-			if err := createMemoryDeviceClass(clientset, modelName, tpl); err != nil {
-				log.Printf("Failed to create/update Memory DeviceClass: %v", err)
-			}
-			if err := createAicoreDeviceClass(clientset, modelName, tpl); err != nil {
-				log.Printf("Failed to create/update AICORE DeviceClass: %v", err)
-			}
-		}
-	}
-
-	log.Printf("Predefined DeviceClass creation completed")
-	return nil
-}
-
-// createFullCardDeviceClass creates or updates a "full-card" DeviceClass
-func createFullCardDeviceClass(clientset *kubernetes.Clientset, modelName string) error {
-	safeModel := toSafeModelName(modelName)
-	dcName := fmt.Sprintf("npu-%s.example.com", safeModel)
-	expr := fmt.Sprintf(`device.attributes["%s"].model == "%s" && device.attributes["%s"].type == "NPU"`,
-		DriverDomainName, modelName, DriverDomainName)
-	return upsertDeviceClass(clientset, dcName, expr, "")
-}
-
-// createMemoryDeviceClass creates or updates a DeviceClass based on memory
-func createMemoryDeviceClass(clientset *kubernetes.Clientset, modelName string, tpl *VnpuTemplate) error {
-	safeModel := toSafeModelName(modelName)
-	dcName := fmt.Sprintf("npu-%s-mem%d.example.com", safeModel, tpl.Attributes.Memory)
-	expr := fmt.Sprintf(`device.attributes["%s"].memory >= %d && device.attributes["%s"].model == "%s"`,
-		DriverDomainName, tpl.Attributes.Memory, DriverDomainName, modelName)
-	return upsertDeviceClass(clientset, dcName, expr, tpl.Name)
-}
-
-// createAicoreDeviceClass creates or updates a DeviceClass based on AICORE
-func createAicoreDeviceClass(clientset *kubernetes.Clientset, modelName string, tpl *VnpuTemplate) error {
-	safeModel := toSafeModelName(modelName)
-	dcName := fmt.Sprintf("npu-%s-aicore%d.example.com", safeModel, tpl.Attributes.AICORE)
-	expr := fmt.Sprintf(`device.attributes["%s"].aicore >= %d && device.attributes["%s"].model == "%s"`,
-		DriverDomainName, tpl.Attributes.AICORE, DriverDomainName, modelName)
-	return upsertDeviceClass(clientset, dcName, expr, tpl.Name)
-}
-
-// upsertDeviceClass idempotently creates/updates a DeviceClass
-func upsertDeviceClass(clientset *kubernetes.Clientset, name, expr, tpl string) error {
-	want, err := buildDeviceClass(name, expr, tpl)
-	if err != nil {
-		return err
-	}
-
-	got, getErr := clientset.ResourceV1beta1().DeviceClasses().Get(
-		context.TODO(), name, metav1.GetOptions{},
-	)
-	if errors.IsNotFound(getErr) {
-		_, createErr := clientset.ResourceV1beta1().DeviceClasses().Create(
-			context.TODO(), want, metav1.CreateOptions{},
-		)
-		if createErr != nil {
-			return fmt.Errorf("failed to create DeviceClass: %v", createErr)
-		}
-		log.Printf("Successfully created DeviceClass: %s", name)
-		return nil
-	}
-	if getErr != nil {
-		return fmt.Errorf("failed to get DeviceClass: %v", getErr)
-	}
-
-	if !deviceClassEquals(got, want) {
-		want.ObjectMeta.ResourceVersion = got.ObjectMeta.ResourceVersion
-		_, updateErr := clientset.ResourceV1beta1().DeviceClasses().Update(
-			context.TODO(), want, metav1.UpdateOptions{},
-		)
-		if updateErr != nil {
-			return fmt.Errorf("failed to update DeviceClass: %v", updateErr)
-		}
-		log.Printf("Successfully updated DeviceClass: %s", name)
-	}
-
-	return nil
-}
-
-// buildDeviceClass generates the target DeviceClass
-func buildDeviceClass(name, celExpression, tplName string) (*resourceapi.DeviceClass, error) {
-	paramObj := map[string]interface{}{
-		"apiVersion": "gpu.resource.example.com/v1alpha1",
-		"kind":       "GpuConfig",
-		"vnpuSpec": map[string]interface{}{
-			"templateName": tplName,
-		},
-	}
-	raw, err := json.Marshal(paramObj)
-	if err != nil {
-		return nil, err
-	}
-	return &resourceapi.DeviceClass{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
-		},
-		Spec: resourceapi.DeviceClassSpec{
-			Selectors: []resourceapi.DeviceSelector{{
-				CEL: &resourceapi.CELDeviceSelector{Expression: celExpression},
-			}},
-			Config: []resourceapi.DeviceClassConfiguration{
-				{
-					DeviceConfiguration: resourceapi.DeviceConfiguration{
-						Opaque: &resourceapi.OpaqueDeviceConfiguration{
-							Driver: DriverName,
-							Parameters: runtime.RawExtension{
-								Raw: raw,
-							},
-						},
-					},
-				},
-			},
-		},
-	}, nil
-}
-
-// deviceClassEquals performs a simple comparison of the Spec fields
-func deviceClassEquals(a, b *resourceapi.DeviceClass) bool {
-	aSpec, _ := json.Marshal(a.Spec)
-	bSpec, _ := json.Marshal(b.Spec)
-	return string(aSpec) == string(bSpec)
-}
-
-// toSafeModelName removes extra characters from model and converts to lowercase
-func toSafeModelName(model string) string {
-	model = strings.ReplaceAll(model, " ", "-")
-	model = strings.ReplaceAll(model, "/", "-")
-	return strings.ToLower(model)
-}
-
 func (s *DeviceState) UpdateAllocatableDevice(deviceName string, physicalNpu *PhysicalNpuState) bool {
 	_, exists := s.allocatable[deviceName]
 	if exists {
@@ -787,9 +1036,13 @@ func (s *DeviceState) UpdateAllocatableDevice(deviceName string, physicalNpu *Ph
 	}
 
 	var sliceType string = "NPU"
-	for _, slice := range physicalNpu.AvailableSlices {
+	var adopted *VnpuSlice
+	for _, slice := range slices.Concat(physicalNpu.AvailableSlices, physicalNpu.AllocatedSlices) {
 		if slice.SliceID == deviceName {
 			sliceType = slice.Type
+			if slice.Adopted {
+				adopted = slice
+			}
 			break
 		}
 	}
@@ -802,30 +1055,75 @@ func (s *DeviceState) UpdateAllocatableDevice(deviceName string, physicalNpu *Ph
 		DriverDomain + "model": {StringValue: ptr.To(physicalNpu.ModelName)},
 		DriverDomain + "type":  {StringValue: ptr.To(sliceType)},
 	}
+	// vNPUs carved from a chip share its topology.
+	physicalNpu.Topology.addTo(devAttributes)
 
 	if s.vnpuManager != nil {
-		maxAicore, maxMemory := 0, 0
-		for _, tpl := range physicalNpu.SupportTemplates {
-			if tpl.Attributes.AICORE > maxAicore {
-				maxAicore = tpl.Attributes.AICORE
-			}
-			if tpl.Attributes.Memory > maxMemory {
-				maxMemory = tpl.Attributes.Memory
+		capacity := remainingCapacity(physicalNpu)
+
+		// An adopted vNPU has a fixed size given by the template it was created with.
+		if adopted != nil {
+			if tpl, ok := physicalNpu.Templates[adopted.TemplateName]; ok {
+				capacity = templateCapacity(tpl)
 			}
+			devAttributes[DriverDomain+"template"] = resourceapi.DeviceAttribute{StringValue: ptr.To(adopted.TemplateName)}
 		}
 
-		devAttributes[DriverDomain+"aicore"] = resourceapi.DeviceAttribute{IntValue: ptr.To(int64(maxAicore))}
-		devAttributes[DriverDomain+"memory"] = resourceapi.DeviceAttribute{IntValue: ptr.To(int64(maxMemory))}
+		setCapacityAttributes(devAttributes, capacity, physicalNpu.Capacity)
+		addTemplateAttributes(devAttributes, physicalNpu.Templates)
 	}
 
 	device := resourceapi.Device{
-		Name: deviceName,
-		Basic: &resourceapi.BasicDevice{
-			Attributes: devAttributes,
-		},
+		Name:       deviceName,
+		Attributes: devAttributes,
 	}
 
 	s.allocatable[deviceName] = device
 	log.Printf("Added new allocatable NPU device: %s, Type: %s, Model: %s", deviceName, sliceType, physicalNpu.ModelName)
 	return true
 }
+
+// UpdateTemplates switches to new vNPU templates, e.g. after the template
+// files changed, and updates the allocatable devices accordingly. vNPUs that
+// are carved already keep their template.
+func (s *DeviceState) UpdateTemplates(catalog TemplateCatalog) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.vnpuManager.SetCatalog(catalog)
+	if s.partitionable {
+		s.updatePartitionTemplates(catalog)
+		return
+	}
+
+	s.vnpuManager.Lock()
+	defer s.vnpuManager.Unlock()
+	chips := make(map[int64]*PhysicalNpuState)
+	for _, physicalNpu := range s.vnpuManager.PhysicalNpus {
+		chips[int64(physicalNpu.LogicID)] = physicalNpu
+	}
+	for _, device := range s.allocatable {
+		// Chips that are not managed, e.g. unhealthy ones, are published
+		// with the templates of their model.
+		var templates map[string]*VnpuTemplate
+		if model := device.Attributes[DriverDomain+"model"].StringValue; model != nil {
+			templates = catalog.ForModel(*model)
+		}
+		if index := device.Attributes[DriverDomain+"index"].IntValue; index != nil && chips[*index] != nil {
+			templates = chips[*index].Templates
+		}
+		setTemplateAttributes(device.Attributes, templates)
+	}
+
+	// The size of the remainder of a split chip depends on the templates of
+	// the vNPUs carved from it.
+	for _, physicalNpu := range s.vnpuManager.PhysicalNpus {
+		for _, slice := range physicalNpu.AvailableSlices {
+			device, ok := s.allocatable[slice.SliceID]
+			if !ok || slice.Type != "vNPU" || slice.Adopted {
+				continue
+			}
+			setCapacityAttributes(device.Attributes, remainingCapacity(physicalNpu), physicalNpu.Capacity)
+		}
+	}
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/kubelet/checkpointmanager"
+
+	configapi "Ascend-dra-driver/api/example.com/resource/gpu/v1alpha1"
+	npuconfigapi "Ascend-dra-driver/api/example.com/resource/gpu/v1alpha2"
+)
+
+// newTestDeviceState builds a DeviceState on top of a fake backend, with the
+// CDI specs and checkpoint kept in temporary directories.
+func newTestDeviceState(t *testing.T, inventory string) (*DeviceState, *FakeBackend) {
+	backend := newTestFakeBackend(t, inventory)
+	return newTestDeviceStateFor(t, backend, inventory, t.TempDir(), ExistingVnpuPolicyAdopt), backend
+}
+
+// newTestDeviceStateFor builds a DeviceState on top of an existing backend and
+// checkpoint directory, as the plugin would after a restart. The options are
+// applied before the devices are published.
+func newTestDeviceStateFor(t *testing.T, backend *FakeBackend, inventory string, checkpointDir string,
+	policy ExistingVnpuPolicy, opts ...func(*DeviceState)) *DeviceState {
+	parsed, err := LoadInventory(writeTestInventory(t, inventory))
+	require.NoError(t, err)
+
+	allocatable, vnpuManager, existingVnpus, err := enumerateAllPossibleDevices(backend, parsed.TemplateCatalog())
+	require.NoError(t, err)
+
+	cdi, err := NewCDIHandler(&Config{flags: &Flags{cdiRoot: t.TempDir()}})
+	require.NoError(t, err)
+
+	checkpointManager, err := checkpointmanager.NewCheckpointManager(checkpointDir)
+	require.NoError(t, err)
+	checkpoints, err := checkpointManager.ListCheckpoints()
+	require.NoError(t, err)
+
+	state := &DeviceState{
+		backend:           backend,
+		cdi:               cdi,
+		rankTableDir:      t.TempDir(),
+		allocatable:       allocatable,
+		checkpointManager: checkpointManager,
+		vnpuManager:       vnpuManager,
+	}
+	for _, opt := range opts {
+		opt(state)
+	}
+	if state.partitionable {
+		require.NoError(t, state.initPartitions(vnpuManager.Catalog))
+	} else {
+		vnpuManager.SetDeviceUpdateCallback(func(deviceName string, physicalNpu *PhysicalNpuState) {
+			if added := state.UpdateAllocatableDevice(deviceName, physicalNpu); added {
+				log.Printf("Added new device %s to allocatable devices", deviceName)
+			}
+		})
+	}
+
+	if len(checkpoints) == 0 {
+		require.NoError(t, checkpointManager.CreateCheckpoint(DriverPluginCheckpointFile, newCheckpoint()))
+	} else {
+		require.NoError(t, state.restoreFromCheckpoint())
+	}
+	state.reconcileExistingVnpus(existingVnpus, policy)
+	return state
+}
+
+// newTestClaim builds an allocated claim for the given devices. If templateName
+// is set, a v1alpha1 class config requesting that vNPU template is attached.
+func newTestClaim(t *testing.T, uid string, templateName string, devices ...string) *resourceapi.ResourceClaim {
+	if templateName == "" {
+		return newTestClaimWithConfig(t, uid, nil, devices...)
+	}
+	config := configapi.DefaultGpuConfig()
+	config.VnpuSpec = &configapi.VnpuSpec{TemplateName: templateName}
+	return newTestClaimWithConfig(t, uid, config, devices...)
+}
+
+// newTestClaimWithConfig builds an allocated claim for the given devices with
+// an optional class config attached.
+func newTestClaimWithConfig(t *testing.T, uid string, config runtime.Object, devices ...string) *resourceapi.ResourceClaim {
+	claim := &resourceapi.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "claim-" + uid,
+			Namespace: "default",
+			UID:       types.UID(uid),
+		},
+		Status: resourceapi.ResourceClaimStatus{
+			Allocation: &resourceapi.AllocationResult{},
+		},
+	}
+	for _, device := range devices {
+		claim.Status.Allocation.Devices.Results = append(claim.Status.Allocation.Devices.Results,
+			resourceapi.DeviceRequestAllocationResult{
+				Request: "npu",
+				Driver:  DriverName,
+				Pool:    "node",
+				Device:  device,
+			})
+	}
+	if config != nil {
+		raw, err := json.Marshal(config)
+		require.NoError(t, err)
+		claim.Status.Allocation.Devices.Config = append(claim.Status.Allocation.Devices.Config,
+			resourceapi.DeviceAllocationConfiguration{
+				Source: resourceapi.AllocationConfigSourceClass,
+				DeviceConfiguration: resourceapi.DeviceConfiguration{
+					Opaque: &resourceapi.OpaqueDeviceConfiguration{
+						Driver:     DriverName,
+						Parameters: runtime.RawExtension{Raw: raw},
+					},
+				},
+			})
+	}
+	return claim
+}
+
+func TestPrepareCreatesAndDestroysVnpu(t *testing.T) {
+	state, backend := newTestDeviceState(t, testInventory)
+
+	devices, err := state.Prepare(newTestClaim(t, "uid-1", "vir02", "npu-0-0"))
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "npu-0-0", devices[0].DeviceName)
+
+	info, err := backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	require.Len(t, info.VDevInfo, 1)
+	assert.Equal(t, "vir02", info.VDevInfo[0].QueryInfo.Name)
+	vdevID := info.VDevInfo[0].VDevID
+
+	checkpoint := newCheckpoint()
+	require.NoError(t, state.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint))
+	prepared := checkpoint.V1.PreparedClaims["uid-1"]
+	require.Len(t, prepared, 1)
+	assert.Equal(t, &PreparedVirtualDevice{LogicID: 0, VDevID: vdevID}, prepared[0].VirtualDevice)
+	assert.Contains(t, prepared[0].ContainerEdits.Env, "ASCEND_RUNTIME_OPTIONS=VIRTUAL")
+	var deviceNodes []string
+	for _, node := range prepared[0].ContainerEdits.DeviceNodes {
+		deviceNodes = append(deviceNodes, node.Path)
+	}
+	assert.Equal(t, []string{
+		fmt.Sprintf("/dev/vdavinci%d", vdevID),
+		"/dev/davinci_manager",
+		"/dev/devmm_svm",
+		"/dev/hisi_hdc",
+	}, deviceNodes)
+
+	require.NoError(t, state.Unprepare("uid-1"))
+	info, err = backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	assert.Empty(t, info.VDevInfo)
+
+	// Unprepare must be idempotent.
+	require.NoError(t, state.Unprepare("uid-1"))
+}
+
+func TestPrepareFullCardCreatesNoVnpu(t *testing.T) {
+	state, backend := newTestDeviceState(t, testInventory)
+
+	_, err := state.Prepare(newTestClaim(t, "uid-1", "", "npu-0-0"))
+	require.NoError(t, err)
+
+	info, err := backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	assert.Empty(t, info.VDevInfo)
+	require.NoError(t, state.Unprepare("uid-1"))
+}
+
+func TestPrepareFullCardVisibleDevice(t *testing.T) {
+	state, _ := newTestDeviceState(t, `
+devices:
+- {logicID: 12, phyID: 14, cardID: 7, chipName: 910_93, aicore: 24, memoryGiB: 64}
+`)
+
+	_, err := state.Prepare(newTestClaim(t, "uid-1", "", "npu-12-0"))
+	require.NoError(t, err)
+
+	// The container sees the chip by its physical ID, like its device node.
+	checkpoint := newCheckpoint()
+	require.NoError(t, state.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint))
+	prepared := checkpoint.V1.PreparedClaims["uid-1"]
+	require.Len(t, prepared, 1)
+	edits := prepared[0].ContainerEdits
+	assert.Contains(t, edits.Env, "ASCEND_VISIBLE_DEVICES=14")
+	require.NotEmpty(t, edits.DeviceNodes)
+	assert.Equal(t, "/dev/davinci14", edits.DeviceNodes[0].Path)
+}
+
+func TestPrepareOnCarvedChipFails(t *testing.T) {
+	testCases := map[string]struct {
+		vnpu        *npuconfigapi.VnpuSpec
+		expectedErr string
+	}{
+		"requirements the remainder cannot meet": {
+			vnpu: &npuconfigapi.VnpuSpec{AICore: 7},
+			expectedErr: "prepare failed: failed to allocate vNPU slice for npu-0-1: " +
+				"no partition scheme found that meets the requirements: AICORE>=7, Memory>=0GB",
+		},
+		"unknown template": {
+			vnpu: &npuconfigapi.VnpuSpec{TemplateName: "vir16"},
+			expectedErr: "prepare failed: failed to allocate vNPU slice for npu-0-1: " +
+				"template vir16 of request npu is not supported by npu-0-1",
+		},
+		"no vNPU spec": {
+			expectedErr: "prepare failed: failed to allocate vNPU slice for npu-0-1: " +
+				"slice npu-0-1 is what is left of a carved chip, a vNPU template or resources are required",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			state, backend := newTestDeviceState(t, testInventory)
+			_, err := state.Prepare(newTestClaim(t, "uid-1", "vir04", "npu-0-0"))
+			require.NoError(t, err)
+
+			// The remainder of the chip is never handed out as the whole chip.
+			claim := newTestClaim(t, "uid-2", "", "npu-0-1")
+			if tc.vnpu != nil {
+				config := npuconfigapi.DefaultNpuConfig()
+				config.Vnpu = tc.vnpu
+				claim = newTestClaimWithConfig(t, "uid-2", config, "npu-0-1")
+			}
+			_, err = state.Prepare(claim)
+			require.EqualError(t, err, tc.expectedErr)
+			assert.Equal(t, PrepareFailureVnpu, prepareFailureReason(err))
+
+			info, err := backend.GetVirtualDeviceInfo(0)
+			require.NoError(t, err)
+			assert.Len(t, info.VDevInfo, 1)
+			npu := state.vnpuManager.PhysicalNpus["npu-0-0"]
+			assert.NotNil(t, findAvailableSlice(npu, "npu-0-1"))
+		})
+	}
+}
+
+func TestUnprepareVnpuCarvedFromFullCard(t *testing.T) {
+	state, backend := newTestDeviceState(t, testInventory)
+	_, err := state.Prepare(newTestClaim(t, "uid-1", "vir02", "npu-0-0"))
+	require.NoError(t, err)
+	_, err = state.Prepare(newTestClaim(t, "uid-2", "vir02", "npu-0-1"))
+	require.NoError(t, err)
+
+	// The chip is not handed out as a whole while the second vNPU lives on it.
+	require.NoError(t, state.Unprepare("uid-1"))
+	info, err := backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	assert.Len(t, info.VDevInfo, 1)
+	npu := state.vnpuManager.PhysicalNpus["npu-0-0"]
+	assert.Nil(t, findAvailableSlice(npu, "npu-0-0"))
+	require.Len(t, npu.AllocatedSlices, 1)
+	assert.Equal(t, "npu-0-1", npu.AllocatedSlices[0].SliceID)
+	_, err = state.Prepare(newTestClaim(t, "uid-3", "", "npu-0-0"))
+	require.Error(t, err)
+	assert.Equal(t, PrepareFailureVnpu, prepareFailureReason(err))
+
+	require.NoError(t, state.Unprepare("uid-2"))
+	info, err = backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	assert.Empty(t, info.VDevInfo)
+	assert.NotNil(t, findAvailableSlice(npu, "npu-0-0"))
+}
+
+func TestPrepareRollsBackOnCDIFailure(t *testing.T) {
+	cdiRoot := filepath.Join(t.TempDir(), "cdi")
+	state, backend := newTestDeviceState(t, testInventory)
+	cdi, err := NewCDIHandler(&Config{flags: &Flags{cdiRoot: cdiRoot}})
+	require.NoError(t, err)
+	state.cdi = cdi
+	// The CDI spec cannot be written where a file is in the way.
+	require.NoError(t, os.WriteFile(cdiRoot, nil, 0600))
+
+	_, err = state.Prepare(newTestClaim(t, "uid-1", "vir02", "npu-0-0"))
+	require.Error(t, err)
+	assert.Equal(t, PrepareFailureCDI, prepareFailureReason(err))
+
+	// The vNPU and the slice are released again.
+	info, err := backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	assert.Empty(t, info.VDevInfo)
+	npu := state.vnpuManager.PhysicalNpus["npu-0-0"]
+	assert.Empty(t, npu.AllocatedSlices)
+	assert.True(t, state.vnpuManager.wholeCardIsAvailable(npu))
+}
+
+func TestPrepareWithNpuConfig(t *testing.T) {
+	state, backend := newTestDeviceState(t, testInventory)
+	config := npuconfigapi.DefaultNpuConfig()
+	config.Vnpu = &npuconfigapi.VnpuSpec{AICore: 3}
+	config.Sharing.Mode = npuconfigapi.TimeSharingMode
+	config.Runtime = &npuconfigapi.RuntimeOptions{
+		NoDriverMounts: true,
+		Env:            map[string]string{"ASCEND_GLOBAL_LOG_LEVEL": "3"},
+	}
+
+	_, err := state.Prepare(newTestClaimWithConfig(t, "uid-1", config, "npu-0-0"))
+	require.NoError(t, err)
+
+	// The smallest template with at least 3 AICores is used.
+	info, err := backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	require.Len(t, info.VDevInfo, 1)
+	assert.Equal(t, "vir04", info.VDevInfo[0].QueryInfo.Name)
+
+	checkpoint := newCheckpoint()
+	require.NoError(t, state.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint))
+	prepared := checkpoint.V1.PreparedClaims["uid-1"]
+	require.Len(t, prepared, 1)
+	edits := prepared[0].ContainerEdits
+	assert.Contains(t, edits.Env, "ASCEND_GLOBAL_LOG_LEVEL=3")
+	assert.Contains(t, edits.Env, "NPU_DEVICE_0-0_SHARING_MODE=TimeSharing")
+	assert.NotEmpty(t, edits.DeviceNodes)
+	assert.Empty(t, edits.Mounts)
+}
+
+// newTestOpaqueConfig wraps a config as an opaque config of the driver from
+// the given source, targeting the given requests.
+func newTestOpaqueConfig(t *testing.T, source resourceapi.AllocationConfigSource, config runtime.Object,
+	requests ...string) resourceapi.DeviceAllocationConfiguration {
+	raw, err := json.Marshal(config)
+	require.NoError(t, err)
+	return resourceapi.DeviceAllocationConfiguration{
+		Source:   source,
+		Requests: requests,
+		DeviceConfiguration: resourceapi.DeviceConfiguration{
+			Opaque: &resourceapi.OpaqueDeviceConfiguration{
+				Driver:     DriverName,
+				Parameters: runtime.RawExtension{Raw: raw},
+			},
+		},
+	}
+}
+
+func newTestVnpuConfig(templateName string, env map[string]string) *npuconfigapi.NpuConfig {
+	config := npuconfigapi.DefaultNpuConfig()
+	if templateName != "" {
+		config.Vnpu = &npuconfigapi.VnpuSpec{TemplateName: templateName}
+	}
+	if env != nil {
+		config.Runtime = &npuconfigapi.RuntimeOptions{Env: env}
+	}
+	return config
+}
+
+func TestGetOpaqueDeviceConfigs(t *testing.T) {
+	possibleConfigs := []resourceapi.DeviceAllocationConfiguration{
+		newTestOpaqueConfig(t, resourceapi.AllocationConfigSourceClaim, newTestVnpuConfig("vir01", nil)),
+		newTestOpaqueConfig(t, resourceapi.AllocationConfigSourceClass, newTestVnpuConfig("vir02", nil)),
+		newTestOpaqueConfig(t, resourceapi.AllocationConfigSourceClaim, newTestVnpuConfig("vir04", nil), "large"),
+		newTestOpaqueConfig(t, resourceapi.AllocationConfigSourceClass, newTestVnpuConfig("vir08", nil)),
+	}
+	other := newTestOpaqueConfig(t, resourceapi.AllocationConfigSourceClaim, newTestVnpuConfig("vir16", nil))
+	other.Opaque.Driver = "gpu.example.com"
+	possibleConfigs = append(possibleConfigs, other)
+
+	configs, err := GetOpaqueDeviceConfigs(npuconfigapi.Decoder, DriverName, possibleConfigs)
+	require.NoError(t, err)
+
+	// Class configs come first, claim configs last, each in their order.
+	var templates []string
+	for _, c := range configs {
+		templates = append(templates, c.Config.(*npuconfigapi.NpuConfig).Vnpu.TemplateName)
+	}
+	assert.Equal(t, []string{"vir02", "vir08", "vir01", "vir04"}, templates)
+	assert.Equal(t, []string{"large"}, configs[3].Requests)
+}
+
+func TestVnpuSpecForRequest(t *testing.T) {
+	configs := []*OpaqueDeviceConfig{
+		{Config: newTestVnpuConfig("vir02", nil)},
+		{Requests: []string{"small"}, Config: newTestVnpuConfig("vir01", nil)},
+		{Requests: []string{"large"}, Config: newTestVnpuConfig("", map[string]string{"A": "1"})},
+		{Requests: []string{"any/large"}, Config: newTestVnpuConfig("vir04", nil)},
+	}
+	testCases := map[string]struct {
+		request  string
+		expected string
+	}{
+		"request with its own spec":                {request: "small", expected: "vir01"},
+		"config without vNPU keeps the class spec": {request: "large", expected: "vir02"},
+		"config of the parent request":             {request: "small/fast", expected: "vir01"},
+		"config of the subrequest":                 {request: "any/large", expected: "vir04"},
+		"untargeted request":                       {request: "other", expected: "vir02"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			spec := vnpuSpecForRequest(configs, tc.request)
+			require.NotNil(t, spec)
+			assert.Equal(t, tc.expected, spec.TemplateName)
+		})
+	}
+	assert.Nil(t, vnpuSpecForRequest(configs[2:3], "large"))
+}
+
+func TestPrepareMultiRequestClaim(t *testing.T) {
+	state, backend := newTestDeviceState(t, testInventory)
+	claim := newTestClaimWithConfig(t, "uid-1", nil)
+	claim.Status.Allocation.Devices.Results = []resourceapi.DeviceRequestAllocationResult{
+		{Request: "small", Driver: DriverName, Pool: "node", Device: "npu-0-0"},
+		{Request: "large", Driver: DriverName, Pool: "node", Device: "npu-1-1"},
+	}
+	claim.Status.Allocation.Devices.Config = []resourceapi.DeviceAllocationConfiguration{
+		newTestOpaqueConfig(t, resourceapi.AllocationConfigSourceClaim, newTestVnpuConfig("vir01", nil), "small"),
+		newTestOpaqueConfig(t, resourceapi.AllocationConfigSourceClaim,
+			newTestVnpuConfig("", map[string]string{"ASCEND_GLOBAL_LOG_LEVEL": "1"}), "large"),
+		newTestOpaqueConfig(t, resourceapi.AllocationConfigSourceClass, newTestVnpuConfig("vir04", nil)),
+	}
+
+	_, err := state.Prepare(claim)
+	require.NoError(t, err)
+
+	// The claim config of the small request overrides the class config, the
+	// large one keeps the vNPU of the class config.
+	info, err := backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	require.Len(t, info.VDevInfo, 1)
+	assert.Equal(t, "vir01", info.VDevInfo[0].QueryInfo.Name)
+	info, err = backend.GetVirtualDeviceInfo(1)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"vir02", "vir04"}, []string{info.VDevInfo[0].QueryInfo.Name, info.VDevInfo[1].QueryInfo.Name})
+
+	checkpoint := newCheckpoint()
+	require.NoError(t, state.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint))
+	prepared := checkpoint.V1.PreparedClaims["uid-1"]
+	require.Len(t, prepared, 2)
+	for _, device := range prepared {
+		if slices.Contains(device.RequestNames, "large") {
+			assert.Contains(t, device.ContainerEdits.Env, "ASCEND_GLOBAL_LOG_LEVEL=1")
+		} else {
+			assert.NotContains(t, device.ContainerEdits.Env, "ASCEND_GLOBAL_LOG_LEVEL=1")
+		}
+	}
+}
+
+func TestRestoreFromCheckpoint(t *testing.T) {
+	backend := newTestFakeBackend(t, testInventory)
+	checkpointDir := t.TempDir()
+	state := newTestDeviceStateFor(t, backend, testInventory, checkpointDir, ExistingVnpuPolicyCleanup)
+
+	_, err := state.Prepare(newTestClaim(t, "uid-vnpu", "vir02", "npu-0-0"))
+	require.NoError(t, err)
+	_, err = state.Prepare(newTestClaim(t, "uid-full", "", "npu-1-0"))
+	require.NoError(t, err)
+	before := state.vnpuManager.PhysicalNpus["npu-0-0"]
+	require.Len(t, before.AllocatedSlices, 1)
+	vdevID := before.AllocatedSlices[0].VDevID
+
+	// The vNPU owned by the prepared claim must survive the cleanup policy.
+	restarted := newTestDeviceStateFor(t, backend, testInventory, checkpointDir, ExistingVnpuPolicyCleanup)
+
+	npu0 := restarted.vnpuManager.PhysicalNpus["npu-0-0"]
+	require.Len(t, npu0.AllocatedSlices, 1)
+	assert.Equal(t, &VnpuSlice{
+		SliceID:      "npu-0-0",
+		TemplateName: "vir02",
+		Allocated:    true,
+		Type:         "vNPU",
+		VDevID:       vdevID,
+	}, npu0.AllocatedSlices[0])
+	require.Len(t, npu0.AvailableSlices, 1)
+	assert.Equal(t, "npu-0-1", npu0.AvailableSlices[0].SliceID)
+	assert.Equal(t, 2, npu0.NextSliceIndex)
+	assert.Contains(t, restarted.allocatable, "npu-0-1")
+
+	npu1 := restarted.vnpuManager.PhysicalNpus["npu-1-0"]
+	require.Len(t, npu1.AllocatedSlices, 1)
+	assert.Equal(t, "NPU", npu1.AllocatedSlices[0].Type)
+	assert.Empty(t, npu1.AvailableSlices)
+
+	// The restored vNPU is destroyed on unprepare like any other.
+	require.NoError(t, restarted.Unprepare("uid-vnpu"))
+	info, err := backend.GetVirtualDeviceInfo(0)
+	require.NoError(t, err)
+	assert.Empty(t, info.VDevInfo)
+	assert.Len(t, npu0.AvailableSlices, 1)
+	assert.Equal(t, "npu-0-0", npu0.AvailableSlices[0].SliceID)
+}
+
+func TestCleanupExistingVnpus(t *testing.T) {
+	backend := newTestFakeBackend(t, testInventory)
+	state := newTestDeviceStateFor(t, backend, testInventory, t.TempDir(), ExistingVnpuPolicyCleanup)
+
+	info, err := backend.GetVirtualDeviceInfo(1)
+	require.NoError(t, err)
+	assert.Empty(t, info.VDevInfo)
+
+	npu1 := state.vnpuManager.PhysicalNpus["npu-1-0"]
+	require.Len(t, npu1.AvailableSlices, 1)
+	assert.Equal(t, "npu-1-0", npu1.AvailableSlices[0].SliceID)
+}
+
+func TestAdoptExistingVnpus(t *testing.T) {
+	backend := newTestFakeBackend(t, testInventory)
+	checkpointDir := t.TempDir()
+	state := newTestDeviceStateFor(t, backend, testInventory, checkpointDir, ExistingVnpuPolicyAdopt)
+
+	// The adopted vNPU is published with its own size, the rest of the card as
+	// a vNPU slice, and the full card is no longer offered.
+	npu1 := state.vnpuManager.PhysicalNpus["npu-1-0"]
+	require.Len(t, npu1.AvailableSlices, 2)
+	assert.Equal(t, &VnpuSlice{
+		SliceID:      "npu-1-2",
+		TemplateName: "vir02",
+		Type:         "vNPU",
+		VDevID:       100,
+		Adopted:      true,
+	}, npu1.AvailableSlices[0])
+	assert.Equal(t, "npu-1-1", npu1.AvailableSlices[1].SliceID)
+	require.Contains(t, state.allocatable, "npu-1-2")
+	attributes := state.allocatable["npu-1-2"].Attributes
+	assert.Equal(t, "vir02", *attributes[DriverDomain+"template"].StringValue)
+	assert.Equal(t, int64(2), *attributes[DriverDomain+"aicore"].IntValue)
+	assert.Equal(t, int64(6), *attributes[DriverDomain+"memory"].IntValue)
+
+	_, err := state.vnpuManager.AllocateSlice("npu-1-2", VnpuRequirements{Min: ChipCapacity{AICore: 4, MemoryGiB: 12}}, "", nil)
+	assert.EqualError(t, err, "adopted vNPU slice npu-1-2 (template: vir02) does not meet the requirements: AICORE>=4, Memory>=12GB")
+
+	devices, err := state.Prepare(newTestClaim(t, "uid-1", "vir01", "npu-1-2"))
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	checkpoint := newCheckpoint()
+	require.NoError(t, state.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint))
+	prepared := checkpoint.V1.PreparedClaims["uid-1"]
+	require.Len(t, prepared, 1)
+	assert.Equal(t, &PreparedVirtualDevice{LogicID: 1, VDevID: 100, Adopted: true}, prepared[0].VirtualDevice)
+	assert.Contains(t, prepared[0].ContainerEdits.Env, "ASCEND_VISIBLE_DEVICES=100")
+
+	// After a restart the vNPU is owned by the restored claim and not adopted twice.
+	restarted := newTestDeviceStateFor(t, backend, testInventory, checkpointDir, ExistingVnpuPolicyAdopt)
+	npu1 = restarted.vnpuManager.PhysicalNpus["npu-1-0"]
+	require.Len(t, npu1.AllocatedSlices, 1)
+	assert.True(t, npu1.AllocatedSlices[0].Adopted)
+	for _, slice := range npu1.AvailableSlices {
+		assert.NotEqual(t, uint32(100), slice.VDevID)
+	}
+
+	// Releasing an adopted vNPU keeps it on the chip and hands it out again.
+	require.NoError(t, restarted.Unprepare("uid-1"))
+	info, err := backend.GetVirtualDeviceInfo(1)
+	require.NoError(t, err)
+	require.Len(t, info.VDevInfo, 1)
+	assert.Equal(t, uint32(100), info.VDevInfo[0].VDevID)
+	assert.True(t, slices.ContainsFunc(npu1.AvailableSlices, func(s *VnpuSlice) bool {
+		return s.SliceID == "npu-1-2" && s.VDevID == 100
+	}))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+
+	"Ascend-dra-driver/pkg/common"
+)
+
+// FaultTaintKey is the key of the taint placed on the devices of a faulty
+// chip. The value is the fault class of the chip.
+const FaultTaintKey = DriverDomain + "fault"
+
+// faultClassSeverity orders the fault classes from most to least severe. A
+// chip reporting faults of several classes is tainted with the first one.
+var faultClassSeverity = []string{
+	common.SeparateNPU,
+	common.RestartNPU,
+	common.RestartBusiness,
+	common.RestartRequest,
+	common.PreSeparateNPU,
+	common.FreeRestartNPU,
+	common.NotHandleFault,
+}
+
+// faultClassEffects maps the fault classes to the effect of their taint.
+// Faults that make the running workload fail evict it, the others only keep
+// new claims away. NotHandleFault does not taint the device at all.
+var faultClassEffects = map[string]resourceapi.DeviceTaintEffect{
+	common.SeparateNPU:     resourceapi.DeviceTaintEffectNoExecute,
+	common.RestartNPU:      resourceapi.DeviceTaintEffectNoExecute,
+	common.RestartBusiness: resourceapi.DeviceTaintEffectNoExecute,
+	common.RestartRequest:  resourceapi.DeviceTaintEffectNoExecute,
+	common.PreSeparateNPU:  resourceapi.DeviceTaintEffectNoSchedule,
+	common.FreeRestartNPU:  resourceapi.DeviceTaintEffectNoSchedule,
+}
+
+// FaultCodeFile lists the dcmi error codes of each fault class as hex
+// strings. It has the format of the faultCode.json file shipped with the
+// Ascend device plugin, other keys of that file are ignored.
+type FaultCodeFile struct {
+	NotHandleFaultCodes  []string `json:"NotHandleFaultCodes,omitempty"`
+	RestartRequestCodes  []string `json:"RestartRequestCodes,omitempty"`
+	RestartBusinessCodes []string `json:"RestartBusinessCodes,omitempty"`
+	FreeRestartNPUCodes  []string `json:"FreeRestartNPUCodes,omitempty"`
+	RestartNPUCodes      []string `json:"RestartNPUCodes,omitempty"`
+	PreSeparateNPUCodes  []string `json:"PreSeparateNPUCodes,omitempty"`
+	SeparateNPUCodes     []string `json:"SeparateNPUCodes,omitempty"`
+}
+
+// LoadFaultCodes reads a JSON or YAML fault code file and returns the fault
+// class of each error code it lists.
+func LoadFaultCodes(path string) (map[int64]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fault code file: %v", err)
+	}
+	file := &FaultCodeFile{}
+	if err := yaml.Unmarshal(content, file); err != nil {
+		return nil, fmt.Errorf("failed to parse fault code file %s: %v", path, err)
+	}
+
+	faultCodes := make(map[int64]string)
+	for class, codes := range map[string][]string{
+		common.NotHandleFault:  file.NotHandleFaultCodes,
+		common.RestartRequest:  file.RestartRequestCodes,
+		common.RestartBusiness: file.RestartBusinessCodes,
+		common.FreeRestartNPU:  file.FreeRestartNPUCodes,
+		common.RestartNPU:      file.RestartNPUCodes,
+		common.PreSeparateNPU:  file.PreSeparateNPUCodes,
+		common.SeparateNPU:     file.SeparateNPUCodes,
+	} {
+		for _, code := range codes {
+			value, err := strconv.ParseInt(strings.TrimPrefix(strings.ToLower(code), "0x"), 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s code %q: %v", class, code, err)
+			}
+			if existing, ok := faultCodes[value]; ok && existing != class {
+				return nil, fmt.Errorf("code %q is listed as both %s and %s", code, existing, class)
+			}
+			faultCodes[value] = class
+		}
+	}
+	return faultCodes, nil
+}
+
+// healthFaultClass is the fault class assumed for a chip from the health it
+// reports, for error codes missing from the fault code table.
+func healthFaultClass(healthCode uint32) string {
+	switch healthCode {
+	case common.HealthStateNormal, common.HealthStateGeneralAlarm:
+		return common.NotHandleFault
+	case common.HealthStateImportantAlarm:
+		return common.PreSeparateNPU
+	default:
+		return common.SeparateNPU
+	}
+}
+
+// FaultTaint returns the taint for the devices of a chip in the given state,
+// or nil if the chip has no fault that needs one. An unhealthy chip is always
+// tainted: if none of its error codes calls for a taint, it gets the fault
+// class derived from its health code.
+func FaultTaint(state DeviceHealthState, faultCodes map[int64]string) *resourceapi.DeviceTaint {
+	classes := sets.New[string]()
+	for _, code := range state.ErrorCodes {
+		class, ok := faultCodes[code]
+		if !ok {
+			class = healthFaultClass(state.HealthCode)
+		}
+		classes.Insert(class)
+	}
+
+	class := ""
+	for _, candidate := range faultClassSeverity {
+		if _, ok := faultClassEffects[candidate]; ok && classes.Has(candidate) {
+			class = candidate
+			break
+		}
+	}
+	if class == "" && state.Health == common.UnHealthyState {
+		class = healthFaultClass(state.HealthCode)
+	}
+	effect, ok := faultClassEffects[class]
+	if !ok {
+		return nil
+	}
+	return &resourceapi.DeviceTaint{
+		Key:       FaultTaintKey,
+		Value:     class,
+		Effect:    effect,
+		TimeAdded: state.Since.DeepCopy(),
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1"
+
+	"Ascend-dra-driver/pkg/common"
+)
+
+func TestLoadFaultCodes(t *testing.T) {
+	tests := map[string]struct {
+		content  string
+		expected map[int64]string
+		wantErr  bool
+	}{
+		"device plugin format": {
+			content: `{
+  "NotHandleFaultCodes": ["80E01801"],
+  "RestartNPUCodes": ["80C98009", "0x80CB8009"],
+  "SeparateNPUCodes": ["80E18005"],
+  "FaultCodeConfiguration": {"unrelated": true}
+}`,
+			expected: map[int64]string{
+				0x80E01801: common.NotHandleFault,
+				0x80C98009: common.RestartNPU,
+				0x80CB8009: common.RestartNPU,
+				0x80E18005: common.SeparateNPU,
+			},
+		},
+		"yaml": {
+			content: "PreSeparateNPUCodes:\n- 80818C00\n",
+			expected: map[int64]string{
+				0x80818C00: common.PreSeparateNPU,
+			},
+		},
+		"invalid code": {
+			content: `{"RestartNPUCodes": ["not-a-code"]}`,
+			wantErr: true,
+		},
+		"conflicting classes": {
+			content: `{"RestartNPUCodes": ["80C98009"], "SeparateNPUCodes": ["80C98009"]}`,
+			wantErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "faultCode.json")
+			require.NoError(t, os.WriteFile(path, []byte(test.content), 0600))
+			faultCodes, err := LoadFaultCodes(path)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, faultCodes)
+		})
+	}
+}
+
+func TestFaultTaint(t *testing.T) {
+	faultCodes := map[int64]string{
+		0x80E01801: common.NotHandleFault,
+		0x80C98009: common.RestartNPU,
+		0x80818C00: common.PreSeparateNPU,
+	}
+	tests := map[string]struct {
+		health     string
+		healthCode uint32
+		errorCodes []int64
+		class      string
+		effect     resourceapi.DeviceTaintEffect
+	}{
+		"healthy": {
+			health: common.HealthyState,
+		},
+		"fault not to be handled": {
+			health:     common.WarningState,
+			errorCodes: []int64{0x80E01801},
+		},
+		"unknown code with minor alarm": {
+			health:     common.WarningState,
+			healthCode: common.HealthStateGeneralAlarm,
+			errorCodes: []int64{0x1234},
+		},
+		"known code": {
+			health:     common.WarningState,
+			errorCodes: []int64{0x80818C00},
+			class:      common.PreSeparateNPU,
+			effect:     resourceapi.DeviceTaintEffectNoSchedule,
+		},
+		"most severe code wins": {
+			health:     common.UnHealthyState,
+			healthCode: common.HealthStateImportantAlarm,
+			errorCodes: []int64{0x80818C00, 0x80E01801, 0x80C98009},
+			class:      common.RestartNPU,
+			effect:     resourceapi.DeviceTaintEffectNoExecute,
+		},
+		"unknown code with urgent alarm": {
+			health:     common.UnHealthyState,
+			healthCode: common.HealthStateUrgentAlarm,
+			errorCodes: []int64{0x1234},
+			class:      common.SeparateNPU,
+			effect:     resourceapi.DeviceTaintEffectNoExecute,
+		},
+		"unhealthy without codes": {
+			health:     common.UnHealthyState,
+			healthCode: common.HealthStateImportantAlarm,
+			class:      common.PreSeparateNPU,
+			effect:     resourceapi.DeviceTaintEffectNoSchedule,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			taint := FaultTaint(DeviceHealthState{
+				DeviceHealth: common.DeviceHealth{Health: test.health},
+				HealthCode:   test.healthCode,
+				ErrorCodes:   test.errorCodes,
+			}, faultCodes)
+			if test.class == "" {
+				assert.Nil(t, taint)
+				return
+			}
+			require.NotNil(t, taint)
+			assert.Equal(t, FaultTaintKey, taint.Key)
+			assert.Equal(t, test.class, taint.Value)
+			assert.Equal(t, test.effect, taint.Effect)
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultTemplateModel holds the templates of the chip models without
+// templates of their own.
+const DefaultTemplateModel = "default"
+
+// DefaultTemplatePath is where the npu-smi template info is read from by default.
+const DefaultTemplatePath = "/etc/npu"
+
+// templateInfoFile is the name of the npu-smi template info file applying to
+// all models. The file template-info-<model>.txt applies to a single model.
+const templateInfoFile = "template-info"
+
+// templateReloadDelay debounces the reloads of the template files, which are
+// usually written in several steps, e.g. when a ConfigMap is updated.
+var templateReloadDelay = time.Second
+
+// TemplateCatalog maps chip models, as reported by dcmi (e.g. 310P3, 910B3),
+// to the vNPU templates they support, keyed by template name.
+type TemplateCatalog map[string]map[string]*VnpuTemplate
+
+// TemplateFile is the structured format of a template file. It can be written
+// as either JSON or YAML.
+type TemplateFile struct {
+	Models map[string][]InventoryTemplate `json:"models"`
+}
+
+// ForModel returns the templates of a chip model, or the default templates.
+func (c TemplateCatalog) ForModel(model string) map[string]*VnpuTemplate {
+	if templates, ok := c[model]; ok {
+		return templates
+	}
+	return c[DefaultTemplateModel]
+}
+
+// Names returns the names of all templates of all models.
+func (c TemplateCatalog) Names() []string {
+	var names []string
+	for _, templates := range c {
+		for name := range templates {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// add adds a template of a model, replacing a template of the same name.
+func (c TemplateCatalog) add(model string, tpl InventoryTemplate) error {
+	if tpl.Name == "" {
+		return fmt.Errorf("template without name for model %s", model)
+	}
+	if tpl.AICore <= 0 || tpl.MemoryGiB <= 0 {
+		return fmt.Errorf("template %s of model %s needs positive aicore and memoryGiB", tpl.Name, model)
+	}
+	if c[model] == nil {
+		c[model] = make(map[string]*VnpuTemplate)
+	}
+	c[model][tpl.Name] = tpl.vnpuTemplate()
+	return nil
+}
+
+// addTemplateInfo adds the templates of a model listed in the output of
+// `npu-smi info -t template-info`.
+func (c TemplateCatalog) addTemplateInfo(model string, output string) error {
+	templates := make(map[string]*VnpuTemplate)
+	if err := parseTemplateInfo(output, templates); err != nil {
+		return err
+	}
+	for _, tpl := range templates {
+		if err := c.add(model, InventoryTemplate{
+			Name:         tpl.Name,
+			AICore:       tpl.Attributes.AICORE,
+			MemoryGiB:    tpl.Attributes.Memory,
+			AICPU:        tpl.Attributes.AICPU,
+			MediaEngines: tpl.Attributes.Media,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge adds the templates of another catalog, replacing templates of the
+// same model and name.
+func (c TemplateCatalog) merge(other TemplateCatalog) {
+	for model, templates := range other {
+		if c[model] == nil {
+			c[model] = make(map[string]*VnpuTemplate)
+		}
+		maps.Copy(c[model], templates)
+	}
+}
+
+// LoadTemplateCatalog reads the vNPU templates from the given files and
+// directories. Directories are read in file name order, ignoring hidden
+// entries. YAML and JSON files are read in the TemplateFile format, .txt files
+// as the output of `npu-smi info -t template-info`, which applies to all
+// models if the file is named template-info.txt and to the model <model> if it
+// is named template-info-<model>.txt. A template defined again in a later file
+// replaces the earlier one. Paths that do not exist are skipped.
+func LoadTemplateCatalog(paths []string) (TemplateCatalog, error) {
+	catalog := make(TemplateCatalog)
+	for _, path := range paths {
+		files, err := templateFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if err := catalog.loadFile(file); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return catalog, nil
+}
+
+// templateFiles lists the template files of a path.
+func templateFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template path: %v", err)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template directory: %v", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || !isTemplateFile(entry.Name()) {
+			continue
+		}
+		file := filepath.Join(path, entry.Name())
+		// ConfigMap keys are mounted as symlinks, follow them.
+		if info, err := os.Stat(file); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func isTemplateFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml", ".json", ".txt":
+		return true
+	}
+	return false
+}
+
+// loadFile adds the templates of a file to the catalog.
+func (c TemplateCatalog) loadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read template file: %v", err)
+	}
+
+	if filepath.Ext(path) == ".txt" {
+		base := strings.TrimSuffix(filepath.Base(path), ".txt")
+		model := DefaultTemplateModel
+		if base != templateInfoFile {
+			var ok bool
+			model, ok = strings.CutPrefix(base, templateInfoFile+"-")
+			if !ok || model == "" {
+				log.Printf("Skipping template file %s, expected %s.txt or %s-<model>.txt", path, templateInfoFile, templateInfoFile)
+				return nil
+			}
+		}
+		if err := c.addTemplateInfo(model, string(content)); err != nil {
+			return fmt.Errorf("invalid template file %s: %v", path, err)
+		}
+		return nil
+	}
+
+	file := TemplateFile{}
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return fmt.Errorf("failed to parse template file %s: %v", path, err)
+	}
+	for model, templates := range file.Models {
+		for _, tpl := range templates {
+			if err := c.add(model, tpl); err != nil {
+				return fmt.Errorf("invalid template file %s: %v", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// TemplateRegistry provides the vNPU templates, either a fixed catalog or the
+// catalog read from template files, which it reloads when they change.
+type TemplateRegistry struct {
+	sync.Mutex
+	paths []string
+	// driver holds the templates reported by the driver. The default
+	// templates of the files are laid under them, those of a model over them.
+	driver  TemplateCatalog
+	catalog TemplateCatalog
+}
+
+// NewTemplateRegistry reads the templates from the given files and
+// directories, which add to the templates reported by the driver. Only the
+// templates the files list for a model replace those of the driver. The
+// driver templates may be nil.
+func NewTemplateRegistry(paths []string, driver TemplateCatalog) (*TemplateRegistry, error) {
+	r := &TemplateRegistry{paths: paths, driver: driver}
+	catalog, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	if len(catalog) == 0 {
+		log.Printf("Warning: no vNPU templates reported by the driver or found in %v, only full cards can be allocated", paths)
+	}
+	r.catalog = catalog
+	return r, nil
+}
+
+// NewStaticTemplateRegistry returns a registry serving a fixed catalog.
+func NewStaticTemplateRegistry(catalog TemplateCatalog) *TemplateRegistry {
+	return &TemplateRegistry{catalog: catalog}
+}
+
+// Catalog returns the current templates.
+func (r *TemplateRegistry) Catalog() TemplateCatalog {
+	r.Lock()
+	defer r.Unlock()
+	return r.catalog
+}
+
+// Reload reads the template files again. It reports whether the templates
+// changed. On errors the current templates are kept.
+func (r *TemplateRegistry) Reload() (bool, error) {
+	catalog, err := r.load()
+	if err != nil {
+		return false, err
+	}
+	r.Lock()
+	defer r.Unlock()
+	if reflect.DeepEqual(catalog, r.catalog) {
+		return false, nil
+	}
+	r.catalog = catalog
+	return true, nil
+}
+
+// load reads the template files on top of the driver templates.
+func (r *TemplateRegistry) load() (TemplateCatalog, error) {
+	files, err := LoadTemplateCatalog(r.paths)
+	if err != nil {
+		return nil, err
+	}
+	catalog := make(TemplateCatalog)
+	// The default templates of the files add to the templates of every model
+	// reported by the driver, but only the templates the files list for the
+	// model itself replace those of the driver.
+	if defaults := files[DefaultTemplateModel]; defaults != nil {
+		for model := range r.driver {
+			catalog[model] = maps.Clone(defaults)
+		}
+	}
+	catalog.merge(r.driver)
+	catalog.merge(files)
+	return catalog, nil
+}
+
+// Watch reloads the templates whenever the template files change and calls
+// onChange with the new templates, until ctx is done. A static registry is
+// not watched.
+func (r *TemplateRegistry) Watch(ctx context.Context, onChange func(TemplateCatalog)) error {
+	if len(r.paths) == 0 {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create template file watcher: %v", err)
+	}
+	// Directories are watched instead of files, so that files created later
+	// and files replaced by renames or symlink swaps are noticed.
+	dirs := make(map[string]bool)
+	for _, path := range r.paths {
+		dir := path
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			dir = filepath.Dir(path)
+		}
+		dirs[dir] = true
+	}
+	for _, dir := range slices.Sorted(maps.Keys(dirs)) {
+		if err := watcher.Add(dir); err != nil {
+			log.Printf("Warning: not watching template directory %s: %v", dir, err)
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+		reload := time.NewTimer(templateReloadDelay)
+		reload.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				reload.Stop()
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				log.Printf("Template file event: %s", event)
+				reload.Reset(templateReloadDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Template file watcher error: %v", err)
+			case <-reload.C:
+				changed, err := r.Reload()
+				if err != nil {
+					log.Printf("Failed to reload vNPU templates, keeping the current ones: %v", err)
+					continue
+				}
+				if changed {
+					log.Printf("vNPU templates changed: %v", r.Catalog().Names())
+					onChange(r.Catalog())
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1"
+)
+
+const testTemplateInfo = `+------------------------------------------------------------------+
+|NPU instance template info is:                                    |
+|Name                AICORE    Memory    AICPU     VPC      VENC   |
+|                                GB                                |
+|==================================================================|
+|vir01               1         3         1         1        0      |
++------------------------------------------------------------------+
+|vir02               2         6         2         3        1      |
++------------------------------------------------------------------+
+`
+
+const testTemplateFile = `
+models:
+  910B3:
+  - name: vir05_1c_16g
+    aicore: 5
+    memoryGiB: 16
+    aicpu: 1
+  310P3:
+  - name: vir02
+    aicore: 2
+    memoryGiB: 8
+`
+
+func writeTemplateFile(t *testing.T, dir, name, content string) {
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+}
+
+// test310PTemplateInfo is the two-line template table of a 310P.
+const test310PTemplateInfo = `+-------------------------------------------------------------------------------------------+
+|NPU instance template info is:                                                             |
+|Name                AICORE    Memory    AICPU     VPC            VENC           JPEGD      |
+|                                GB                VDEC           JPEGE          PNGD       |
+|===========================================================================================|
+|vir01               1         3         1         1              0              2          |
+|                                                  1              0              4          |
++-------------------------------------------------------------------------------------------+
+|vir04_3c_ndvpp      4         12        3         0              0              0          |
+|                                                  0              0              0          |
++-------------------------------------------------------------------------------------------+
+|vir04_4c_dvpp       4         12        4         12             3              16         |
+|                                                  12             3              32         |
++-------------------------------------------------------------------------------------------+
+`
+
+func TestParseTemplateInfo(t *testing.T) {
+	testCases := map[string]struct {
+		output   string
+		expected map[string]*VnpuTemplate
+	}{
+		"one line per template": {
+			output: testTemplateInfo,
+			expected: map[string]*VnpuTemplate{
+				"vir01": {Name: "vir01", Attributes: VnpuTemplateAttribute{AICORE: 1, Memory: 3, AICPU: 1, Media: MediaEngines{VPC: 1}}},
+				"vir02": {Name: "vir02", Attributes: VnpuTemplateAttribute{AICORE: 2, Memory: 6, AICPU: 2, Media: MediaEngines{VPC: 3, VENC: 1}}},
+			},
+		},
+		"two lines per template": {
+			output: test310PTemplateInfo,
+			expected: map[string]*VnpuTemplate{
+				"vir01": {Name: "vir01", Attributes: VnpuTemplateAttribute{AICORE: 1, Memory: 3, AICPU: 1,
+					Media: MediaEngines{VPC: 1, VDEC: 1, JPEGD: 2, PNGD: 4}}},
+				"vir04_3c_ndvpp": {Name: "vir04_3c_ndvpp", Attributes: VnpuTemplateAttribute{AICORE: 4, Memory: 12, AICPU: 3}},
+				"vir04_4c_dvpp": {Name: "vir04_4c_dvpp", Attributes: VnpuTemplateAttribute{AICORE: 4, Memory: 12, AICPU: 4,
+					Media: MediaEngines{VPC: 12, VENC: 3, VDEC: 12, JPEGD: 16, JPEGE: 3, PNGD: 32}}},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			templates := make(map[string]*VnpuTemplate)
+			require.NoError(t, parseTemplateInfo(tc.output, templates))
+			assert.Equal(t, tc.expected, templates)
+		})
+	}
+
+	assert.EqualError(t, parseTemplateInfo("Error: not supported", map[string]*VnpuTemplate{}),
+		"failed to find template info header")
+}
+
+func TestLoadTemplateCatalog(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplateFile(t, dir, "template-info.txt", testTemplateInfo)
+	writeTemplateFile(t, dir, "template-info-310B1.txt", testTemplateInfo)
+	// Later files replace the templates of earlier ones.
+	writeTemplateFile(t, dir, "z-templates.yaml", testTemplateFile)
+	writeTemplateFile(t, dir, ".hidden.yaml", "invalid")
+	writeTemplateFile(t, dir, "README.md", "invalid")
+
+	catalog, err := LoadTemplateCatalog([]string{dir, filepath.Join(dir, "missing")})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{DefaultTemplateModel, "310B1", "310P3", "910B3"}, keys(catalog))
+	assert.Equal(t, &VnpuTemplate{Name: "vir02", Attributes: VnpuTemplateAttribute{AICORE: 2, Memory: 6, AICPU: 2, Media: MediaEngines{VPC: 3, VENC: 1}}},
+		catalog.ForModel("310B1")["vir02"])
+	assert.Equal(t, &VnpuTemplate{Name: "vir02", Attributes: VnpuTemplateAttribute{AICORE: 2, Memory: 8}},
+		catalog.ForModel("310P3")["vir02"])
+	assert.Len(t, catalog.ForModel("910B3"), 1)
+	// Models without templates of their own use the default ones.
+	assert.Len(t, catalog.ForModel("310P1"), 2)
+	assert.Equal(t, []string{"vir01", "vir02", "vir05_1c_16g"}, catalog.Names())
+
+	catalog, err = LoadTemplateCatalog([]string{filepath.Join(dir, "z-templates.yaml")})
+	require.NoError(t, err)
+	assert.Nil(t, catalog.ForModel("310B1"))
+
+	skipped := map[string]string{
+		"template-info-.txt":  testTemplateInfo,
+		"npu-smi.txt":         testTemplateInfo,
+		"templates.yaml.orig": testTemplateFile,
+	}
+	for name, content := range skipped {
+		dir := t.TempDir()
+		writeTemplateFile(t, dir, name, content)
+		catalog, err := LoadTemplateCatalog([]string{dir})
+		require.NoError(t, err, name)
+		assert.Empty(t, catalog, name)
+	}
+
+	invalid := map[string]string{
+		"unknown-field.yaml":     `models: {310P3: [{name: vir01, aicore: 1, memory: 3}]}`,
+		"missing-resources.json": `{"models": {"310P3": [{"name": "vir01", "aicore": 1}]}}`,
+		"template-info.txt":      "vir01 1 3",
+	}
+	for name, content := range invalid {
+		dir := t.TempDir()
+		writeTemplateFile(t, dir, name, content)
+		_, err := LoadTemplateCatalog([]string{dir})
+		assert.Error(t, err, name)
+	}
+}
+
+func keys[V any](m map[string]V) []string {
+	var result []string
+	for key := range m {
+		result = append(result, key)
+	}
+	return result
+}
+
+func TestTemplateRegistryWatch(t *testing.T) {
+	templateReloadDelay = 10 * time.Millisecond
+	dir := t.TempDir()
+	writeTemplateFile(t, dir, "template-info.txt", testTemplateInfo)
+	registry, err := NewTemplateRegistry([]string{dir}, nil)
+	require.NoError(t, err)
+	assert.Len(t, registry.Catalog().ForModel("310P3"), 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan TemplateCatalog, 10)
+	require.NoError(t, registry.Watch(ctx, func(catalog TemplateCatalog) {
+		changes <- catalog
+	}))
+
+	writeTemplateFile(t, dir, "templates.yaml", testTemplateFile)
+	select {
+	case catalog := <-changes:
+		assert.Len(t, catalog.ForModel("310P3"), 1)
+		assert.Equal(t, catalog, registry.Catalog())
+	case <-time.After(5 * time.Second):
+		t.Fatal("templates were not reloaded")
+	}
+
+	// Invalid files keep the current templates.
+	writeTemplateFile(t, dir, "templates.yaml", "models: [")
+	changed, err := registry.Reload()
+	assert.Error(t, err)
+	assert.False(t, changed)
+	assert.Len(t, registry.Catalog().ForModel("910B3"), 1)
+}
+
+func TestUpdateTemplates(t *testing.T) {
+	state, _ := newTestDeviceState(t, testInventory)
+	require.Contains(t, state.allocatable, "npu-0-0")
+	assert.Contains(t, state.allocatable["npu-0-0"].Attributes, resourceapi.QualifiedName(DriverDomain+"vnpu_vir01"))
+
+	// A split chip publishes the remainder under the new templates.
+	_, err := state.Prepare(newTestClaim(t, "claim-1", "vir02", "npu-0-0"))
+	require.NoError(t, err)
+
+	state.UpdateTemplates(TemplateCatalog{"310P3": {
+		"vir03": {Name: "vir03", Attributes: VnpuTemplateAttribute{AICORE: 3, Memory: 9}},
+	}})
+	for name, device := range state.allocatable {
+		assert.NotContains(t, device.Attributes, resourceapi.QualifiedName(DriverDomain+"vnpu_vir01"), name)
+		assert.Equal(t, "aicore=3,memoryGiB=9", *device.Attributes[DriverDomain+"vnpu_vir03"].StringValue, name)
+	}
+	tpl, ok := state.vnpuManager.Template("npu-0-1", "vir03")
+	require.True(t, ok)
+	assert.Equal(t, 3, tpl.Attributes.AICORE)
+}
+
+func TestUpdatePartitionTemplates(t *testing.T) {
+	backend := newTestFakeBackend(t, partitionTestInventory)
+	state := newTestDeviceStateFor(t, backend, partitionTestInventory, t.TempDir(), ExistingVnpuPolicyAdopt, partitionable)
+	require.Contains(t, state.allocatable, "npu-0-vir04-3c-1")
+
+	state.UpdateTemplates(TemplateCatalog{DefaultTemplateModel: {
+		"vir02": {Name: "vir02", Attributes: VnpuTemplateAttribute{AICORE: 2, Memory: 6, AICPU: 2}},
+	}})
+	assert.NotContains(t, state.allocatable, "npu-0-vir04-3c-1")
+	assert.Contains(t, state.allocatable, "npu-0-vir02-3")
+	assert.Equal(t, "vir02", state.partitions["npu-0-vir02-3"].TemplateName)
+	assert.Equal(t, "aicore=2,memoryGiB=6,aicpu=2", *state.allocatable["npu-0-0"].Attributes[DriverDomain+"vnpu_vir02"].StringValue)
+	// The vNPU adopted on logic ID 1 stays.
+	assert.Contains(t, state.allocatable, "npu-1-vdev100")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/utils/ptr"
+
+	"Ascend-dra-driver/pkg/common"
+)
+
+// sysfsPCIDevicesPath is where the kernel lists the PCI devices of the node.
+var sysfsPCIDevicesPath = "/sys/bus/pci/devices"
+
+// hccsFullMeshModels matches the chip models whose chips are all connected to
+// each other through HCCS, i.e. the 910B and 910_93 series. The chips of the
+// first generation 910 are connected in rings of four.
+var hccsFullMeshModels = regexp.MustCompile(`^910(B\d|_93)`)
+
+const hccsRingSize = 4
+
+// NpuTopology locates a chip on the node. It is published as device attributes
+// so that claims can keep the devices of a request on the same card, NUMA node
+// or HCCS group with matchAttribute constraints. Fields that could not be
+// determined are left empty and not published.
+type NpuTopology struct {
+	CardID    int32
+	PhyID     int32
+	PCIeBusID string
+	NUMANode  *int64
+	HCCSGroup *int64
+}
+
+// numaNodeGetter is implemented by backends that know the NUMA node of their
+// chips themselves. The NUMA node of the other backends is read from sysfs.
+type numaNodeGetter interface {
+	GetNUMANode(logicID int32) (int32, error)
+}
+
+// getNpuTopology collects the topology of a chip. Only the card and physical
+// IDs are mandatory, the rest is best effort as not every chip and driver
+// version reports it.
+func getNpuTopology(backend NpuBackend, dev common.NpuDevice) NpuTopology {
+	topology := NpuTopology{
+		CardID:    dev.CardID,
+		PhyID:     dev.PhyID,
+		HCCSGroup: hccsGroup(dev.ChipName, dev.PhyID),
+	}
+
+	busID, err := backend.GetPCIeBusInfo(dev.LogicID)
+	if err != nil {
+		log.Printf("Failed to get PCIe bus ID of logic ID %d: %v", dev.LogicID, err)
+	}
+	topology.PCIeBusID = strings.ToLower(strings.TrimSpace(busID))
+
+	if getter, ok := backend.(numaNodeGetter); ok {
+		node, err := getter.GetNUMANode(dev.LogicID)
+		if err != nil {
+			log.Printf("Failed to get NUMA node of logic ID %d: %v", dev.LogicID, err)
+		} else if node >= 0 {
+			topology.NUMANode = ptr.To(int64(node))
+		}
+	} else if topology.PCIeBusID != "" {
+		node, err := pciNUMANode(topology.PCIeBusID)
+		if err != nil {
+			log.Printf("Failed to get NUMA node of logic ID %d: %v", dev.LogicID, err)
+		}
+		topology.NUMANode = node
+	}
+	return topology
+}
+
+// pciNUMANode reads the NUMA node of a PCI device from sysfs. It returns nil
+// on nodes without NUMA, where the kernel reports -1.
+func pciNUMANode(busID string) (*int64, error) {
+	content, err := os.ReadFile(filepath.Join(sysfsPCIDevicesPath, busID, "numa_node"))
+	if err != nil {
+		return nil, err
+	}
+	node, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NUMA node of PCI device %s: %v", busID, err)
+	}
+	if node < 0 {
+		return nil, nil
+	}
+	return &node, nil
+}
+
+// hccsGroup returns the HCCS group of a chip, or nil for chips without HCCS
+// such as the 310 series.
+func hccsGroup(chipName string, phyID int32) *int64 {
+	switch {
+	case hccsFullMeshModels.MatchString(chipName):
+		return ptr.To(int64(0))
+	case strings.HasPrefix(chipName, "910"):
+		return ptr.To(int64(phyID / hccsRingSize))
+	default:
+		return nil
+	}
+}
+
+// addTo adds the topology attributes to the attributes of a device.
+func (t NpuTopology) addTo(attributes map[resourceapi.QualifiedName]resourceapi.DeviceAttribute) {
+	attributes[DriverDomain+"cardID"] = resourceapi.DeviceAttribute{IntValue: ptr.To(int64(t.CardID))}
+	attributes[DriverDomain+"phyID"] = resourceapi.DeviceAttribute{IntValue: ptr.To(int64(t.PhyID))}
+	if t.PCIeBusID != "" {
+		attributes[DriverDomain+"pcieBusID"] = resourceapi.DeviceAttribute{StringValue: ptr.To(t.PCIeBusID)}
+	}
+	if t.NUMANode != nil {
+		attributes[DriverDomain+"numaNode"] = resourceapi.DeviceAttribute{IntValue: ptr.To(*t.NUMANode)}
+	}
+	if t.HCCSGroup != nil {
+		attributes[DriverDomain+"hccsGroup"] = resourceapi.DeviceAttribute{IntValue: ptr.To(*t.HCCSGroup)}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+)
+
+const topologyTestInventory = `
+templates:
+- name: vir02
+  aicore: 2
+  memoryGiB: 6
+devices:
+- logicID: 0
+  phyID: 0
+  cardID: 3
+  chipName: 310P3
+  aicore: 8
+  memoryGiB: 21
+  pcieBusID: 0000:C1:00.0
+  numaNode: 1
+- logicID: 1
+  phyID: 1
+  cardID: 3
+  deviceID: 1
+  chipName: 310P3
+  aicore: 8
+  memoryGiB: 21
+`
+
+func TestTopologyAttributes(t *testing.T) {
+	state, _ := newTestDeviceState(t, topologyTestInventory)
+
+	device := state.allocatable["npu-0-0"]
+	assert.Equal(t, int64(3), *device.Attributes[DriverDomain+"cardID"].IntValue)
+	assert.Equal(t, int64(0), *device.Attributes[DriverDomain+"phyID"].IntValue)
+	assert.Equal(t, "0000:c1:00.0", *device.Attributes[DriverDomain+"pcieBusID"].StringValue)
+	assert.Equal(t, int64(1), *device.Attributes[DriverDomain+"numaNode"].IntValue)
+	assert.NotContains(t, device.Attributes, DriverDomain+"hccsGroup")
+
+	// Unknown topology is not published.
+	device = state.allocatable["npu-1-0"]
+	assert.Equal(t, int64(3), *device.Attributes[DriverDomain+"cardID"].IntValue)
+	assert.NotContains(t, device.Attributes, DriverDomain+"pcieBusID")
+	assert.NotContains(t, device.Attributes, DriverDomain+"numaNode")
+
+	// The vNPUs carved from a chip share its topology.
+	_, err := state.Prepare(newTestClaim(t, "uid-1", "vir02", "npu-0-0"))
+	require.NoError(t, err)
+	var carved int
+	for name, device := range state.allocatable {
+		if *device.Attributes[DriverDomain+"index"].IntValue != 0 || name == "npu-0-0" {
+			continue
+		}
+		carved++
+		assert.Equal(t, int64(3), *device.Attributes[DriverDomain+"cardID"].IntValue, name)
+		assert.Equal(t, int64(1), *device.Attributes[DriverDomain+"numaNode"].IntValue, name)
+	}
+	assert.NotZero(t, carved)
+}
+
+func TestPCINUMANode(t *testing.T) {
+	sysfs := t.TempDir()
+	oldPath := sysfsPCIDevicesPath
+	sysfsPCIDevicesPath = sysfs
+	t.Cleanup(func() { sysfsPCIDevicesPath = oldPath })
+
+	for busID, content := range map[string]string{
+		"0000:c1:00.0": "1\n",
+		"0000:c2:00.0": "-1\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(sysfs, busID), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sysfs, busID, "numa_node"), []byte(content), 0600))
+	}
+
+	node, err := pciNUMANode("0000:c1:00.0")
+	require.NoError(t, err)
+	assert.Equal(t, ptr.To(int64(1)), node)
+
+	node, err = pciNUMANode("0000:c2:00.0")
+	require.NoError(t, err)
+	assert.Nil(t, node)
+
+	_, err = pciNUMANode("0000:c3:00.0")
+	assert.Error(t, err)
+}
+
+func TestHCCSGroup(t *testing.T) {
+	tests := []struct {
+		chipName string
+		phyID    int32
+		expected *int64
+	}{
+		{chipName: "310P3", phyID: 5, expected: nil},
+		{chipName: "910B3", phyID: 5, expected: ptr.To(int64(0))},
+		{chipName: "910_9392", phyID: 12, expected: ptr.To(int64(0))},
+		{chipName: "910B", phyID: 3, expected: ptr.To(int64(0))},
+		{chipName: "910ProB", phyID: 5, expected: ptr.To(int64(1))},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, hccsGroup(test.chipName, test.phyID), "%s/%d", test.chipName, test.phyID)
+	}
+}
@@ -1,134 +1,167 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"log"
-	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 )
 
-// NewVnpuManager creates and initializes a new VnpuManager.
-func NewVnpuManager() (*VnpuManager, error) {
-	templates, err := GetNpuTemplateInfo()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get NPU template info: %v", err)
-	}
+// NewVnpuManager creates and initializes a new VnpuManager carving the vNPUs
+// from the templates of the catalog.
+func NewVnpuManager(catalog TemplateCatalog) *VnpuManager {
 	return &VnpuManager{
 		PhysicalNpus: make(map[string]*PhysicalNpuState),
-		Templates:    templates,
-	}, nil
+		Catalog:      catalog,
+	}
 }
 
-// GetNpuTemplateInfo attempts to read the NPU template information from a file.
-// If the file is not found, it falls back to default templates.
-func GetNpuTemplateInfo() (map[string]*VnpuTemplate, error) {
-	filePath := "/etc/npu/template-info.txt"
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		log.Printf("Failed to read template file: %v. Using default templates.", err)
-		return createDefaultTemplates(), nil
-	}
-	templates := make(map[string]*VnpuTemplate)
-	if err := parseTemplateInfo(string(content), templates); err != nil {
-		return nil, err
+// SetCatalog replaces the templates of all physical NPUs, e.g. after the
+// template files changed. vNPUs already carved keep their template.
+func (m *VnpuManager) SetCatalog(catalog TemplateCatalog) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.Catalog = catalog
+	for _, npu := range m.PhysicalNpus {
+		npu.Templates = cloneTemplates(catalog.ForModel(npu.ModelName))
+		m.updateSupportTemplates(npu)
 	}
-	log.Printf("Successfully loaded %d templates from file.", len(templates))
-	return templates, nil
 }
 
-// createDefaultTemplates generates a set of default templates.
-func createDefaultTemplates() map[string]*VnpuTemplate {
-	templates := map[string]*VnpuTemplate{
-		"vir01": {Name: "vir01", Attributes: VnpuTemplateAttribute{AICORE: 4, Memory: 8}},
-		"vir02": {Name: "vir02", Attributes: VnpuTemplateAttribute{AICORE: 8, Memory: 12}},
-		"vir04": {Name: "vir04", Attributes: VnpuTemplateAttribute{AICORE: 16, Memory: 16}},
+// Template returns a template of the physical NPU owning a device.
+func (m *VnpuManager) Template(deviceName, templateName string) (*VnpuTemplate, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	npu, ok := m.findPhysicalNpu(deviceName)
+	if !ok {
+		return nil, false
 	}
-	log.Printf("Using default templates. Total: %d", len(templates))
-	return templates
+	tpl, ok := npu.Templates[templateName]
+	return tpl, ok
 }
 
-// parseTemplateInfo parses the template info string and populates the templates map.
+// parseTemplateInfo parses the template table printed by `npu-smi info -t
+// template-info` and populates the templates map. The table may take two lines
+// per template, e.g. the 310P prints its VDEC, JPEGE and PNGD columns below the
+// VPC, VENC and JPEGD ones, so values are matched to the column of their line
+// whose header starts closest to them.
 func parseTemplateInfo(output string, templates map[string]*VnpuTemplate) error {
-	scanner := bufio.NewScanner(strings.NewReader(output))
-
-	var headerLine string
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, "Name") && strings.Contains(line, "AICORE") && strings.Contains(line, "Memory") {
-			headerLine = line
-			break
-		}
-	}
-	if headerLine == "" {
+	lines := strings.Split(output, "\n")
+	header := slices.IndexFunc(lines, func(line string) bool {
+		return strings.Contains(line, "Name") && strings.Contains(line, "AICORE") && strings.Contains(line, "Memory")
+	})
+	if header < 0 {
 		return fmt.Errorf("failed to find template info header")
 	}
 
-	headerLine = strings.Trim(headerLine, "|")
-	headerFields := regexp.MustCompile(`\s+`).Split(strings.TrimSpace(headerLine), -1)
-	columnPositions := map[string]int{}
-	for i, field := range headerFields {
-		columnPositions[field] = i
-	}
-
-	// Skip the line immediately after the header
-	if scanner.Scan() {
-	}
-
-	// Skip until we reach a line containing "=="
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, "==") {
-			break
-		}
+	// The header ends at a line of "=", each of its lines names the columns
+	// of the corresponding line of a template.
+	columns := [][]tableField{tableFields(lines[header])}
+	body := header + 1
+	for ; body < len(lines) && !strings.Contains(lines[body], "=="); body++ {
+		columns = append(columns, tableFields(lines[body]))
 	}
 
 	var (
-		currentTemplate string
-		currentAttrs    *VnpuTemplateAttribute
+		current *VnpuTemplate
+		row     int
 	)
-	for scanner.Scan() {
-		line := strings.TrimSpace(strings.Trim(scanner.Text(), "|"))
-		if line == "" || strings.Contains(line, "--") {
+	for _, line := range lines[min(body+1, len(lines)):] {
+		fields := tableFields(line)
+		if len(fields) == 0 || strings.Contains(line, "--") {
+			current = nil
 			continue
 		}
-		fields := regexp.MustCompile(`\s+`).Split(line, -1)
-		if len(fields) > 0 && strings.HasPrefix(fields[0], "vir") {
-			currentTemplate = fields[0]
-			currentAttrs = &VnpuTemplateAttribute{}
-			for attr, pos := range columnPositions {
-				if attr == "Name" || pos >= len(fields) {
-					continue
-				}
-				valStr := fields[pos]
-				if attr == "Memory" {
-					valStr = strings.TrimSuffix(valStr, "GB")
-				}
-				val, err := strconv.Atoi(valStr)
-				if err != nil {
-					log.Printf("Warning: failed to parse %s value %s: %v", attr, fields[pos], err)
-					continue
-				}
-				switch attr {
-				case "AICORE":
-					currentAttrs.AICORE = val
-				case "Memory":
-					currentAttrs.Memory = val
-				}
-			}
-			templates[currentTemplate] = &VnpuTemplate{
-				Name:       currentTemplate,
-				Attributes: *currentAttrs,
+		if strings.HasPrefix(fields[0].text, "vir") {
+			current = &VnpuTemplate{Name: fields[0].text}
+			templates[current.Name] = current
+			row = 0
+			fields = fields[1:]
+		} else if current == nil || row+1 >= len(columns) {
+			continue
+		} else {
+			row++
+		}
+		for _, field := range fields {
+			column := nearestColumn(columns[row], field.start)
+			if err := setTemplateColumn(&current.Attributes, column, field.text); err != nil {
+				log.Printf("Warning: failed to parse %s value %s of template %s: %v", column, field.text, current.Name, err)
 			}
 		}
 	}
 	return nil
 }
 
+// tableField is a word of a line of an npu-smi table and where it starts.
+type tableField struct {
+	text  string
+	start int
+}
+
+var tableFieldPattern = regexp.MustCompile(`[^\s|]+`)
+
+// tableFields splits a line of an npu-smi table into its words, dropping the
+// cell borders.
+func tableFields(line string) []tableField {
+	var fields []tableField
+	for _, loc := range tableFieldPattern.FindAllStringIndex(line, -1) {
+		fields = append(fields, tableField{text: line[loc[0]:loc[1]], start: loc[0]})
+	}
+	return fields
+}
+
+// nearestColumn returns the name of the column starting closest to a value.
+func nearestColumn(columns []tableField, start int) string {
+	var name string
+	best := -1
+	for _, column := range columns {
+		if d := abs(column.start - start); best < 0 || d < best {
+			best = d
+			name = column.text
+		}
+	}
+	return name
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// setTemplateColumn sets the attribute of a template column. Columns the
+// driver does not know, such as the unit line of the memory, are ignored.
+func setTemplateColumn(attrs *VnpuTemplateAttribute, column, value string) error {
+	fields := map[string]*int{
+		"AICORE": &attrs.AICORE,
+		"Memory": &attrs.Memory,
+		"AICPU":  &attrs.AICPU,
+		"VPC":    &attrs.Media.VPC,
+		"VENC":   &attrs.Media.VENC,
+		"VDEC":   &attrs.Media.VDEC,
+		"JPEGD":  &attrs.Media.JPEGD,
+		"JPEGE":  &attrs.Media.JPEGE,
+		"PNGD":   &attrs.Media.PNGD,
+	}
+	field, ok := fields[column]
+	if !ok {
+		return nil
+	}
+	val, err := strconv.Atoi(strings.TrimSuffix(value, "GB"))
+	if err != nil {
+		return err
+	}
+	*field = val
+	return nil
+}
+
 // InitPhysicalNpu initializes a physical NPU, using the entire card as a default available slice.
-func (m *VnpuManager) InitPhysicalNpu(deviceName string, logicID int32, modelName string) {
+func (m *VnpuManager) InitPhysicalNpu(deviceName string, logicID int32, modelName string, topology NpuTopology, capacity ChipCapacity) {
 	m.Lock()
 	defer m.Unlock()
 
@@ -144,9 +177,11 @@ func (m *VnpuManager) InitPhysicalNpu(deviceName string, logicID int32, modelNam
 		PhysicalDeviceID: physicalDeviceID,
 		LogicID:          logicID,
 		ModelName:        modelName,
+		Topology:         topology,
+		Capacity:         capacity,
 		AvailableSlices:  []*VnpuSlice{},
 		AllocatedSlices:  []*VnpuSlice{},
-		SupportTemplates: cloneTemplates(m.Templates),
+		Templates:        cloneTemplates(m.Catalog.ForModel(modelName)),
 		NextSliceIndex:   1,
 	}
 
@@ -156,9 +191,10 @@ func (m *VnpuManager) InitPhysicalNpu(deviceName string, logicID int32, modelNam
 		Allocated:    false,
 		Type:         "NPU",
 	})
+	m.updateSupportTemplates(npu)
 	m.PhysicalNpus[deviceName] = npu
 
-	log.Printf("Physical NPU %s has been initialized.", deviceName)
+	log.Printf("Physical NPU %s has been initialized with %d templates.", deviceName, len(npu.Templates))
 }
 
 // ReleaseSlice releases the specified VNPU slice.
@@ -174,21 +210,16 @@ func (m *VnpuManager) ReleaseSlice(sliceID string) error {
 	pnpu.AllocatedSlices = append(pnpu.AllocatedSlices[:idx], pnpu.AllocatedSlices[idx+1:]...)
 	slice.Allocated = false
 
-	if slice.Type == "NPU" {
-		pnpu.AllocatedSlices = []*VnpuSlice{}
-		pnpu.AvailableSlices = []*VnpuSlice{}
-		pnpu.NextSliceIndex = 1
-		pnpu.AvailableSlices = append(pnpu.AvailableSlices, &VnpuSlice{
-			SliceID:      pnpu.DeviceName,
-			TemplateName: "",
-			Allocated:    false,
-			Type:         "NPU",
-		})
-		log.Printf("Successfully released the entire NPU card %s, restored to initial state", pnpu.DeviceName)
+	if slice.Adopted {
+		pnpu.AvailableSlices = append(pnpu.AvailableSlices, slice)
+		log.Printf("Released adopted vNPU slice %s, vNPU %d is kept on the card", sliceID, slice.VDevID)
 		return nil
 	}
+	slice.VDevID = 0
 
-	if len(pnpu.AllocatedSlices) == 0 {
+	// The card only goes back to full card state once no vNPU is left on it,
+	// adopted vNPUs stay on the card.
+	if len(pnpu.AllocatedSlices) == 0 && !hasAdoptedSlices(pnpu) {
 		pnpu.AvailableSlices = []*VnpuSlice{}
 		pnpu.NextSliceIndex = 1
 		pnpu.AvailableSlices = append(pnpu.AvailableSlices, &VnpuSlice{
@@ -199,40 +230,177 @@ func (m *VnpuManager) ReleaseSlice(sliceID string) error {
 		})
 		log.Printf("All vNPU slices released for device %s, restored to full card state", pnpu.DeviceName)
 	} else {
-		pnpu.AvailableSlices = []*VnpuSlice{}
-		newSliceID := fmt.Sprintf("npu-%d-%d", pnpu.LogicID, pnpu.NextSliceIndex)
-		newSlice := &VnpuSlice{
-			SliceID:      newSliceID,
-			TemplateName: "",
-			Allocated:    false,
-			Type:         "vNPU",
-		}
-		pnpu.AvailableSlices = append(pnpu.AvailableSlices, newSlice)
-		pnpu.NextSliceIndex++
-
-		if m.deviceUpdateCallback != nil {
-			m.deviceUpdateCallback(newSliceID, pnpu)
-		}
-		log.Printf("Released vNPU slice %s, created new available slice %s", sliceID, newSliceID)
+		log.Printf("Released vNPU slice %s", sliceID)
+		m.resetRemainder(pnpu)
 	}
 
 	m.updateSupportTemplates(pnpu)
 	return nil
 }
 
-// GetVnpuSpecsEnv returns the ASCEND_VNPU_SPECS environment variable for a given slice.
-func (m *VnpuManager) GetVnpuSpecsEnv(sliceID string) (string, error) {
+// GetAllocatedSlice returns a copy of an allocated slice together with the
+// logic ID of the physical NPU it belongs to.
+func (m *VnpuManager) GetAllocatedSlice(sliceID string) (VnpuSlice, int32, error) {
 	m.Lock()
 	defer m.Unlock()
 
-	_, _, slice, err := m.findAllocatedSlice(sliceID)
+	npu, _, slice, err := m.findAllocatedSlice(sliceID)
+	if err != nil {
+		return VnpuSlice{}, 0, err
+	}
+	return *slice, npu.LogicID, nil
+}
+
+// GetPreparedSlice returns the checkpoint record of an allocated slice.
+func (m *VnpuManager) GetPreparedSlice(sliceID string) (*PreparedSlice, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	npu, _, slice, err := m.findAllocatedSlice(sliceID)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return &PreparedSlice{
+		PhysicalDevice: npu.DeviceName,
+		SliceID:        slice.SliceID,
+		Type:           slice.Type,
+		TemplateName:   slice.TemplateName,
+	}, nil
+}
+
+// RestoreSlice marks a slice recorded in the checkpoint as allocated again,
+// together with the vNPU backing it, if any. RebuildAvailableSlices must be
+// called once all slices are restored.
+func (m *VnpuManager) RestoreSlice(record *PreparedSlice, vdev *PreparedVirtualDevice) error {
+	m.Lock()
+	defer m.Unlock()
+
+	npu, ok := m.PhysicalNpus[record.PhysicalDevice]
+	if !ok {
+		return fmt.Errorf("physical NPU not found: %s", record.PhysicalDevice)
+	}
+	if _, _, _, err := m.findAllocatedSlice(record.SliceID); err == nil {
+		return fmt.Errorf("slice %s is already allocated", record.SliceID)
+	}
+
+	npu.AvailableSlices = slices.DeleteFunc(npu.AvailableSlices, func(s *VnpuSlice) bool {
+		return s.SliceID == record.SliceID
+	})
+	slice := &VnpuSlice{
+		SliceID:      record.SliceID,
+		TemplateName: record.TemplateName,
+		Allocated:    true,
+		Type:         record.Type,
+	}
+	if vdev != nil {
+		slice.VDevID = vdev.VDevID
+		slice.Adopted = vdev.Adopted
+	}
+	npu.AllocatedSlices = append(npu.AllocatedSlices, slice)
+
+	var logicID int32
+	var index int
+	if _, err := fmt.Sscanf(record.SliceID, "npu-%d-%d", &logicID, &index); err == nil && index >= npu.NextSliceIndex {
+		npu.NextSliceIndex = index + 1
+	}
+	return nil
+}
+
+// RebuildAvailableSlices recomputes the available slices and supported
+// templates of every physical NPU that has restored allocations. A slice
+// allocated without a template takes everything that was left on the card,
+// otherwise the remaining resources are represented by a single new vNPU slice.
+func (m *VnpuManager) RebuildAvailableSlices() {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, npu := range m.PhysicalNpus {
+		if len(npu.AllocatedSlices) == 0 {
+			continue
+		}
+		npu.AvailableSlices = []*VnpuSlice{}
+		exhausted := slices.ContainsFunc(npu.AllocatedSlices, func(s *VnpuSlice) bool {
+			return s.TemplateName == ""
+		})
+		if exhausted {
+			m.updateSupportTemplates(npu)
+			continue
+		}
+		m.resetRemainder(npu)
+	}
+}
+
+// AdoptVnpu registers a vNPU found on the card at startup that no prepared
+// claim owns. It becomes an available slice carrying its template and vdev ID,
+// and the rest of the card is offered as a vNPU slice instead of the full card.
+func (m *VnpuManager) AdoptVnpu(deviceName string, vdevID uint32, templateName string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	npu, ok := m.PhysicalNpus[deviceName]
+	if !ok {
+		return "", fmt.Errorf("physical NPU not found: %s", deviceName)
+	}
+
+	// The rest of the card keeps the index following the full card.
+	remainderIndex := 0
+	if m.wholeCardIsAvailable(npu) {
+		npu.AvailableSlices = slices.DeleteFunc(npu.AvailableSlices, func(s *VnpuSlice) bool {
+			return s.SliceID == npu.DeviceName
+		})
+		remainderIndex = npu.NextSliceIndex
+		npu.NextSliceIndex++
+	}
+
+	sliceID := fmt.Sprintf("npu-%d-%d", npu.LogicID, npu.NextSliceIndex)
+	npu.AvailableSlices = append(npu.AvailableSlices, &VnpuSlice{
+		SliceID:      sliceID,
+		TemplateName: templateName,
+		Allocated:    false,
+		Type:         "vNPU",
+		VDevID:       vdevID,
+		Adopted:      true,
+	})
+	npu.NextSliceIndex++
+
+	// The vNPU takes its share of the rest of the card.
+	if remainderIndex != 0 {
+		m.addRemainderSlice(npu, remainderIndex)
+	} else {
+		m.resetRemainder(npu)
+	}
+	if m.deviceUpdateCallback != nil {
+		m.deviceUpdateCallback(sliceID, npu)
+	}
+	log.Printf("Adopted vNPU %d (template: %s) on %s as slice %s", vdevID, templateName, deviceName, sliceID)
+	return sliceID, nil
+}
+
+// HasVnpu reports whether a vNPU is already tracked by a slice of the card.
+func (m *VnpuManager) HasVnpu(deviceName string, vdevID uint32) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	npu, ok := m.PhysicalNpus[deviceName]
+	if !ok {
+		return false
 	}
-	if slice.TemplateName == "" {
-		return "", nil
+	return slices.ContainsFunc(slices.Concat(npu.AvailableSlices, npu.AllocatedSlices), func(s *VnpuSlice) bool {
+		return s.VDevID == vdevID
+	})
+}
+
+// SetSliceVDevID records the ID of the vNPU created for an allocated slice.
+func (m *VnpuManager) SetSliceVDevID(sliceID string, vdevID uint32) error {
+	m.Lock()
+	defer m.Unlock()
+
+	_, _, slice, err := m.findAllocatedSlice(sliceID)
+	if err != nil {
+		return err
 	}
-	return slice.TemplateName, nil
+	slice.VDevID = vdevID
+	return nil
 }
 
 // findAllocatedSlice is a helper method to locate an allocated slice by its ID.
@@ -247,6 +415,39 @@ func (m *VnpuManager) findAllocatedSlice(sliceID string) (*PhysicalNpuState, int
 	return nil, -1, nil, fmt.Errorf("VNPU slice %s not found", sliceID)
 }
 
+// findPhysicalNpu locates the physical NPU that owns a device, which is either
+// the full card itself or one of its available slices.
+func (m *VnpuManager) findPhysicalNpu(deviceName string) (*PhysicalNpuState, bool) {
+	if npu, ok := m.PhysicalNpus[deviceName]; ok {
+		return npu, true
+	}
+	for _, npu := range m.PhysicalNpus {
+		for _, s := range npu.AvailableSlices {
+			if s.SliceID == deviceName {
+				return npu, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// findAvailableSlice locates an available slice of the card by its ID.
+func findAvailableSlice(npu *PhysicalNpuState, sliceID string) *VnpuSlice {
+	for _, s := range npu.AvailableSlices {
+		if s.SliceID == sliceID {
+			return s
+		}
+	}
+	return nil
+}
+
+// hasAdoptedSlices checks if any vNPU adopted at startup lives on the card.
+func hasAdoptedSlices(npu *PhysicalNpuState) bool {
+	return slices.ContainsFunc(slices.Concat(npu.AvailableSlices, npu.AllocatedSlices), func(s *VnpuSlice) bool {
+		return s.Adopted
+	})
+}
+
 // wholeCardIsAvailable checks if the entire card slice is in the available slices.
 func (m *VnpuManager) wholeCardIsAvailable(npu *PhysicalNpuState) bool {
 	for _, s := range npu.AvailableSlices {
@@ -288,23 +489,52 @@ func (d *driver) getAvailableDeviceNames() []string {
 	return deviceNames
 }
 
-// updateSupportTemplates updates the set of templates supported by the physical NPU.
+// updateSupportTemplates keeps the templates of the physical NPU that still
+// fit into what is left of it.
 func (m *VnpuManager) updateSupportTemplates(npu *PhysicalNpuState) {
-	// If no slices are allocated, support all templates.
-	if len(npu.AllocatedSlices) == 0 {
-		npu.SupportTemplates = cloneTemplates(m.Templates)
-		return
-	}
-	// Otherwise, filter templates as needed.
+	remaining := remainingCapacity(npu)
 	npu.SupportTemplates = make(map[string]*VnpuTemplate)
-	for name, tpl := range m.Templates {
-		// For example, keep only "vir01" when any slice is allocated.
-		if strings.HasPrefix(name, "vir01") {
-			npu.SupportTemplates[name] = tpl
+	for name, tpl := range npu.Templates {
+		if remaining.fits(templateCapacity(tpl), npu.Capacity) {
+			copied := *tpl
+			npu.SupportTemplates[name] = &copied
 		}
 	}
 }
 
+// resetRemainder replaces the slice offering the rest of the card with one
+// sized to what is left now. The new slice gets a new ID, so that it is
+// published afresh.
+func (m *VnpuManager) resetRemainder(npu *PhysicalNpuState) {
+	npu.AvailableSlices = slices.DeleteFunc(npu.AvailableSlices, func(s *VnpuSlice) bool {
+		return s.Type == "vNPU" && s.TemplateName == ""
+	})
+	index := npu.NextSliceIndex
+	npu.NextSliceIndex++
+	m.addRemainderSlice(npu, index)
+}
+
+// addRemainderSlice offers the rest of the card as an available vNPU slice,
+// unless no template fits into it anymore.
+func (m *VnpuManager) addRemainderSlice(npu *PhysicalNpuState, index int) {
+	m.updateSupportTemplates(npu)
+	if len(npu.SupportTemplates) == 0 {
+		log.Printf("No vNPU template fits into what is left of %s", npu.DeviceName)
+		return
+	}
+	sliceID := fmt.Sprintf("npu-%d-%d", npu.LogicID, index)
+	npu.AvailableSlices = append(npu.AvailableSlices, &VnpuSlice{
+		SliceID:      sliceID,
+		TemplateName: "",
+		Allocated:    false,
+		Type:         "vNPU",
+	})
+	if m.deviceUpdateCallback != nil {
+		m.deviceUpdateCallback(sliceID, npu)
+	}
+	log.Printf("Created available slice %s representing the remaining resources of %s", sliceID, npu.DeviceName)
+}
+
 // cloneTemplates performs a shallow copy of the templates.
 func cloneTemplates(src map[string]*VnpuTemplate) map[string]*VnpuTemplate {
 	dst := make(map[string]*VnpuTemplate, len(src))
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/tools/cache"
+	drav1beta1 "k8s.io/dynamic-resource-allocation/api/v1beta1"
+	drav1beta2 "k8s.io/dynamic-resource-allocation/api/v1beta2"
+	"k8s.io/klog/v2"
+
+	configapi "Ascend-dra-driver/api/example.com/resource/gpu/v1alpha2"
+	"Ascend-dra-driver/pkg/common"
+)
+
+// scheme knows all versions of the resource.k8s.io API the webhook may be
+// called with and the conversions from the older ones to v1.
+var scheme = runtime.NewScheme()
+
+var codecs serializer.CodecFactory
+
+func init() {
+	utilruntime.Must(resourceapi.AddToScheme(scheme))
+	utilruntime.Must(drav1beta1.AddToScheme(scheme))
+	utilruntime.Must(drav1beta2.AddToScheme(scheme))
+	codecs = serializer.NewCodecFactory(scheme)
+}
+
+// Validator validates the opaque configs for the driver in ResourceClaims,
+// ResourceClaimTemplates and DeviceClasses.
+type Validator struct {
+	// resourceSlices holds the ResourceSlices the vNPU templates configs may
+	// refer to are taken from.
+	resourceSlices cache.Store
+}
+
+// NewValidator returns a Validator that accepts only the vNPU templates
+// published by the devices of the driver in the given ResourceSlices.
+func NewValidator(resourceSlices cache.Store) *Validator {
+	return &Validator{resourceSlices: resourceSlices}
+}
+
+// publishedTemplates returns the names of the vNPU templates the kubelet
+// plugins publish as device attributes, over all chip models.
+func (v *Validator) publishedTemplates() map[string]bool {
+	templates := make(map[string]bool)
+	for _, obj := range v.resourceSlices.List() {
+		slice, ok := obj.(*resourceapi.ResourceSlice)
+		if !ok || slice.Spec.Driver != DriverName {
+			continue
+		}
+		for _, device := range slice.Spec.Devices {
+			for name := range device.Attributes {
+				if templateName, ok := strings.CutPrefix(string(name), DriverDomain+common.TemplateAttributePrefix); ok {
+					templates[templateName] = true
+				}
+			}
+		}
+	}
+	return templates
+}
+
+// Admit admits an object if all opaque configs for the driver in it are valid.
+func (v *Validator) Admit(request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if request.Resource.Group != resourceapi.GroupName {
+		return deny(http.StatusBadRequest, metav1.StatusReasonBadRequest,
+			fmt.Sprintf("unexpected resource %s", request.Resource))
+	}
+
+	var configs []resourceapi.DeviceConfiguration
+	var fldPath *field.Path
+	switch request.Resource.Resource {
+	case "resourceclaims":
+		claim := &resourceapi.ResourceClaim{}
+		if err := decodeObject(request.Object.Raw, claim); err != nil {
+			return deny(http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		}
+		configs = claimConfigs(claim.Spec.Devices.Config)
+		fldPath = field.NewPath("spec", "devices", "config")
+	case "resourceclaimtemplates":
+		template := &resourceapi.ResourceClaimTemplate{}
+		if err := decodeObject(request.Object.Raw, template); err != nil {
+			return deny(http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		}
+		configs = claimConfigs(template.Spec.Spec.Devices.Config)
+		fldPath = field.NewPath("spec", "spec", "devices", "config")
+	case "deviceclasses":
+		class := &resourceapi.DeviceClass{}
+		if err := decodeObject(request.Object.Raw, class); err != nil {
+			return deny(http.StatusBadRequest, metav1.StatusReasonBadRequest, err.Error())
+		}
+		for _, config := range class.Spec.Config {
+			configs = append(configs, config.DeviceConfiguration)
+		}
+		fldPath = field.NewPath("spec", "config")
+	default:
+		return deny(http.StatusBadRequest, metav1.StatusReasonBadRequest,
+			fmt.Sprintf("unexpected resource %s", request.Resource))
+	}
+
+	if errs := v.validateConfigs(configs, fldPath); len(errs) > 0 {
+		klog.Infof("Rejected %s %s/%s: %v", request.Resource.Resource, request.Namespace, request.Name, errs.ToAggregate())
+		return deny(http.StatusUnprocessableEntity, metav1.StatusReasonInvalid, errs.ToAggregate().Error())
+	}
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+// decodeObject decodes an object of any supported version of the
+// resource.k8s.io API into its v1 version.
+func decodeObject(raw []byte, into runtime.Object) error {
+	decoder := codecs.UniversalDecoder(resourceapi.SchemeGroupVersion)
+	if _, _, err := decoder.Decode(raw, nil, into); err != nil {
+		return fmt.Errorf("failed to decode object: %v", err)
+	}
+	return nil
+}
+
+func claimConfigs(configs []resourceapi.DeviceClaimConfiguration) []resourceapi.DeviceConfiguration {
+	var result []resourceapi.DeviceConfiguration
+	for _, config := range configs {
+		result = append(result, config.DeviceConfiguration)
+	}
+	return result
+}
+
+// validateConfigs decodes, normalizes and validates the opaque configs for the
+// driver the same way the kubelet plugin does when preparing a claim. Configs
+// for other drivers are skipped. vNPU templates must be published by at least
+// one device.
+func (v *Validator) validateConfigs(configs []resourceapi.DeviceConfiguration, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	var templates map[string]bool
+	for i, config := range configs {
+		if config.Opaque == nil || config.Opaque.Driver != DriverName {
+			continue
+		}
+		paramsPath := fldPath.Index(i).Child("opaque", "parameters")
+
+		decoded, err := runtime.Decode(configapi.Decoder, config.Opaque.Parameters.Raw)
+		if err != nil {
+			errs = append(errs, field.Invalid(paramsPath, "<omitted>", fmt.Sprintf("error decoding config parameters: %v", err)))
+			continue
+		}
+		npuConfig, err := configapi.ToNpuConfig(decoded)
+		if err != nil {
+			errs = append(errs, field.Invalid(paramsPath, "<omitted>", err.Error()))
+			continue
+		}
+		if err := npuConfig.Normalize(); err != nil {
+			errs = append(errs, field.Invalid(paramsPath, "<omitted>", fmt.Sprintf("error normalizing NPU config: %v", err)))
+			continue
+		}
+		if err := npuConfig.Validate(); err != nil {
+			errs = append(errs, field.Invalid(paramsPath, "<omitted>", fmt.Sprintf("error validating NPU config: %v", err)))
+			continue
+		}
+		if npuConfig.Vnpu == nil || npuConfig.Vnpu.TemplateName == "" {
+			continue
+		}
+		if templates == nil {
+			templates = v.publishedTemplates()
+		}
+		if !templates[npuConfig.Vnpu.TemplateName] {
+			errs = append(errs, field.NotSupported(paramsPath.Child("vnpu", "templateName"), npuConfig.Vnpu.TemplateName, slices.Sorted(maps.Keys(templates))))
+		}
+	}
+	return errs
+}
+
+func deny(code int32, reason metav1.StatusReason, message string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    code,
+			Reason:  reason,
+			Message: message,
+		},
+	}
+}
@@ -0,0 +1,215 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	vir02Config = `{"apiVersion": "gpu.resource.example.com/v1alpha2", "kind": "NpuConfig", "vnpu": {"templateName": "vir02"}}`
+	vir99Config = `{"apiVersion": "gpu.resource.example.com/v1alpha2", "kind": "NpuConfig", "vnpu": {"templateName": "vir99"}}`
+)
+
+func claimObject(version, driver, parameters string) string {
+	return fmt.Sprintf(`{"apiVersion": "resource.k8s.io/%s", "kind": "ResourceClaim",
+		"metadata": {"name": "claim", "namespace": "default"},
+		"spec": {"devices": {"config": [{"opaque": {"driver": %q, "parameters": %s}}]}}}`,
+		version, driver, parameters)
+}
+
+// newResourceSlice returns a ResourceSlice of a driver with a device publishing
+// the given vNPU templates.
+func newResourceSlice(name, driver string, templates ...string) *resourceapi.ResourceSlice {
+	attributes := map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{
+		DriverDomain + "model": {StringValue: ptr.To("310P3")},
+	}
+	for _, template := range templates {
+		attributes[resourceapi.QualifiedName(DriverDomain+"vnpu_"+template)] = resourceapi.DeviceAttribute{StringValue: ptr.To("aicore=1,memoryGiB=3")}
+	}
+	return &resourceapi.ResourceSlice{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: resourceapi.ResourceSliceSpec{
+			Driver:  driver,
+			Devices: []resourceapi.Device{{Name: "npu-0-0", Attributes: attributes}},
+		},
+	}
+}
+
+func newResourceSliceStore(t *testing.T, slices ...*resourceapi.ResourceSlice) cache.Store {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, slice := range slices {
+		require.NoError(t, store.Add(slice))
+	}
+	return store
+}
+
+func TestAdmit(t *testing.T) {
+	tests := map[string]struct {
+		resource string
+		version  string
+		object   string
+		allowed  bool
+		message  string
+	}{
+		"valid claim": {
+			resource: "resourceclaims",
+			version:  "v1",
+			object:   claimObject("v1", DriverName, vir02Config),
+			allowed:  true,
+		},
+		"v1beta1 claim with GpuConfig": {
+			resource: "resourceclaims",
+			version:  "v1beta1",
+			object: claimObject("v1beta1", DriverName,
+				`{"apiVersion": "gpu.resource.example.com/v1alpha1", "kind": "GpuConfig", "vnpuSpec": {"templateName": "vir02"}}`),
+			allowed: true,
+		},
+		"unknown template": {
+			resource: "resourceclaims",
+			version:  "v1beta2",
+			object:   claimObject("v1beta2", DriverName, vir99Config),
+			message:  `spec.devices.config[0].opaque.parameters.vnpu.templateName: Unsupported value: "vir99"`,
+		},
+		"unknown field": {
+			resource: "resourceclaims",
+			version:  "v1",
+			object: claimObject("v1", DriverName,
+				`{"apiVersion": "gpu.resource.example.com/v1alpha2", "kind": "NpuConfig", "vnpuSpec": {"templateName": "vir02"}}`),
+			message: `unknown field "vnpuSpec"`,
+		},
+		"config of another driver": {
+			resource: "resourceclaims",
+			version:  "v1",
+			object:   claimObject("v1", "gpu.example.com", `{"anything": true}`),
+			allowed:  true,
+		},
+		"invalid claim template": {
+			resource: "resourceclaimtemplates",
+			version:  "v1",
+			object: `{"apiVersion": "resource.k8s.io/v1", "kind": "ResourceClaimTemplate",
+				"metadata": {"name": "template", "namespace": "default"},
+				"spec": {"spec": {"devices": {"config": [{"opaque": {"driver": "npu.example.com",
+				"parameters": {"apiVersion": "gpu.resource.example.com/v1alpha2", "kind": "NpuConfig", "vnpu": {}}}}]}}}}`,
+			message: "spec.spec.devices.config[0].opaque.parameters: Invalid value: \"<omitted>\": error validating NPU config: vNPU template name or resources are required",
+		},
+		"invalid device class": {
+			resource: "deviceclasses",
+			version:  "v1",
+			object: `{"apiVersion": "resource.k8s.io/v1", "kind": "DeviceClass", "metadata": {"name": "class"},
+				"spec": {"config": [{"opaque": {"driver": "npu.example.com",
+				"parameters": {"apiVersion": "gpu.resource.example.com/v1alpha2", "kind": "NpuConfig", "sharing": {"mode": "TimeSlicing"}}}}]}}`,
+			message: "unknown NPU sharing mode: TimeSlicing",
+		},
+	}
+
+	store := newResourceSliceStore(t,
+		newResourceSlice("node-1", DriverName, "vir01", "vir02"),
+		newResourceSlice("node-2", DriverName, "vir04"))
+	server := httptest.NewServer(newMux(NewValidator(store), func() bool { return true }))
+	defer server.Close()
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			review := admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+				Request: &admissionv1.AdmissionRequest{
+					UID:       types.UID("uid-1"),
+					Resource:  metav1.GroupVersionResource{Group: "resource.k8s.io", Version: test.version, Resource: test.resource},
+					Operation: admissionv1.Create,
+					Object:    runtime.RawExtension{Raw: []byte(test.object)},
+				},
+			}
+			body, err := json.Marshal(review)
+			require.NoError(t, err)
+
+			response, err := http.Post(server.URL+ValidatePath, "application/json", bytes.NewReader(body))
+			require.NoError(t, err)
+			defer response.Body.Close()
+			require.Equal(t, http.StatusOK, response.StatusCode)
+			result := &admissionv1.AdmissionReview{}
+			require.NoError(t, json.NewDecoder(response.Body).Decode(result))
+
+			require.NotNil(t, result.Response)
+			assert.Equal(t, types.UID("uid-1"), result.Response.UID)
+			assert.Equal(t, test.allowed, result.Response.Allowed)
+			if !test.allowed {
+				require.NotNil(t, result.Response.Result)
+				assert.Contains(t, result.Response.Result.Message, test.message)
+			}
+		})
+	}
+}
+
+func TestAdmitPublishedTemplates(t *testing.T) {
+	tests := map[string]struct {
+		slices  []*resourceapi.ResourceSlice
+		allowed bool
+	}{
+		"published template": {
+			slices:  []*resourceapi.ResourceSlice{newResourceSlice("node-1", DriverName, "vir01", "vir99")},
+			allowed: true,
+		},
+		"template of another driver": {
+			slices: []*resourceapi.ResourceSlice{newResourceSlice("node-1", "gpu.example.com", "vir99")},
+		},
+		"no ResourceSlices": {},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			request := &admissionv1.AdmissionRequest{
+				Resource: metav1.GroupVersionResource{Group: "resource.k8s.io", Version: "v1", Resource: "resourceclaims"},
+				Object:   runtime.RawExtension{Raw: []byte(claimObject("v1", DriverName, vir99Config))},
+			}
+			validator := NewValidator(newResourceSliceStore(t, test.slices...))
+			assert.Equal(t, test.allowed, validator.Admit(request).Allowed)
+		})
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	var synced atomic.Bool
+	server := httptest.NewServer(newMux(NewValidator(newResourceSliceStore(t)), synced.Load))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + ReadyzPath)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+
+	synced.Store(true)
+	response, err = http.Get(server.URL + ReadyzPath)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
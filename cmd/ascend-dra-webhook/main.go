@@ -0,0 +1,210 @@
+/*
+ * Copyright 2025 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	cgoresource "k8s.io/client-go/kubernetes/typed/resource/v1"
+	"k8s.io/client-go/tools/cache"
+	draclient "k8s.io/dynamic-resource-allocation/client"
+	"k8s.io/klog/v2"
+
+	"Ascend-dra-driver/pkg/flags"
+)
+
+const (
+	DriverName   = "npu.example.com"
+	DriverDomain = "npu.example.com/"
+
+	ValidatePath = "/validate-resource-claim-parameters"
+	ReadyzPath   = "/readyz"
+)
+
+type Flags struct {
+	kubeClientConfig flags.KubeClientConfig
+	loggingConfig    *flags.LoggingConfig
+
+	certFile string
+	keyFile  string
+	port     int
+}
+
+func main() {
+	if err := newApp().Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func newApp() *cli.App {
+	flags := &Flags{
+		loggingConfig: flags.NewLoggingConfig(),
+	}
+	cliFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "tls-cert-file",
+			Usage:       "File containing the x509 certificate for HTTPS.",
+			Required:    true,
+			Destination: &flags.certFile,
+			EnvVars:     []string{"TLS_CERT_FILE"},
+		},
+		&cli.StringFlag{
+			Name:        "tls-private-key-file",
+			Usage:       "File containing the x509 private key matching --tls-cert-file.",
+			Required:    true,
+			Destination: &flags.keyFile,
+			EnvVars:     []string{"TLS_PRIVATE_KEY_FILE"},
+		},
+		&cli.IntFlag{
+			Name:        "port",
+			Usage:       "Secure port that the webhook listens on.",
+			Value:       443,
+			Destination: &flags.port,
+			EnvVars:     []string{"PORT"},
+		},
+	}
+	cliFlags = append(cliFlags, flags.kubeClientConfig.Flags()...)
+	cliFlags = append(cliFlags, flags.loggingConfig.Flags()...)
+
+	app := &cli.App{
+		Name:  "ascend-dra-webhook",
+		Usage: "ascend-dra-webhook validates the opaque NPU configs of ResourceClaims, ResourceClaimTemplates and DeviceClasses.",
+		Flags: cliFlags,
+		Before: func(c *cli.Context) error {
+			return flags.loggingConfig.Apply()
+		},
+		Action: func(c *cli.Context) error {
+			ctx, cancel := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+			clientSets, err := flags.kubeClientConfig.NewClientSets()
+			if err != nil {
+				return fmt.Errorf("create client: %v", err)
+			}
+
+			informer := newResourceSliceInformer(draclient.New(clientSets.Core))
+			go informer.RunWithContext(ctx)
+
+			server := &http.Server{
+				Addr:    fmt.Sprintf(":%d", flags.port),
+				Handler: newMux(NewValidator(informer.GetStore()), informer.HasSynced),
+			}
+			go func() {
+				<-ctx.Done()
+				if err := server.Shutdown(context.Background()); err != nil {
+					klog.Errorf("Failed to shut down webhook server: %v", err)
+				}
+			}()
+			klog.Infof("Starting webhook server on %s", server.Addr)
+			if err := server.ListenAndServeTLS(flags.certFile, flags.keyFile); err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+	}
+
+	return app
+}
+
+// newResourceSliceInformer returns an informer for the ResourceSlices of the
+// driver, whose devices publish the vNPU templates of their chips. It goes
+// through a client that converts from older versions of the resource.k8s.io
+// API.
+func newResourceSliceInformer(client cgoresource.ResourceV1Interface) cache.SharedIndexInformer {
+	selector := fields.OneTermEqualSelector("spec.driver", DriverName).String()
+	return cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return client.ResourceSlices().List(ctx, options)
+		},
+		WatchFuncWithContext: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return client.ResourceSlices().Watch(ctx, options)
+		},
+	}, &resourceapi.ResourceSlice{}, 0, cache.Indexers{})
+}
+
+// newMux serves the admission requests. The webhook only reports ready once
+// the ResourceSlices are synced, as vNPU templates are checked against them.
+func newMux(validator *Validator, synced cache.InformerSynced) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ValidatePath, func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, validator.Admit)
+	})
+	mux.HandleFunc(ReadyzPath, func(w http.ResponseWriter, r *http.Request) {
+		if !synced() {
+			http.Error(w, "ResourceSlices not synced yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// serve handles the HTTP part of an admission request and passes the
+// AdmissionReview on to admit.
+func serve(w http.ResponseWriter, r *http.Request, admit func(*admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse) {
+	if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+		http.Error(w, fmt.Sprintf("unsupported content type %s, expected application/json", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode AdmissionReview: %v", err), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "AdmissionReview without request", http.StatusBadRequest)
+		return
+	}
+
+	response := admit(review.Request)
+	response.UID = review.Request.UID
+	review.Response = response
+	review.Request = nil
+
+	out, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode AdmissionReview: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(out); err != nil {
+		klog.Errorf("Failed to write admission response: %v", err)
+	}
+}
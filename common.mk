@@ -12,7 +12,7 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
-GOLANG_VERSION ?= 1.23.1
+GOLANG_VERSION ?= 1.24.0
 
 DRIVER_NAME := ascend-dra-driver
 MODULE := .
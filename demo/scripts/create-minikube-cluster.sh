@@ -17,8 +17,8 @@ minikube start \
   --profile="${MINIKUBE_PROFILE_NAME}" \
   --driver=docker \
   --container-runtime=containerd \
-  --feature-gates=DynamicResourceAllocation=true \
-  --extra-config=apiserver.runtime-config=resource.k8s.io/v1beta1=true \
+  --kubernetes-version=v1.34.4 \
+  --feature-gates=DynamicResourceAllocation=true,DRADeviceTaints=true \
   --extra-config=apiserver.v=1 \
   --extra-config=controller-manager.v=1 \
   --extra-config=scheduler.v=1 \
@@ -15,8 +15,31 @@
 // Package common a series of common function
 package common
 
+import "math"
+
 const (
 	MaxVirtualDeviceNum = 1024
+	// DefaultIDForCreateVNPU lets dcmi choose the vdev and vfg id when creating a vNPU
+	DefaultIDForCreateVNPU = math.MaxUint32
+	// VirtualDevicePathPrefix device node prefix of a vNPU, followed by its vdev id
+	VirtualDevicePathPrefix = "/dev/vdavinci"
+	// DevicePathPrefix device node prefix of a physical NPU, followed by its phy id
+	DevicePathPrefix = "/dev/davinci"
+	// DavinciManagerPath device node of the davinci manager shared by all NPUs
+	DavinciManagerPath = "/dev/davinci_manager"
+	// DevmmSvmPath device node of the shared virtual memory manager
+	DevmmSvmPath = "/dev/devmm_svm"
+	// HisiHdcPath device node of the host-device communication channel
+	HisiHdcPath = "/dev/hisi_hdc"
+)
+
+const (
+	// RankTableStatusCompleted status of a rank table listing every device
+	RankTableStatusCompleted = "completed"
+	// RankTableEnv environment variable pointing HCCL at the rank table
+	RankTableEnv = "RANK_TABLE_FILE"
+	// RankTableContainerPath path of the rank table in the container, as used by the Ascend operators
+	RankTableContainerPath = "/user/serverid/devindex/config/hccl.json"
 )
 
 const (
@@ -65,6 +88,47 @@ const (
 	MinAICoreNum = 8
 )
 
+// Device health states reported by the dcmi interface
+const (
+	// HealthStateNormal the device works normally
+	HealthStateNormal = 0
+	// HealthStateGeneralAlarm the device reported a minor alarm and is still usable
+	HealthStateGeneralAlarm = 1
+	// HealthStateImportantAlarm the device reported a major alarm
+	HealthStateImportantAlarm = 2
+	// HealthStateUrgentAlarm the device reported a critical alarm
+	HealthStateUrgentAlarm = 3
+)
+
+// Device health published in the ResourceSlice
+const (
+	// HealthyState the device works normally
+	HealthyState = "Healthy"
+	// WarningState the device reported a minor alarm and can still be allocated
+	WarningState = "Warning"
+	// UnHealthyState the device is faulty and must not be allocated
+	UnHealthyState = "Unhealthy"
+)
+
+// Fault handling classes of the dcmi error codes, as used by the faultCode.json
+// file of the Ascend device plugin
+const (
+	// NotHandleFault the fault needs no handling
+	NotHandleFault = "NotHandleFault"
+	// RestartRequest the request running on the chip must be retried
+	RestartRequest = "RestartRequest"
+	// RestartBusiness the workload running on the chip must be restarted
+	RestartBusiness = "RestartBusiness"
+	// FreeRestartNPU the chip must be reset once it is free
+	FreeRestartNPU = "FreeRestartNPU"
+	// RestartNPU the chip must be reset
+	RestartNPU = "RestartNPU"
+	// PreSeparateNPU the chip must be isolated once it is free
+	PreSeparateNPU = "PreSeparateNPU"
+	// SeparateNPU the chip must be isolated
+	SeparateNPU = "SeparateNPU"
+)
+
 // Special scene for invoking the dcmi interface
 const (
 	DeviceNotSupport = 8255
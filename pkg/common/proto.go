@@ -52,6 +52,12 @@ type NpuDevice struct {
 	LogicID    int32
 	PhyID      int32
 	CardID     int32
+	// ChipName chip model the device belongs to, e.g. 310P3
+	ChipName string
+	// VDevID id of the virtual device, 0 for a physical device
+	VDevID uint32
+	// TemplateName template the virtual device was created with
+	TemplateName string
 }
 
 // DavinCiDev davinci device
@@ -74,6 +80,21 @@ type Instance struct { // Instance
 	Devices  []Device `json:"devices"`   // dev
 }
 
+// RankTable hccl.json rank table read by HCCL through RANK_TABLE_FILE
+type RankTable struct {
+	Status     string      `json:"status"`      // completed once every device is listed
+	GroupCount string      `json:"group_count"` // number of groups
+	GroupList  []RankGroup `json:"group_list"`  // groups
+}
+
+// RankGroup group of instances in a rank table
+type RankGroup struct {
+	GroupName     string     `json:"group_name"`     // group name
+	DeviceCount   string     `json:"device_count"`   // number of devices in the group
+	InstanceCount string     `json:"instance_count"` // number of instances in the group
+	InstanceList  []Instance `json:"instance_list"`  // instances
+}
+
 // Option option
 type Option struct {
 	GetFdFlag          bool     // to describe FdFlag
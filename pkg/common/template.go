@@ -0,0 +1,84 @@
+/* Copyright(C) 2022. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package common a series of common function
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TemplateAttributePrefix prefixes the device attributes under which the kubelet plugin
+// publishes the vNPU templates supported by the chip of a device, one attribute per
+// template, e.g. vnpu_vir02="aicore=2,memoryGiB=6,aicpu=2". The controller builds the
+// DeviceClasses from them. The media engines of the templates are not part of the
+// value, which is limited to 64 characters.
+const TemplateAttributePrefix = "vnpu_"
+
+// maxAttributeIDLength maximum length of the name of a device attribute without domain
+const maxAttributeIDLength = 32
+
+// TemplateResources resources of a vNPU template as published in a template attribute
+type TemplateResources struct {
+	AICore    int
+	MemoryGiB int
+	AICPU     int
+}
+
+// TemplateAttributeID returns the attribute name of a template, or false if the
+// template name does not fit into an attribute name.
+func TemplateAttributeID(templateName string) (string, bool) {
+	id := TemplateAttributePrefix + templateName
+	if len(id) > maxAttributeIDLength {
+		return "", false
+	}
+	return id, true
+}
+
+// String formats the resources as the value of a template attribute. The AICPUs
+// are left out for chips that do not report them.
+func (r TemplateResources) String() string {
+	value := fmt.Sprintf("aicore=%d,memoryGiB=%d", r.AICore, r.MemoryGiB)
+	if r.AICPU > 0 {
+		value += fmt.Sprintf(",aicpu=%d", r.AICPU)
+	}
+	return value
+}
+
+// ParseTemplateResources parses the value of a template attribute. Unknown keys are
+// ignored so that newer plugins may publish more resources.
+func ParseTemplateResources(value string) (TemplateResources, error) {
+	var resources TemplateResources
+	for _, field := range strings.Split(value, ",") {
+		key, number, ok := strings.Cut(field, "=")
+		if !ok {
+			return resources, fmt.Errorf("invalid template resource %q", field)
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return resources, fmt.Errorf("invalid template resource %q: %v", field, err)
+		}
+		switch key {
+		case "aicore":
+			resources.AICore = n
+		case "memoryGiB":
+			resources.MemoryGiB = n
+		case "aicpu":
+			resources.AICPU = n
+		}
+	}
+	return resources, nil
+}
@@ -30,7 +30,7 @@ import (
 )
 
 type LoggingConfig struct {
-	featureGate featuregate.MutableFeatureGate
+	featureGate featuregate.MutableVersionedFeatureGate
 	config      *logsapi.LoggingConfiguration
 }
 